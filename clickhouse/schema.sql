@@ -1,20 +1,134 @@
+-- Current ClickHouse schema, for reference and manual installs.
+--
+-- Servers are created and upgraded by the versioned migrations in
+-- collector/migrate/migrations (`dnsdist-collector migrate`, run by
+-- install.sh). Schema changes go there as a new migration; update this
+-- file to match.
+--
+-- The TTLs below are the initial ones. The migrate command replaces them
+-- with clickhouse.retention from the collector config (defaults: raw rows
+-- 30 days, rollups 12 months).
+
 CREATE TABLE IF NOT EXISTS dns.dns_logs
 (
-  `timestamp` DateTime,
+  `timestamp` DateTime64(6),
   `client_ip` IPv6,
   `qname` LowCardinality(String),
   `qtype` UInt16,
   `response_type` Enum8('CQ' = 1, 'CR' = 2),
   `response_size` UInt32,
   `rcode` UInt8,
+  `opcode` UInt8,
+  `flags` UInt16,
+  `parse_error` LowCardinality(String),
+  `socket_protocol` LowCardinality(String),
+  `socket_family` LowCardinality(String),
+  `query_port` UInt16,
+  `server_identity` LowCardinality(String),
+  `server_version` LowCardinality(String),
+  `edns_present` Bool,
+  `edns_udp_size` UInt16,
+  `edns_do` Bool,
+  `edns_cookie` Bool,
+  `edns_padding` Bool,
+  `ecs_source_prefix` UInt8,
+  `ecs_subnet` String,
+  `response_timestamp` DateTime64(6) DEFAULT toDateTime64(0, 6),
+  `latency_us` UInt32 DEFAULT 0,
+  `paired` Bool DEFAULT response_timestamp != toDateTime64(0, 6),
+  `sample_weight` Float32 DEFAULT 1,
+  `anonymized` Enum8('none' = 0, 'truncate' = 1, 'hmac' = 2) DEFAULT 'none',
+  `answer_types` Array(UInt16),
+  `answer_ttls` Array(UInt32),
+  `answer_data` Array(String),
   INDEX idx_qname qname TYPE bloom_filter GRANULARITY 4,
-  INDEX idx_client client_ip TYPE minmax GRANULARITY 4
+  INDEX idx_client client_ip TYPE minmax GRANULARITY 4,
+  INDEX idx_answer_data answer_data TYPE bloom_filter GRANULARITY 4
 )
 ENGINE = MergeTree
 PARTITION BY toYYYYMMDD(timestamp)
 ORDER BY (toStartOfHour(timestamp), client_ip, qname)
-TTL timestamp + INTERVAL 30 DAY
+TTL toDateTime(timestamp) + INTERVAL 30 DAY
 SETTINGS index_granularity = 8192;
 
-ALTER TABLE IF EXISTS dns.dns_logs
-MODIFY TTL timestamp + INTERVAL 30 DAY;
+-- Dashboard rollups
+-- Filled by materialized views on every insert into dns_logs. They keep a
+-- year of trends while raw rows expire after 30 days. Counts are
+-- sum(sample_weight), so collector sampling is already scaled back up.
+
+-- Per-minute counts by response type, qtype and rcode
+CREATE TABLE IF NOT EXISTS dns.dns_stats_1m
+(
+  `minute` DateTime,
+  `response_type` Enum8('CQ' = 1, 'CR' = 2),
+  `paired` UInt8,
+  `qtype` UInt16,
+  `rcode` UInt8,
+  `queries` Float64,
+  `latency_us_sum` UInt64,
+  `latency_count` UInt64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(minute)
+ORDER BY (minute, response_type, paired, qtype, rcode)
+TTL minute + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dns.dns_stats_1m_mv TO dns.dns_stats_1m AS
+SELECT
+  toStartOfMinute(timestamp) AS minute,
+  response_type,
+  toUInt8(paired) AS paired,
+  qtype,
+  rcode,
+  sum(sample_weight) AS queries,
+  sum(toUInt64(latency_us)) AS latency_us_sum,
+  countIf(paired) AS latency_count
+FROM dns.dns_logs
+GROUP BY minute, response_type, paired, qtype, rcode;
+
+-- Per-hour top domains and clients (client queries only) as topKWeighted
+-- states: 300 counters per hour, merged over any range into the top 100
+-- with approximate counts. Weights are sample_weight in hundredths, since
+-- topKWeighted only takes integers. Read with
+-- topKWeightedMerge(100, 3, 'counts'), which returns (value, weight, error).
+CREATE TABLE IF NOT EXISTS dns.dns_top_1h
+(
+  `hour` DateTime,
+  `domains` AggregateFunction(topKWeighted(100, 3, 'counts'), String, UInt64),
+  `clients` AggregateFunction(topKWeighted(100, 3, 'counts'), IPv6, UInt64)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY hour
+TTL hour + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dns.dns_top_1h_mv TO dns.dns_top_1h AS
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  topKWeightedState(100, 3, 'counts')(toString(qname), toUInt64(round(sample_weight * 100))) AS domains,
+  topKWeightedState(100, 3, 'counts')(client_ip, toUInt64(round(sample_weight * 100))) AS clients
+FROM dns.dns_logs
+WHERE response_type = 'CQ'
+GROUP BY hour;
+
+-- Per-hour distinct clients and domains (client queries only). Read with
+-- uniqMerge, which also combines hours into a day.
+CREATE TABLE IF NOT EXISTS dns.dns_uniques_1h
+(
+  `hour` DateTime,
+  `clients` AggregateFunction(uniq, IPv6),
+  `domains` AggregateFunction(uniq, String)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY hour
+TTL hour + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dns.dns_uniques_1h_mv TO dns.dns_uniques_1h AS
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  uniqState(client_ip) AS clients,
+  uniqState(qname) AS domains
+FROM dns.dns_logs
+WHERE response_type = 'CQ'
+GROUP BY hour;
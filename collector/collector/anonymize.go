@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// Anonymization modes, also stored in the anonymized column.
+const (
+	AnonymizeNone     = "none"
+	AnonymizeTruncate = "truncate"
+	AnonymizeHMAC     = "hmac"
+)
+
+// maxPseudonyms bounds the per-output pseudonym cache; it is cleared when
+// full.
+const maxPseudonyms = 65536
+
+// Anonymizer rewrites client addresses before a row reaches a sink.
+//
+// truncate keeps the first IPv4Bits / IPv6Bits of the address. hmac replaces
+// the address with a pseudonym in fd00::/8: the second byte identifies the
+// key that made it and the remaining 112 bits are HMAC-SHA256(key, address),
+// so the same client keeps the same pseudonym until the key changes. With a
+// Rotate period the key is derived anew from the secret every period, which
+// makes pseudonyms from different periods unlinkable. In both modes the ECS
+// subnet is truncated to the same prefix lengths and the client port is
+// zeroed, since address prefix and source port together can single out a
+// client behind NAT.
+//
+// An Anonymizer is used by one Fanout loop only and is not safe for
+// concurrent use; reloading swaps in a new one.
+type Anonymizer struct {
+	Mode     string
+	IPv4Bits int
+	IPv6Bits int
+	Rotate   time.Duration // hmac: 0 keeps one key
+
+	secret []byte
+	epoch  int64  // rotation period of key
+	key    []byte // current HMAC key
+	keyID  byte
+	cache  map[netip.Addr]string
+}
+
+// NewAnonymizer creates an Anonymizer. secret is only used in hmac mode.
+func NewAnonymizer(mode string, ipv4Bits, ipv6Bits int, secret []byte, rotate time.Duration) *Anonymizer {
+	return &Anonymizer{
+		Mode:     mode,
+		IPv4Bits: ipv4Bits,
+		IPv6Bits: ipv6Bits,
+		Rotate:   rotate,
+		secret:   secret,
+		epoch:    -1,
+	}
+}
+
+// Apply anonymizes row in place. A nil Anonymizer leaves it alone.
+func (a *Anonymizer) Apply(row *model.DNSLog) {
+	if a == nil || a.Mode == AnonymizeNone {
+		return
+	}
+	row.Anonymized = a.Mode
+	row.QueryPort = 0
+	if row.ECSSubnet != "" {
+		if p, err := netip.ParsePrefix(row.ECSSubnet); err == nil {
+			bits := min(p.Bits(), a.bits(p.Addr()))
+			row.ECSSubnet = netip.PrefixFrom(p.Addr(), bits).Masked().String()
+			row.ECSSourcePrefix = uint8(bits)
+		}
+	}
+
+	addr, err := netip.ParseAddr(row.ClientIP)
+	if err != nil {
+		row.ClientIP = "::"
+		return
+	}
+	switch a.Mode {
+	case AnonymizeTruncate:
+		row.ClientIP = a.truncate(addr)
+	case AnonymizeHMAC:
+		row.ClientIP = a.pseudonym(addr)
+	}
+}
+
+// bits returns the prefix length kept for addr.
+func (a *Anonymizer) bits(addr netip.Addr) int {
+	if addr.Is4() || addr.Is4In6() {
+		return a.IPv4Bits
+	}
+	return a.IPv6Bits
+}
+
+// truncate masks addr, keeping IPv4-mapped addresses in the ::ffff:a.b.c.d
+// form the collector writes.
+func (a *Anonymizer) truncate(addr netip.Addr) string {
+	if addr.Is4In6() {
+		p := netip.PrefixFrom(addr.Unmap(), a.IPv4Bits).Masked()
+		return "::ffff:" + p.Addr().String()
+	}
+	return netip.PrefixFrom(addr, a.bits(addr)).Masked().Addr().String()
+}
+
+func (a *Anonymizer) pseudonym(addr netip.Addr) string {
+	a.rotate(time.Now())
+	addr = netip.AddrFrom16(addr.As16()) // IPv4 and IPv4-mapped alike
+	if p, ok := a.cache[addr]; ok {
+		return p
+	}
+
+	mac := hmac.New(sha256.New, a.key)
+	b := addr.As16()
+	mac.Write(b[:])
+	var out [16]byte
+	out[0], out[1] = 0xfd, a.keyID
+	copy(out[2:], mac.Sum(nil))
+	p := netip.AddrFrom16(out).String()
+
+	if len(a.cache) >= maxPseudonyms {
+		clear(a.cache)
+	}
+	a.cache[addr] = p
+	return p
+}
+
+// rotate derives the key for the period containing now.
+func (a *Anonymizer) rotate(now time.Time) {
+	var epoch int64
+	if a.Rotate > 0 {
+		epoch = now.UnixNano() / int64(a.Rotate)
+	}
+	if epoch == a.epoch {
+		return
+	}
+
+	a.epoch = epoch
+	a.key = a.secret
+	if a.Rotate > 0 {
+		mac := hmac.New(sha256.New, a.secret)
+		binary.Write(mac, binary.BigEndian, epoch)
+		a.key = mac.Sum(nil)
+	}
+	id := sha256.Sum256(a.key)
+	a.keyID = id[0]
+	a.cache = make(map[netip.Addr]string)
+}
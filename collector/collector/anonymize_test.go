@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"testing"
+
+	"dnsdist-collector/model"
+)
+
+func TestAnonymizerApply(t *testing.T) {
+	for _, mode := range []string{AnonymizeTruncate, AnonymizeHMAC} {
+		a := NewAnonymizer(mode, 24, 48, []byte("0123456789abcdef"), 0)
+		row := model.DNSLog{ClientIP: "::ffff:192.0.2.77", QueryPort: 53412, ECSSubnet: "198.51.100.128/25", ECSSourcePrefix: 25}
+		a.Apply(&row)
+
+		if row.QueryPort != 0 {
+			t.Errorf("%s: query_port = %d, want 0", mode, row.QueryPort)
+		}
+		if row.ECSSubnet != "198.51.100.0/24" || row.ECSSourcePrefix != 24 {
+			t.Errorf("%s: ecs = %s (/%d), want 198.51.100.0/24", mode, row.ECSSubnet, row.ECSSourcePrefix)
+		}
+		if row.Anonymized != mode {
+			t.Errorf("%s: anonymized = %q", mode, row.Anonymized)
+		}
+		if mode == AnonymizeTruncate && row.ClientIP != "::ffff:192.0.2.0" {
+			t.Errorf("truncate: client_ip = %s, want ::ffff:192.0.2.0", row.ClientIP)
+		}
+		if mode == AnonymizeHMAC && row.ClientIP[:2] != "fd" {
+			t.Errorf("hmac: client_ip = %s, want a pseudonym in fd00::/8", row.ClientIP)
+		}
+	}
+
+	// Without anonymization the port stays.
+	row := model.DNSLog{ClientIP: "::ffff:192.0.2.77", QueryPort: 53412}
+	NewAnonymizer(AnonymizeNone, 24, 48, nil, 0).Apply(&row)
+	if row.QueryPort != 53412 || row.ClientIP != "::ffff:192.0.2.77" {
+		t.Errorf("none: row changed to %+v", row)
+	}
+}
@@ -2,38 +2,135 @@ package collector
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	neturl "net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"dnsdist-collector/metrics"
 	"dnsdist-collector/model"
 )
 
+// ClickHouseOptions are the connection settings shared by the HTTP and
+// native writers. Zero values connect as the default user, without TLS, to
+// dns.dns_logs.
+type ClickHouseOptions struct {
+	Username string
+	Password string
+	Database string
+	Table    string
+	TLS      *tls.Config // nil for plain HTTP / TCP
+}
+
+func (o ClickHouseOptions) database() string {
+	if o.Database == "" {
+		return "dns"
+	}
+	return o.Database
+}
+
+func (o ClickHouseOptions) table() string {
+	if o.Table == "" {
+		return "dns_logs"
+	}
+	return o.Table
+}
+
 type ClickHouseWriter struct {
 	URL           string
+	Username      string
+	Password      string
 	LogChan       <-chan model.DNSLog
 	BatchSize     int
 	FlushInterval time.Duration
 	Done          chan struct{}
 	Client        *http.Client
+
+	// RetryAttempts is how often a batch is tried before it is dropped
+	// when there is no spool. Spooled batches are replayed with exponential
+	// backoff between ReplayMinBackoff and ReplayMaxBackoff instead.
+	RetryAttempts    int
+	ReplayMinBackoff time.Duration
+	ReplayMaxBackoff time.Duration
+
+	// Workers is the number of concurrent encode/insert senders and
+	// MaxInFlight the number of batches that may be queued or sending at
+	// once (at least Workers).
+	Workers     int
+	MaxInFlight int
+
+	// Native, if set, replaces the HTTP JSONEachRow insert with columnar
+	// blocks over the native protocol. Spooled batches stay NDJSON either way.
+	Native *NativeInserter
+
+	// Spool receives batches that ClickHouse rejected or could not be
+	// reached for. If nil, failed batches are dropped after RetryAttempts.
+	Spool *Spool
+	// DroppedRows counts rows lost because there was no spool to fall back to
+	// (or the spool itself failed).
+	DroppedRows atomic.Uint64
+
+	// Counters exported on /metrics
+	BatchesSent    atomic.Uint64
+	BatchesFailed  atomic.Uint64 // failed insert attempts, including retries
+	BatchesDropped atomic.Uint64
+	RowsInserted   atomic.Uint64
+	EarlyFlushes   atomic.Uint64 // partial batches sent because LogChan was filling
+	Stalls         atomic.Uint64 // times the batcher waited for a free in-flight slot
+	InsertLatency  *metrics.Histogram
+
+	inFlight atomic.Int64
+	reconfig chan batching
+	down     atomic.Bool // spool without trying until replay gets through
+}
+
+// batching is a batch size / flush interval change sent to a running Worker.
+type batching struct {
+	size     int
+	interval time.Duration
 }
 
-func NewClickHouseWriter(httpAddr string, logChan <-chan model.DNSLog) (*ClickHouseWriter, error) {
-	// httpAddr must be "ip:8123"
+func NewClickHouseWriter(httpAddr string, opts ClickHouseOptions, logChan <-chan model.DNSLog) (*ClickHouseWriter, error) {
+	// httpAddr must be "ip:8123" (or the HTTPS port, usually 8443, with TLS)
 	// async_insert=1: ClickHouse buffers small inserts and merges them
 	// wait_for_async_insert=0: Don't wait for insert confirmation (faster)
-	url := fmt.Sprintf("http://%s/?query=INSERT+INTO+dns.dns_logs+FORMAT+JSONEachRow&async_insert=1&wait_for_async_insert=0", httpAddr)
+	scheme := "http"
+	if opts.TLS != nil {
+		scheme = "https"
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", quoteIdent(opts.database()), quoteIdent(opts.table()))
+	url := fmt.Sprintf("%s://%s/?query=%s&async_insert=1&wait_for_async_insert=0", scheme, httpAddr, neturl.QueryEscape(query))
 
 	return &ClickHouseWriter{
 		URL:           url,
+		Username:      opts.Username,
+		Password:      opts.Password,
 		LogChan:       logChan,
 		BatchSize:     50000,
 		FlushInterval: 5 * time.Second, // Increased: let more logs accumulate
 		Done:          make(chan struct{}),
+		Workers:       4,
+		MaxInFlight:   8,
+		// 1 quick retry then drop (without spool)
+		RetryAttempts:    2,
+		ReplayMinBackoff: 500 * time.Millisecond,
+		ReplayMaxBackoff: 60 * time.Second,
+		reconfig:         make(chan batching, 1),
+		InsertLatency: metrics.NewHistogram(
+			"dnsdist_collector_insert_duration_seconds",
+			"Latency of ClickHouse insert requests.",
+			[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		),
 		Client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
@@ -42,71 +139,395 @@ func NewClickHouseWriter(httpAddr string, logChan <-chan model.DNSLog) (*ClickHo
 				IdleConnTimeout:     30 * time.Second,
 				DisableKeepAlives:   false,
 				DisableCompression:  true,
+				TLSClientConfig:     opts.TLS,
 			},
 		},
 	}, nil
 }
 
+// Worker batches rows from LogChan and hands each batch to one of Workers
+// senders, which encode and insert in parallel. At most MaxInFlight batches
+// are queued or being sent; when all are taken the batcher waits, LogChan
+// fills and rows are dropped upstream (counted per sink) instead of piling
+// up in memory.
+//
+// When LogChan is closed the last partial batch is queued, every sender
+// finishes its batch and the spool replayer stops before Done is closed.
 func (w *ClickHouseWriter) Worker() {
 	defer close(w.Done)
 
-	batch := make([]model.DNSLog, 0, w.BatchSize)
-	ticker := time.NewTicker(w.FlushInterval)
-	defer ticker.Stop()
+	if w.Spool != nil {
+		stop := make(chan struct{})
+		replayDone := make(chan struct{})
+		go w.replay(stop, replayDone)
+		defer func() {
+			// Pending segments stay on disk and are replayed on next start.
+			close(stop)
+			<-replayDone
+		}()
+	}
 
-	flush := func() {
-		if len(batch) == 0 {
-			return
-		}
+	workers := max(w.Workers, 1)
+	maxInFlight := max(w.MaxInFlight, workers)
 
-		// 1 quick retry (short jitter) then drop to avoid long blocking
-		if err := w.sendBatch(batch); err != nil {
-			j := time.Duration(100+rand.Intn(200)) * time.Millisecond
-			time.Sleep(j)
+	// Batches being sent occupy a sender, the rest wait in queue.
+	queue := make(chan []model.DNSLog, maxInFlight-workers)
+	free := make(chan []model.DNSLog, maxInFlight+1)
 
-			if err2 := w.sendBatch(batch); err2 != nil {
-				log.Printf("ClickHouse insert failed (dropping %d rows): %v", len(batch), err2)
+	var senders sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for batch := range queue {
+				w.flush(batch)
+				w.inFlight.Add(-1)
+				select {
+				case free <- batch[:0]:
+				default:
+				}
 			}
+		}()
+	}
+
+	newBatch := func() []model.DNSLog {
+		select {
+		case b := <-free:
+			return b
+		default:
+			return make([]model.DNSLog, 0, w.BatchSize)
 		}
+	}
+	batch := newBatch()
 
-		batch = batch[:0]
+	dispatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.inFlight.Add(1)
+		select {
+		case queue <- batch:
+		default:
+			// Every in-flight slot is taken: this is the backpressure point.
+			w.Stalls.Add(1)
+			queue <- batch
+		}
+		batch = newBatch()
 	}
 
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case item, ok := <-w.LogChan:
 			if !ok {
-				// Channel closed: final flush then exit
-				flush()
+				// Channel closed: queue the rest and wait for the senders
+				dispatch()
+				close(queue)
+				senders.Wait()
 				return
 			}
 			batch = append(batch, item)
 			if len(batch) >= w.BatchSize {
-				flush()
+				dispatch()
+			} else if w.flushEarly(len(batch), workers) {
+				w.EarlyFlushes.Add(1)
+				dispatch()
 			}
 
 		case <-ticker.C:
-			flush()
+			dispatch()
+
+		case b := <-w.reconfig:
+			w.BatchSize = b.size
+			w.FlushInterval = b.interval
+			ticker.Reset(b.interval)
+			if len(batch) >= w.BatchSize {
+				dispatch()
+			}
+		}
+	}
+}
+
+// flushEarly reports whether a partial batch of n rows should be sent now:
+// LogChan is more than half full while a sender is idle, so waiting for a
+// full batch would only risk drops. Tiny batches are still held back to
+// keep the insert rate reasonable for ClickHouse.
+func (w *ClickHouseWriter) flushEarly(n, workers int) bool {
+	if n < max(w.BatchSize/earlyFlushDivisor, 1) || int(w.inFlight.Load()) >= workers {
+		return false
+	}
+	return cap(w.LogChan) > 0 && len(w.LogChan) >= cap(w.LogChan)/2
+}
+
+// earlyFlushDivisor sets the smallest early batch to BatchSize/16.
+const earlyFlushDivisor = 16
+
+// InFlight returns the number of batches queued or being sent.
+func (w *ClickHouseWriter) InFlight() int64 {
+	return w.inFlight.Load()
+}
+
+// flush inserts one batch, spooling or dropping it on failure.
+//
+// With a spool, senders keep inserting directly while replay drains older
+// spooled batches in parallel, so a backlog does not funnel all traffic
+// through the single replay goroutine. Only while ClickHouse is down (the
+// last insert failed and replay has not got one through since) do senders
+// spool without trying, which keeps them from each waiting for a timeout;
+// that fallback is bounded by the spool limits. Rows are therefore not
+// inserted in arrival order: spooled batches are replayed oldest first
+// among themselves, but newer live batches may land before them. Nothing
+// depends on insert order, as every table and rollup is keyed by the row
+// timestamp.
+func (w *ClickHouseWriter) flush(batch []model.DNSLog) {
+	if w.Spool != nil {
+		if !w.down.Load() {
+			err := w.insert(batch)
+			if err == nil {
+				return
+			}
+			if !permanent(err) {
+				w.down.Store(true)
+			}
+			log.Printf("ClickHouse insert failed (spooling %d rows): %v", len(batch), err)
+		}
+		body, err := encodeBatch(batch)
+		if err != nil {
+			log.Printf("ClickHouse batch encode failed (dropping %d rows): %v", len(batch), err)
+			w.dropBatch(len(batch))
+			return
+		}
+		if err := w.Spool.Put(body, len(batch)); err != nil {
+			log.Printf("Spool write failed (dropping %d rows): %v", len(batch), err)
+			w.dropBatch(len(batch))
 		}
+		return
+	}
+
+	// Quick retries (short jitter) then drop to avoid long blocking
+	err := w.insert(batch)
+	for attempt := 1; err != nil && !permanent(err) && attempt < w.RetryAttempts; attempt++ {
+		j := time.Duration(100+rand.Intn(200)) * time.Millisecond
+		time.Sleep(j)
+		err = w.insert(batch)
+	}
+	if err != nil {
+		log.Printf("ClickHouse insert failed (dropping %d rows): %v", len(batch), err)
+		w.dropBatch(len(batch))
 	}
 }
 
-func (w *ClickHouseWriter) sendBatch(logs []model.DNSLog) error {
+// SetBatching changes the batch size and flush interval of a running
+// Worker. The latest call wins if the Worker has not picked up the
+// previous one yet.
+func (w *ClickHouseWriter) SetBatching(size int, interval time.Duration) {
+	b := batching{size: size, interval: interval}
+	for {
+		select {
+		case w.reconfig <- b:
+			return
+		default:
+		}
+		select {
+		case <-w.reconfig:
+		default:
+		}
+	}
+}
+
+// replay drains the spool oldest first, backing off exponentially while
+// ClickHouse keeps failing. Its first success after a failure lets the
+// senders insert directly again (see flush). A segment that ClickHouse
+// rejects permanently (see permanent) is moved to the dead letter
+// directory instead of blocking the segments behind it forever.
+func (w *ClickHouseWriter) replay(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	backoff := w.ReplayMinBackoff
+	for {
+		seg, body, ok := w.Spool.Peek()
+		if !ok {
+			select {
+			case <-w.Spool.Ready():
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		if err := w.insertBody(body, seg.rows); err != nil {
+			if errors.Is(err, errBadSegment) {
+				log.Printf("Discarding unreadable spool segment (%d rows): %v", seg.rows, err)
+				w.Spool.Remove(seg)
+				w.dropBatch(seg.rows)
+				continue
+			}
+			if permanent(err) {
+				if dlErr := w.Spool.DeadLetter(seg, err); dlErr != nil {
+					log.Printf("Spool: cannot dead-letter %s: %v", seg.name, dlErr)
+				} else {
+					log.Printf("ClickHouse rejected spooled batch, moved %s (%d rows) to %s: %v",
+						seg.name, seg.rows, filepath.Join(w.Spool.Dir, deadLetterDir), err)
+					continue
+				}
+			}
+			w.down.Store(true)
+			log.Printf("Spool replay failed (%d rows pending in %d batches, retry in %s): %v",
+				seg.rows, w.Spool.Len(), backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			backoff *= 2
+			if backoff > w.ReplayMaxBackoff {
+				backoff = w.ReplayMaxBackoff
+			}
+			continue
+		}
+
+		w.Spool.Remove(seg)
+		w.down.Store(false)
+		backoff = w.ReplayMinBackoff
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// errBadSegment marks a spooled body that can no longer be decoded; it is
+// discarded instead of being retried forever.
+var errBadSegment = errors.New("bad spool segment")
+
+// errBadRows marks a batch the native protocol cannot encode, e.g. a row
+// with an unparsable timestamp. Every attempt fails the same way.
+var errBadRows = errors.New("rows cannot be encoded")
+
+// statusError is a non-200 reply of the ClickHouse HTTP interface.
+type statusError struct {
+	Status string
+	Code   int
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("clickhouse status=%s body=%q", e.Status, e.Body)
+}
+
+// permanent reports whether retrying the same body cannot fix err: a
+// ClickHouse reply with a 4xx status other than 408 (timeout) and 429 (too
+// many requests), e.g. a parse error or an unknown table, or errBadRows.
+// Everything else, including 5xx and network errors, is transient.
+func permanent(err error) bool {
+	if errors.Is(err, errBadRows) {
+		return true
+	}
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.Code >= 400 && se.Code < 500 &&
+		se.Code != http.StatusRequestTimeout && se.Code != http.StatusTooManyRequests
+}
+
+// insert sends a batch over the configured protocol and records the outcome
+// in the writer counters.
+func (w *ClickHouseWriter) insert(logs []model.DNSLog) error {
+	if w.Native != nil {
+		return w.record(len(logs), func() error { return w.Native.Insert(logs) })
+	}
+
+	body, err := encodeBatch(logs)
+	if err != nil {
+		return err
+	}
+	return w.post(body, len(logs))
+}
+
+// insertBody sends a spooled NDJSON body over the configured protocol.
+func (w *ClickHouseWriter) insertBody(body []byte, rows int) error {
+	if w.Native != nil {
+		logs, err := decodeBatch(body)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadSegment, err)
+		}
+		return w.record(rows, func() error { return w.Native.Insert(logs) })
+	}
+	return w.post(body, rows)
+}
+
+// encodeBatch renders rows as NDJSON, the body format of JSONEachRow.
+func encodeBatch(logs []model.DNSLog) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 
 	// JSONEachRow expects one JSON object per line (NDJSON)
 	for _, l := range logs {
 		if err := enc.Encode(l); err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return buf.Bytes(), nil
+}
+
+// decodeBatch parses an NDJSON body produced by encodeBatch.
+func decodeBatch(body []byte) ([]model.DNSLog, error) {
+	var logs []model.DNSLog
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var l model.DNSLog
+		if err := dec.Decode(&l); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// quoteIdent quotes a database or table name for use in a query.
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (w *ClickHouseWriter) dropBatch(rows int) {
+	w.BatchesDropped.Add(1)
+	w.DroppedRows.Add(uint64(rows))
+}
+
+// post sends one NDJSON body and records the outcome in the writer counters.
+func (w *ClickHouseWriter) post(body []byte, rows int) error {
+	return w.record(rows, func() error { return w.doPost(body) })
+}
+
+// record times one insert attempt and updates the writer counters.
+func (w *ClickHouseWriter) record(rows int, do func() error) error {
+	start := time.Now()
+	err := do()
+	w.InsertLatency.Observe(time.Since(start).Seconds())
 
-	req, err := http.NewRequest("POST", w.URL, &buf)
+	if err != nil {
+		w.BatchesFailed.Add(1)
+		return err
+	}
+	w.BatchesSent.Add(1)
+	w.RowsInserted.Add(uint64(rows))
+	return nil
+}
+
+func (w *ClickHouseWriter) doPost(body []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
 
 	resp, err := w.Client.Do(req)
 	if err != nil {
@@ -116,7 +537,7 @@ func (w *ClickHouseWriter) sendBatch(logs []model.DNSLog) error {
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return fmt.Errorf("clickhouse status=%s body=%q", resp.Status, string(b))
+		return &statusError{Status: resp.Status, Code: resp.StatusCode, Body: string(b)}
 	}
 
 	return nil
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+func TestPermanent(t *testing.T) {
+	for code, want := range map[int]bool{
+		400: true, 401: true, 404: true, 413: true,
+		408: false, 429: false, 500: false, 503: false,
+	} {
+		if got := permanent(&statusError{Code: code}); got != want {
+			t.Errorf("permanent(%d) = %v, want %v", code, got, want)
+		}
+	}
+	if permanent(io.ErrUnexpectedEOF) {
+		t.Error("network errors must be transient")
+	}
+	if !permanent(fmt.Errorf("%w: row 0: timestamp: bad", errBadRows)) {
+		t.Error("rows the native block cannot hold must be permanent")
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	var unavailable atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "bad"):
+			http.Error(w, "Code: 27. DB::Exception: Cannot parse input", http.StatusBadRequest)
+		case unavailable.Add(1) <= 2:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	spool, err := NewSpool(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := NewClickHouseWriter(strings.TrimPrefix(srv.URL, "http://"), ClickHouseOptions{}, nil)
+	w.Spool = spool
+	w.ReplayMinBackoff = time.Millisecond
+	w.ReplayMaxBackoff = 5 * time.Millisecond
+
+	// The rejected segment is first and must not hold up the second one.
+	if err := spool.Put([]byte("{\"bad\":1}\n"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Put([]byte("{\"qname\":\"a\"}\n{\"qname\":\"b\"}\n"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go w.replay(stop, done)
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	if n := spool.Len(); n != 0 {
+		t.Fatalf("%d segments left in the spool", n)
+	}
+	if got := w.RowsInserted.Load(); got != 2 {
+		t.Errorf("RowsInserted = %d, want 2", got)
+	}
+	if got := spool.DeadLetterRows.Load(); got != 1 {
+		t.Errorf("DeadLetterRows = %d, want 1", got)
+	}
+	dead, _ := filepath.Glob(filepath.Join(spool.Dir, deadLetterDir, "*"+spoolSuffix))
+	if len(dead) != 1 {
+		t.Fatalf("dead-letter directory holds %v, want the rejected segment", dead)
+	}
+	reason, _ := os.ReadFile(strings.TrimSuffix(dead[0], spoolSuffix) + ".err")
+	if !strings.Contains(string(reason), "Cannot parse input") {
+		t.Errorf(".err file = %q, want the ClickHouse reply", reason)
+	}
+
+	// A restart must not pick the dead-lettered segment up again.
+	reopened, err := NewSpool(spool.Dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reopened.Len(); n != 0 {
+		t.Errorf("reopened spool has %d segments, want 0", n)
+	}
+}
+
+func TestReplayDeadLetterNative(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := NewClickHouseWriter("127.0.0.1:1", ClickHouseOptions{}, nil)
+	w.Spool = spool
+	// Nothing listens there: the row must be refused before any dial.
+	w.Native = NewNativeInserter("127.0.0.1:1", ClickHouseOptions{})
+	w.ReplayMinBackoff = time.Millisecond
+	w.ReplayMaxBackoff = 5 * time.Millisecond
+
+	if err := spool.Put([]byte(`{"timestamp":"yesterday","client_ip":"192.0.2.1"}`+"\n"), 1); err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go w.replay(stop, done)
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	if n := spool.Len(); n != 0 {
+		t.Fatalf("%d segments left in the spool, want the unencodable one dead-lettered", n)
+	}
+	dead, _ := filepath.Glob(filepath.Join(spool.Dir, deadLetterDir, "*"+spoolSuffix))
+	if len(dead) != 1 || spool.DeadLetterRows.Load() != 1 {
+		t.Fatalf("dead-letter directory holds %v (%d rows), want the segment", dead, spool.DeadLetterRows.Load())
+	}
+	reason, _ := os.ReadFile(strings.TrimSuffix(dead[0], spoolSuffix) + ".err")
+	if !strings.Contains(string(reason), "timestamp") {
+		t.Errorf(".err file = %q, want the encoding error", reason)
+	}
+}
+
+func TestFlushBypassesBacklog(t *testing.T) {
+	var requests, fail atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() != 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	spool, err := NewSpool(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := NewClickHouseWriter(strings.TrimPrefix(srv.URL, "http://"), ClickHouseOptions{}, nil)
+	w.Spool = spool
+	batch := []model.DNSLog{{QName: "example.com"}}
+
+	// A backlog alone does not send live batches to the spool.
+	if err := spool.Put([]byte("{}\n"), 1); err != nil {
+		t.Fatal(err)
+	}
+	w.flush(batch)
+	if got := w.RowsInserted.Load(); got != 1 || spool.Len() != 1 {
+		t.Fatalf("RowsInserted = %d, spool = %d; want a direct insert", got, spool.Len())
+	}
+
+	// After a failure senders spool without trying ClickHouse ...
+	fail.Store(1)
+	w.flush(batch)
+	w.flush(batch)
+	if got := requests.Load(); got != 2 {
+		t.Errorf("%d requests, want 2: no insert attempts while down", got)
+	}
+	if n := spool.Len(); n != 3 {
+		t.Fatalf("spool = %d segments, want 3", n)
+	}
+
+	// ... until replay gets a batch through.
+	fail.Store(0)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go w.replay(stop, done)
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if w.down.Load() {
+		t.Fatal("writer still down after a successful replay")
+	}
+}
@@ -1,10 +1,14 @@
 package collector
 
 import (
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,41 +20,127 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-// ClickHouse DateTime format
-const chDateTimeFormat = "2006-01-02 15:04:05"
+// ClickHouse DateTime64(6) format
+const chDateTimeFormat = "2006-01-02 15:04:05.000000"
 
-// DnsTapListener listens on a Unix socket for dnstap frames.
+// Stop gives open connections this long to drain buffered frames.
+const stopDrainTimeout = 2 * time.Second
+
+// DnsTapListener accepts framestream connections carrying dnstap frames on a
+// Unix socket, a TCP address or a TLS-wrapped TCP address.
 type DnsTapListener struct {
-	SocketPath string
-	LogChan    chan<- model.DNSLog
-	Dropped    atomic.Uint64
-	listener   net.Listener
-	wg         sync.WaitGroup
+	Network   string // "unix", "tcp" or "tls"
+	Address   string // socket path or host:port
+	TLSConfig *tls.Config
+	LogChan   chan<- model.DNSLog
+	Dropped   atomic.Uint64
+
+	// Pairer, if set, merges queries with their responses. It may be
+	// shared between listeners.
+	Pairer *Pairer
+	// ParseAnswers enables answer section extraction for CLIENT_RESPONSE
+	// messages (up to MaxAnswers records per response).
+	ParseAnswers bool
+	MaxAnswers   int
+
+	// Counters exported on /metrics
+	FramesDecoded     atomic.Uint64
+	UnmarshalFailures atomic.Uint64
+	ParseFailures     atomic.Uint64
+	AnswerFailures    atomic.Uint64
+	EDNSFailures      atomic.Uint64
+	ActiveConns       atomic.Int64
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
 }
 
-// NewDnsTapListener creates a new listener.
-func NewDnsTapListener(socketPath string, logChan chan<- model.DNSLog) *DnsTapListener {
+// NewDnsTapListener creates a new listener for a listen address of the form
+// unix:///path/to/socket, tcp://host:port or tls://host:port. A bare path is
+// treated as a Unix socket.
+func NewDnsTapListener(listenAddr string, logChan chan<- model.DNSLog) (*DnsTapListener, error) {
+	network, address, err := ParseListenAddr(listenAddr)
+	if err != nil {
+		return nil, err
+	}
 	return &DnsTapListener{
-		SocketPath: socketPath,
-		LogChan:    logChan,
+		Network: network,
+		Address: address,
+		LogChan: logChan,
+		conns:   make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ParseListenAddr splits a listen address into network and address.
+func ParseListenAddr(listenAddr string) (network, address string, err error) {
+	scheme, rest, found := strings.Cut(listenAddr, "://")
+	if !found {
+		if listenAddr == "" {
+			return "", "", fmt.Errorf("empty listen address")
+		}
+		return "unix", listenAddr, nil
+	}
+
+	switch scheme {
+	case "unix":
+		if rest == "" {
+			return "", "", fmt.Errorf("missing socket path in %q", listenAddr)
+		}
+		return "unix", rest, nil
+	case "tcp", "tls":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return "", "", fmt.Errorf("invalid address in %q: %w", listenAddr, err)
+		}
+		return scheme, rest, nil
+	default:
+		return "", "", fmt.Errorf("unsupported listen scheme %q (want unix, tcp or tls)", scheme)
 	}
 }
 
-// Start begins listening on the socket.
-func (l *DnsTapListener) Start() error {
-	// Clean up old socket if exists
-	_ = os.Remove(l.SocketPath)
+// String returns the listen address in URL form.
+func (l *DnsTapListener) String() string {
+	return l.Network + "://" + l.Address
+}
 
+// Start begins listening on the configured address.
+func (l *DnsTapListener) Start() error {
 	var err error
-	l.listener, err = net.Listen("unix", l.SocketPath)
-	if err != nil {
-		return fmt.Errorf("failed to listen on socket %s: %w", l.SocketPath, err)
-	}
+	switch l.Network {
+	case "unix":
+		// Clean up old socket if exists
+		_ = os.Remove(l.Address)
 
-	// Prefer secure perms (group-based). Adjust with systemd User/Group.
-	if err := os.Chmod(l.SocketPath, 0660); err != nil {
-		_ = l.listener.Close()
-		return fmt.Errorf("failed to chmod socket: %w", err)
+		l.listener, err = net.Listen("unix", l.Address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on socket %s: %w", l.Address, err)
+		}
+
+		// Prefer secure perms (group-based). Adjust with systemd User/Group.
+		if err := os.Chmod(l.Address, 0660); err != nil {
+			_ = l.listener.Close()
+			return fmt.Errorf("failed to chmod socket: %w", err)
+		}
+
+	case "tcp":
+		l.listener, err = net.Listen("tcp", l.Address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", l, err)
+		}
+
+	case "tls":
+		if l.TLSConfig == nil {
+			return fmt.Errorf("%s: TLS listener requires a certificate", l)
+		}
+		l.listener, err = tls.Listen("tcp", l.Address, l.TLSConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", l, err)
+		}
+
+	default:
+		return fmt.Errorf("unsupported listener network %q", l.Network)
 	}
 
 	l.wg.Add(1)
@@ -82,14 +172,34 @@ func (l *DnsTapListener) Start() error {
 	return nil
 }
 
-// Stop closes the listener and waits for all handlers to finish.
+// Stop closes the listener, lets open connections drain for a short while
+// and waits for all handlers to finish. Remote senders may keep their
+// connection open indefinitely, so handlers are not waited on unbounded.
 func (l *DnsTapListener) Stop() {
 	if l.listener != nil {
 		_ = l.listener.Close()
 	}
+
+	deadline := time.Now().Add(stopDrainTimeout)
+	l.connsMu.Lock()
+	for conn := range l.conns {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	l.connsMu.Unlock()
+
 	l.wg.Wait()
 }
 
+func (l *DnsTapListener) trackConn(conn net.Conn, add bool) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	if add {
+		l.conns[conn] = struct{}{}
+	} else {
+		delete(l.conns, conn)
+	}
+}
+
 // formatIPv6 converts an IP to ClickHouse IPv6 compatible format.
 // IPv4 addresses are converted to IPv6-mapped format (::ffff:x.x.x.x)
 func formatIPv6(ip net.IP) string {
@@ -107,6 +217,23 @@ func (l *DnsTapListener) handleConn(conn net.Conn) {
 	defer l.wg.Done()
 	defer conn.Close()
 
+	l.ActiveConns.Add(1)
+	defer l.ActiveConns.Add(-1)
+
+	l.trackConn(conn, true)
+	defer l.trackConn(conn, false)
+
+	// Surface TLS failures (bad or missing client certificate) instead of
+	// letting the framestream handshake fail silently.
+	if tc, ok := conn.(*tls.Conn); ok {
+		_ = tc.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tc.Handshake(); err != nil {
+			log.Printf("dnstap %s: TLS handshake with %s failed: %v", l, conn.RemoteAddr(), err)
+			return
+		}
+		_ = tc.SetDeadline(time.Time{})
+	}
+
 	decoder, err := framestream.NewDecoder(conn, &framestream.DecoderOptions{
 		ContentType:   []byte("protobuf:dnstap.Dnstap"),
 		Bidirectional: true,
@@ -123,9 +250,11 @@ func (l *DnsTapListener) handleConn(conn net.Conn) {
 			}
 			return
 		}
+		l.FramesDecoded.Add(1)
 
 		var dt dnstap.Dnstap
 		if err := proto.Unmarshal(buf, &dt); err != nil {
+			l.UnmarshalFailures.Add(1)
 			continue
 		}
 		if dt.Message == nil {
@@ -140,18 +269,18 @@ func (l *DnsTapListener) handleConn(conn net.Conn) {
 			continue
 		}
 
-		// Get timestamp and truncate to seconds
-		var recordTime time.Time
+		// Get event time with nanosecond precision (stored as microseconds)
+		var eventTime time.Time
 		if t == dnstap.Message_CLIENT_RESPONSE && msg.ResponseTimeSec != nil {
-			recordTime = time.Unix(int64(*msg.ResponseTimeSec), 0)
+			eventTime = time.Unix(int64(*msg.ResponseTimeSec), int64(msg.GetResponseTimeNsec()))
 		} else if t == dnstap.Message_CLIENT_QUERY && msg.QueryTimeSec != nil {
-			recordTime = time.Unix(int64(*msg.QueryTimeSec), 0)
+			eventTime = time.Unix(int64(*msg.QueryTimeSec), int64(msg.GetQueryTimeNsec()))
 		} else {
-			recordTime = time.Now().UTC().Truncate(time.Second)
+			eventTime = time.Now()
 		}
 
 		parsedLog := model.DNSLog{
-			Timestamp: recordTime.UTC().Format(chDateTimeFormat),
+			Timestamp: eventTime.UTC().Format(chDateTimeFormat),
 		}
 
 		// Map to CQ/CR (compact)
@@ -161,6 +290,17 @@ func (l *DnsTapListener) handleConn(conn net.Conn) {
 			parsedLog.ResponseType = "CR"
 		}
 
+		// Transport and sending server
+		if msg.SocketProtocol != nil {
+			parsedLog.SocketProtocol = msg.GetSocketProtocol().String()
+		}
+		if msg.SocketFamily != nil {
+			parsedLog.SocketFamily = msg.GetSocketFamily().String()
+		}
+		parsedLog.QueryPort = uint16(msg.GetQueryPort())
+		parsedLog.ServerIdentity = string(dt.GetIdentity())
+		parsedLog.ServerVersion = string(dt.GetVersion())
+
 		// Client IP from QueryAddress (dnsdist sees real client IP)
 		// Convert to IPv6 format for ClickHouse IPv6 column
 		if msg.QueryAddress != nil {
@@ -181,11 +321,62 @@ func (l *DnsTapListener) handleConn(conn net.Conn) {
 			parsedLog.ResponseSize = uint32(len(packetData))
 
 			// Optimize: Use custom lightweight parser instead of full Unpack
-			rcode, qname, qtype, err := ParseHeaderAndQuestion(packetData)
+			hdr, qname, qtype, err := ParseHeaderAndQuestion(packetData)
+			parsedLog.RCode = hdr.RCode
+			parsedLog.Opcode = hdr.Opcode
+			parsedLog.Flags = hdr.Flags
 			if err == nil {
-				parsedLog.RCode = rcode
 				parsedLog.QName = qname
 				parsedLog.QType = qtype
+			} else {
+				// Keep the row but flag it, so malformed traffic stays visible.
+				l.ParseFailures.Add(1)
+				parsedLog.ParseError = err.Error()
+			}
+
+			if t == dnstap.Message_CLIENT_QUERY {
+				edns, err := ParseEDNS(packetData)
+				if err != nil {
+					l.EDNSFailures.Add(1)
+				}
+				parsedLog.EDNSPresent = edns.Present
+				parsedLog.EDNSUDPSize = edns.UDPSize
+				parsedLog.EDNSDO = edns.DO
+				parsedLog.EDNSCookie = edns.Cookie
+				parsedLog.EDNSPadding = edns.Padding
+				parsedLog.ECSSourcePrefix = edns.ECSPrefix
+				parsedLog.ECSSubnet = edns.ECSSubnet
+			}
+
+			if l.ParseAnswers && t == dnstap.Message_CLIENT_RESPONSE {
+				// Keep whatever was decoded before a malformed record.
+				answers, err := ParseAnswers(packetData, l.MaxAnswers)
+				if err != nil {
+					l.AnswerFailures.Add(1)
+				}
+				for _, a := range answers {
+					parsedLog.AnswerTypes = append(parsedLog.AnswerTypes, a.Type)
+					parsedLog.AnswerTTLs = append(parsedLog.AnswerTTLs, a.TTL)
+					parsedLog.AnswerData = append(parsedLog.AnswerData, a.Data)
+				}
+			}
+		}
+
+		// Query/response pairing: queries wait for their response and are
+		// written once, with latency, as a single CQ row.
+		if l.Pairer != nil && len(packetData) >= 2 {
+			key := pairKey{
+				addr:  string(msg.QueryAddress),
+				port:  msg.GetQueryPort(),
+				id:    binary.BigEndian.Uint16(packetData[0:2]),
+				qname: parsedLog.QName,
+			}
+			if t == dnstap.Message_CLIENT_QUERY {
+				if !l.Pairer.Query(key, parsedLog, eventTime) {
+					continue
+				}
+			} else {
+				parsedLog = l.Pairer.Response(key, parsedLog, eventTime)
 			}
 		}
 
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// EDNS0 option codes (IANA "DNS EDNS0 Option Codes").
+const (
+	ednsOptionECS     = 8
+	ednsOptionCookie  = 10
+	ednsOptionPadding = 12
+)
+
+const typeOPT = 41
+
+// EDNS is what the collector keeps from the OPT pseudo-record of a message.
+type EDNS struct {
+	Present   bool
+	UDPSize   uint16 // requestor's advertised UDP payload size
+	DO        bool   // DNSSEC OK
+	Cookie    bool
+	Padding   bool
+	ECSPrefix uint8  // ECS source prefix length
+	ECSSubnet string // ECS address/prefix, e.g. "192.0.2.0/24"
+}
+
+// ParseEDNS locates the OPT record in the additional section and decodes
+// the fields above. A message without OPT returns a zero EDNS and no error.
+func ParseEDNS(payload []byte) (EDNS, error) {
+	var e EDNS
+	if len(payload) < 12 {
+		return e, ErrTruncated
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(payload[4:6]))
+	ancount := int(binary.BigEndian.Uint16(payload[6:8]))
+	nscount := int(binary.BigEndian.Uint16(payload[8:10]))
+	arcount := int(binary.BigEndian.Uint16(payload[10:12]))
+	if arcount == 0 {
+		return e, nil
+	}
+
+	pos := 12
+	for i := 0; i < qdcount; i++ {
+		var err error
+		if pos, err = skipName(payload, pos); err != nil {
+			return e, err
+		}
+		pos += 4 // QTYPE + QCLASS
+	}
+	for i := 0; i < ancount+nscount; i++ {
+		var err error
+		if pos, err = skipRR(payload, pos); err != nil {
+			return e, err
+		}
+	}
+
+	for i := 0; i < arcount; i++ {
+		var err error
+		if pos, err = skipName(payload, pos); err != nil {
+			return e, err
+		}
+		if pos+10 > len(payload) {
+			return e, ErrTruncated
+		}
+		rrtype := binary.BigEndian.Uint16(payload[pos : pos+2])
+		rdlen := int(binary.BigEndian.Uint16(payload[pos+8 : pos+10]))
+		if pos+10+rdlen > len(payload) {
+			return e, ErrTruncated
+		}
+		if rrtype != typeOPT {
+			pos += 10 + rdlen
+			continue
+		}
+
+		// CLASS carries the UDP size; TTL is EXTENDED-RCODE (8),
+		// VERSION (8), DO (1) and Z (15).
+		e.Present = true
+		e.UDPSize = binary.BigEndian.Uint16(payload[pos+2 : pos+4])
+		e.DO = payload[pos+6]&0x80 != 0
+		return e, parseEDNSOptions(payload[pos+10:pos+10+rdlen], &e)
+	}
+
+	return e, nil
+}
+
+// parseEDNSOptions walks the OPT RDATA {code, length, data} list.
+func parseEDNSOptions(rdata []byte, e *EDNS) error {
+	for i := 0; i < len(rdata); {
+		if i+4 > len(rdata) {
+			return ErrTruncated
+		}
+		code := binary.BigEndian.Uint16(rdata[i : i+2])
+		olen := int(binary.BigEndian.Uint16(rdata[i+2 : i+4]))
+		i += 4
+		if i+olen > len(rdata) {
+			return ErrTruncated
+		}
+		data := rdata[i : i+olen]
+		i += olen
+
+		switch code {
+		case ednsOptionCookie:
+			e.Cookie = true
+		case ednsOptionPadding:
+			e.Padding = true
+		case ednsOptionECS:
+			if err := parseECS(data, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseECS decodes an RFC 7871 client subnet option: FAMILY (2),
+// SOURCE PREFIX-LENGTH (1), SCOPE PREFIX-LENGTH (1), ADDRESS (truncated to
+// the source prefix).
+func parseECS(data []byte, e *EDNS) error {
+	if len(data) < 4 {
+		return errors.New("bad ECS option length")
+	}
+	family := binary.BigEndian.Uint16(data[0:2])
+	prefix := int(data[2])
+	addr := data[4:]
+
+	var size int
+	switch family {
+	case 1:
+		size = 4
+	case 2:
+		size = 16
+	default:
+		return errors.New("unknown ECS family")
+	}
+	if prefix > size*8 || len(addr) > size {
+		return errors.New("bad ECS prefix")
+	}
+
+	var buf [16]byte
+	copy(buf[:], addr)
+	var ip netip.Addr
+	if size == 4 {
+		ip = netip.AddrFrom4([4]byte(buf[:4]))
+	} else {
+		ip = netip.AddrFrom16(buf)
+	}
+	p, err := ip.Prefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	e.ECSPrefix = uint8(prefix)
+	e.ECSSubnet = p.String()
+	return nil
+}
+
+// skipRR advances past one resource record.
+func skipRR(payload []byte, pos int) (int, error) {
+	pos, err := skipName(payload, pos)
+	if err != nil {
+		return 0, err
+	}
+	// TYPE (2), CLASS (2), TTL (4), RDLENGTH (2)
+	if pos+10 > len(payload) {
+		return 0, ErrTruncated
+	}
+	rdlen := int(binary.BigEndian.Uint16(payload[pos+8 : pos+10]))
+	pos += 10 + rdlen
+	if pos > len(payload) {
+		return 0, ErrTruncated
+	}
+	return pos, nil
+}
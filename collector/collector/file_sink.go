@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// FileSink writes rows as NDJSON to a file that is rotated by size, or to
+// stdout. Rotated files are renamed to <name>-<UTC time><ext> and the
+// oldest are removed beyond MaxFiles.
+type FileSink struct {
+	Path          string // empty for stdout
+	MaxBytes      int64  // rotate when the file exceeds this size (0 disables)
+	MaxFiles      int    // rotated files to keep (0 keeps all)
+	FlushInterval time.Duration
+	LogChan       <-chan model.DNSLog
+
+	RowsWritten atomic.Uint64
+	Errors      atomic.Uint64
+
+	file *os.File // nil after a failed rotation until reopened
+	buf  *bufio.Writer
+	size int64
+}
+
+// NewFileSink opens (or creates) path for appending.
+func NewFileSink(path string, maxBytes int64, maxFiles int, logChan <-chan model.DNSLog) (*FileSink, error) {
+	s := &FileSink{
+		Path:          path,
+		MaxBytes:      maxBytes,
+		MaxFiles:      maxFiles,
+		FlushInterval: time.Second,
+		LogChan:       logChan,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("file sink: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStdoutSink writes NDJSON to standard output without rotation.
+func NewStdoutSink(logChan <-chan model.DNSLog) *FileSink {
+	return &FileSink{
+		FlushInterval: time.Second,
+		LogChan:       logChan,
+		buf:           bufio.NewWriterSize(os.Stdout, 64<<10),
+	}
+}
+
+func (s *FileSink) Worker() {
+	batchLoop(s.LogChan, 1000, s.FlushInterval, s.write)
+
+	if s.Path != "" && s.file == nil {
+		return
+	}
+	if err := s.buf.Flush(); err != nil {
+		log.Printf("File sink flush failed: %v", err)
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+}
+
+func (s *FileSink) write(logs []model.DNSLog) {
+	// Rows are encoded into line first: the bufio.Writer flushes on its
+	// own, so its Buffered() count says nothing about the file size.
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+
+	if s.Path != "" && s.file == nil {
+		if err := s.open(); err != nil {
+			log.Printf("File sink reopen failed (dropping %d rows): %v", len(logs), err)
+			s.Errors.Add(uint64(len(logs)))
+			return
+		}
+	}
+	// A failed rotation is retried with the next batch, not on every row.
+	rotate := true
+	for i, l := range logs {
+		if rotate && s.file != nil && s.MaxBytes > 0 && s.size >= s.MaxBytes {
+			if err := s.rotate(); err != nil {
+				log.Printf("File sink rotation failed: %v", err)
+				s.Errors.Add(1)
+				rotate = false
+				if s.file == nil {
+					s.Errors.Add(uint64(len(logs) - i))
+					return
+				}
+			}
+		}
+		line.Reset()
+		if err := enc.Encode(l); err != nil {
+			s.Errors.Add(1)
+			continue
+		}
+		n, _ := s.buf.Write(line.Bytes())
+		s.size += int64(n)
+		s.RowsWritten.Add(1)
+	}
+	if err := s.buf.Flush(); err != nil {
+		log.Printf("File sink write failed: %v", err)
+		s.Errors.Add(1)
+	}
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("file sink: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	if s.buf == nil {
+		s.buf = bufio.NewWriterSize(f, 256<<10)
+	} else {
+		s.buf.Reset(f)
+	}
+	return nil
+}
+
+// rotate renames the current file and opens a fresh one. If the rename
+// fails, writing continues in the current file. If no file can be opened,
+// s.file is left nil and write reopens it with the next batch.
+func (s *FileSink) rotate() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return errors.Join(err, s.open())
+	}
+
+	ext := filepath.Ext(s.Path)
+	base := strings.TrimSuffix(s.Path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	if err := os.Rename(s.Path, rotated); err != nil {
+		return errors.Join(err, s.open())
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.prune(base, ext)
+	return nil
+}
+
+// prune removes the oldest rotated files beyond MaxFiles. The timestamp
+// suffix sorts chronologically.
+func (s *FileSink) prune(base, ext string) {
+	if s.MaxFiles <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil || len(matches) <= s.MaxFiles {
+		return
+	}
+	sort.Strings(matches)
+	for _, old := range matches[:len(matches)-s.MaxFiles] {
+		if err := os.Remove(old); err != nil {
+			log.Printf("File sink prune failed: %v", err)
+		}
+	}
+}
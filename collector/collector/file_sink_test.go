@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dnsdist-collector/model"
+)
+
+func fileSinkRows(n int) []model.DNSLog {
+	rows := make([]model.DNSLog, n)
+	for i := range rows {
+		rows[i] = model.DNSLog{Timestamp: "2026-01-02 03:04:05.000006", ClientIP: "::ffff:192.0.2.1", QName: "example.com.", ResponseType: "CQ"}
+	}
+	return rows
+}
+
+// countLines returns the number of NDJSON rows in the files matching pattern.
+func countLines(t *testing.T, pattern string) (files, lines int) {
+	t.Helper()
+	matches, _ := filepath.Glob(pattern)
+	for _, m := range matches {
+		f, err := os.Open(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			lines++
+		}
+		f.Close()
+	}
+	return len(matches), lines
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dns.ndjson")
+	s, err := NewFileSink(path, 64<<10, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Batches much larger than the 256 KiB write buffer, so it flushes on
+	// its own in the middle of a batch.
+	for range 10 {
+		s.write(fileSinkRows(5000))
+	}
+	_ = s.file.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file is rotated before the first row that would start beyond
+	// MaxBytes, so it ends at most one row over.
+	if info.Size() > 64<<10+512 {
+		t.Errorf("current file is %d bytes, want about MaxBytes (%d)", info.Size(), 64<<10)
+	}
+	rotated, _ := countLines(t, filepath.Join(dir, "dns-*.ndjson"))
+	if rotated != 3 {
+		t.Errorf("%d rotated files kept, want MaxFiles = 3", rotated)
+	}
+	if s.Errors.Load() != 0 || s.RowsWritten.Load() != 50000 {
+		t.Errorf("RowsWritten = %d, Errors = %d, want 50000 and 0", s.RowsWritten.Load(), s.Errors.Load())
+	}
+}
+
+func TestFileSinkFailedRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sink")
+	path := filepath.Join(dir, "dns.ndjson")
+	s, err := NewFileSink(path, 1024, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write(fileSinkRows(20))
+
+	// The file vanished (e.g. removed by hand): the rename fails, and the
+	// sink reopens the path and keeps writing.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s.write(fileSinkRows(10))
+	if s.Errors.Load() != 1 {
+		t.Errorf("Errors = %d after the failed rename, want 1", s.Errors.Load())
+	}
+	if _, lines := countLines(t, path); lines == 0 {
+		t.Error("rows after the failed rename were not written to a reopened file")
+	}
+
+	// The directory vanished as well: no file can be opened. The batch is
+	// dropped, and the next one reopens the file once the directory is back.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	s.write(fileSinkRows(30))
+	if s.file != nil {
+		t.Fatal("sink kept a file after it could not reopen one")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s.write(fileSinkRows(5))
+	if s.file == nil {
+		t.Fatal("sink did not reopen the file")
+	}
+	_ = s.file.Close()
+	if _, lines := countLines(t, filepath.Join(dir, "*.ndjson")); lines != 5 {
+		t.Errorf("reopened files hold %d rows, want 5", lines)
+	}
+}
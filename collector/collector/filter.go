@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"strings"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// SamplingPolicy decides which rows survive sampling. Rows matching one of
+// the Keep rules bypass both the uniform rate and the rate limits.
+type SamplingPolicy struct {
+	Rate        float64 // keep probability, 1 disables uniform sampling
+	KeepErrors  bool    // keep every row whose rcode is not NOERROR
+	KeepDomains []string
+	KeepQTypes  []uint16
+
+	ClientLimit RateLimit // per client IP
+	QNameLimit  RateLimit // per query name
+	MaxBuckets  int       // per limit; keys beyond it are not limited
+}
+
+// RateLimit is a token bucket of Rate rows per second and Burst rows. A
+// zero Rate disables it.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RowFilter drops unwanted rows and samples the rest before they reach the
+// sinks. Its rules never change; reloading swaps in a new RowFilter. The
+// rate-limit buckets are state and are only touched by the Fanout loop.
+type RowFilter struct {
+	domains       suffixSet
+	qtypes        map[uint16]bool
+	clients       []netip.Prefix // IPv6 / IPv4-mapped
+	responseTypes map[string]bool
+
+	rate        float64 // keep probability, 1 disables sampling
+	weight      float32 // 1/rate, stored on kept rows
+	keepErrors  bool
+	keepDomains suffixSet
+	keepQTypes  map[uint16]bool
+
+	clientBuckets *bucketTable // nil when not limited
+	qnameBuckets  *bucketTable
+}
+
+// SampleResult is the outcome of RowFilter.Sample.
+type SampleResult int
+
+const (
+	SampleKeep   SampleResult = iota
+	SampleDrop                // dropped by uniform sampling
+	SampleFolded              // held back by a rate limit, counted in a collapsed row
+)
+
+// NewRowFilter builds a filter. Rows matching any exclusion are dropped;
+// the remaining rows are sampled according to policy.
+func NewRowFilter(domains []string, qtypes []uint16, clients []netip.Prefix, responseTypes []string, policy SamplingPolicy) *RowFilter {
+	f := &RowFilter{
+		domains:       newSuffixSet(domains),
+		clients:       clients,
+		qtypes:        make(map[uint16]bool, len(qtypes)),
+		responseTypes: make(map[string]bool, len(responseTypes)),
+		rate:          policy.Rate,
+		weight:        float32(1 / policy.Rate),
+		keepErrors:    policy.KeepErrors,
+		keepDomains:   newSuffixSet(policy.KeepDomains),
+		keepQTypes:    make(map[uint16]bool, len(policy.KeepQTypes)),
+		clientBuckets: newBucketTable(policy.ClientLimit, policy.MaxBuckets, true),
+		qnameBuckets:  newBucketTable(policy.QNameLimit, policy.MaxBuckets, false),
+	}
+	for _, qt := range qtypes {
+		f.qtypes[qt] = true
+	}
+	for _, rt := range responseTypes {
+		f.responseTypes[rt] = true
+	}
+	for _, qt := range policy.KeepQTypes {
+		f.keepQTypes[qt] = true
+	}
+	return f
+}
+
+// Exclude reports whether row matches one of the exclusion rules.
+func (f *RowFilter) Exclude(row *model.DNSLog) bool {
+	if f.responseTypes[row.ResponseType] || f.qtypes[row.QType] {
+		return true
+	}
+	if f.domains.match(row.QName) {
+		return true
+	}
+	if len(f.clients) > 0 {
+		if addr, err := netip.ParseAddr(row.ClientIP); err == nil {
+			for _, p := range f.clients {
+				if p.Contains(addr) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// keep reports whether row matches one of the always-keep rules.
+func (f *RowFilter) keep(row *model.DNSLog) bool {
+	return (f.keepErrors && row.RCode != 0) || f.keepQTypes[row.QType] || f.keepDomains.match(row.QName)
+}
+
+// Sample decides whether row is kept and sets its sample weight. Rows held
+// back by a rate limit are remembered and come out of Flush.
+func (f *RowFilter) Sample(row *model.DNSLog) SampleResult {
+	if f.keep(row) {
+		return SampleKeep
+	}
+	if f.rate < 1 {
+		if rand.Float64() >= f.rate {
+			return SampleDrop
+		}
+		row.SampleWeight = f.weight
+	}
+	if f.clientBuckets == nil && f.qnameBuckets == nil {
+		return SampleKeep
+	}
+
+	now := time.Now()
+	cb := f.clientBuckets.get(row.ClientIP, now)
+	if cb != nil && cb.tokens < 1 {
+		cb.fold(row)
+		return SampleFolded
+	}
+	qb := f.qnameBuckets.get(row.QName, now)
+	if qb != nil && qb.tokens < 1 {
+		qb.fold(row)
+		return SampleFolded
+	}
+	if cb != nil {
+		cb.tokens--
+	}
+	if qb != nil {
+		qb.tokens--
+	}
+	return SampleKeep
+}
+
+// Flush emits one collapsed row for every bucket that held rows back since
+// the last Flush, and forgets idle buckets. The Fanout calls it every
+// second and when the filter is replaced.
+func (f *RowFilter) Flush(emit func(model.DNSLog)) {
+	now := time.Now()
+	f.clientBuckets.flush(now, emit)
+	f.qnameBuckets.flush(now, emit)
+}
+
+// suffixSet matches a name against a set of domains and their subdomains.
+// Lookups walk the labels of the name, so the cost does not grow with the
+// size of the set (blocklists can be large).
+type suffixSet map[string]struct{}
+
+func newSuffixSet(domains []string) suffixSet {
+	set := make(suffixSet, len(domains))
+	for _, d := range domains {
+		set[strings.ToLower(strings.TrimSuffix(d, "."))] = struct{}{}
+	}
+	return set
+}
+
+// match reports whether name equals a domain in the set or is a subdomain
+// of one, ignoring case.
+func (s suffixSet) match(name string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for {
+		if _, ok := s[name]; ok {
+			return true
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			return false
+		}
+		name = name[dot+1:]
+	}
+}
+
+// bucketTable holds one token bucket per key.
+type bucketTable struct {
+	rate    float64
+	burst   float64
+	max     int
+	buckets map[string]*bucket
+
+	// Blank the qname of collapsed rows. A client's folded rows span many
+	// names; keeping the first one would credit it with all of them.
+	blankQName bool
+}
+
+// bucket is a token bucket that also keeps the first row it held back,
+// carrying the weight of every row held back since.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	held   model.DNSLog
+	weight float32 // 0 when nothing is held
+}
+
+func newBucketTable(limit RateLimit, max int, blankQName bool) *bucketTable {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	return &bucketTable{
+		rate:       limit.Rate,
+		burst:      float64(limit.Burst),
+		max:        max,
+		buckets:    make(map[string]*bucket),
+		blankQName: blankQName,
+	}
+}
+
+// get returns the refilled bucket for key, or nil when the table is
+// disabled or full.
+func (t *bucketTable) get(key string, now time.Time) *bucket {
+	if t == nil {
+		return nil
+	}
+	b := t.buckets[key]
+	if b == nil {
+		if len(t.buckets) >= t.max {
+			return nil
+		}
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[key] = b
+		return b
+	}
+	b.tokens = min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+	b.last = now
+	return b
+}
+
+// fold counts row into the bucket's collapsed row.
+func (b *bucket) fold(row *model.DNSLog) {
+	if b.weight == 0 {
+		b.held = *row
+	}
+	b.weight += rowWeight(row)
+}
+
+func (t *bucketTable) flush(now time.Time, emit func(model.DNSLog)) {
+	if t == nil {
+		return
+	}
+	for key, b := range t.buckets {
+		if b.weight > 0 {
+			row := b.held
+			row.SampleWeight = b.weight
+			if t.blankQName {
+				row.QName = ""
+			}
+			b.held, b.weight = model.DNSLog{}, 0
+			emit(row)
+			continue
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*t.rate >= t.burst {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// rowWeight returns the number of rows row stands for; 0 means 1.
+func rowWeight(row *model.DNSLog) float32 {
+	if row.SampleWeight == 0 {
+		return 1
+	}
+	return row.SampleWeight
+}
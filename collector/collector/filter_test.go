@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"testing"
+
+	"dnsdist-collector/model"
+)
+
+func TestRowFilterClientFold(t *testing.T) {
+	f := NewRowFilter(nil, nil, nil, nil, SamplingPolicy{
+		Rate:        1,
+		ClientLimit: RateLimit{Rate: 1, Burst: 1},
+		MaxBuckets:  10,
+	})
+
+	names := []string{"a.example.", "b.example.", "c.example.", "d.example."}
+	for i, name := range names {
+		row := model.DNSLog{ClientIP: "::ffff:192.0.2.1", QName: name, ResponseType: "CQ"}
+		want := SampleFolded
+		if i == 0 {
+			want = SampleKeep
+		}
+		if got := f.Sample(&row); got != want {
+			t.Fatalf("Sample(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	var rows []model.DNSLog
+	f.Flush(func(row model.DNSLog) { rows = append(rows, row) })
+	if len(rows) != 1 {
+		t.Fatalf("Flush emitted %d rows, want 1", len(rows))
+	}
+	if rows[0].QName != "" || rows[0].ClientIP != "::ffff:192.0.2.1" || rows[0].SampleWeight != 3 {
+		t.Errorf("collapsed row = %+v, want client ::ffff:192.0.2.1, no qname, weight 3", rows[0])
+	}
+}
+
+func TestRowFilterQNameFold(t *testing.T) {
+	f := NewRowFilter(nil, nil, nil, nil, SamplingPolicy{
+		Rate:       1,
+		QNameLimit: RateLimit{Rate: 1, Burst: 1},
+		MaxBuckets: 10,
+	})
+	for _, ip := range []string{"::ffff:192.0.2.1", "::ffff:192.0.2.2", "::ffff:192.0.2.3"} {
+		row := model.DNSLog{ClientIP: ip, QName: "flood.example.", ResponseType: "CQ"}
+		f.Sample(&row)
+	}
+
+	var rows []model.DNSLog
+	f.Flush(func(row model.DNSLog) { rows = append(rows, row) })
+	if len(rows) != 1 || rows[0].QName != "flood.example." || rows[0].SampleWeight != 2 {
+		t.Errorf("collapsed rows = %+v, want one flood.example. row with weight 2", rows)
+	}
+}
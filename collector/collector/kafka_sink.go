@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dnsdist-collector/model"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSink publishes every row as a JSON message to a Kafka topic, keyed
+// by client IP so one client's queries stay ordered within a partition.
+// Any broker speaking the Kafka protocol works (Redpanda is a convenient
+// local stand-in).
+type KafkaSink struct {
+	Writer  *kafka.Writer
+	LogChan <-chan model.DNSLog
+
+	Sent    atomic.Uint64
+	Dropped atomic.Uint64 // rows lost after the producer gave up
+}
+
+// NewKafkaSink creates a producer for brokers (comma separated host:port).
+func NewKafkaSink(brokers, topic string, logChan <-chan model.DNSLog) *KafkaSink {
+	return &KafkaSink{
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    5000,
+			BatchTimeout: 500 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+			Compression:  kafka.Lz4,
+			MaxAttempts:  5,
+			WriteTimeout: 10 * time.Second,
+		},
+		LogChan: logChan,
+	}
+}
+
+func (s *KafkaSink) Worker() {
+	batchLoop(s.LogChan, 5000, time.Second, func(logs []model.DNSLog) {
+		msgs := make([]kafka.Message, 0, len(logs))
+		for i := range logs {
+			value, err := json.Marshal(&logs[i])
+			if err != nil {
+				s.Dropped.Add(1)
+				continue
+			}
+			msgs = append(msgs, kafka.Message{Key: []byte(logs[i].ClientIP), Value: value})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := s.Writer.WriteMessages(ctx, msgs...)
+		if err == nil {
+			s.Sent.Add(uint64(len(msgs)))
+			return
+		}
+
+		failed := len(msgs)
+		var werrs kafka.WriteErrors
+		if errors.As(err, &werrs) {
+			failed = werrs.Count()
+		}
+		log.Printf("Kafka write failed (dropping %d of %d rows): %v", failed, len(msgs), err)
+		s.Dropped.Add(uint64(failed))
+		s.Sent.Add(uint64(len(msgs) - failed))
+	})
+
+	if err := s.Writer.Close(); err != nil {
+		log.Printf("Kafka writer close failed: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+
+	"dnsdist-collector/model"
+)
+
+// fakeBroker is a local stand-in for a Kafka cluster of one broker with one
+// partition per topic. It plugs in as the producer's transport and answers
+// the first failProduces produce requests with LeaderNotAvailable, which
+// the producer retries.
+type fakeBroker struct {
+	failProduces int
+
+	mu       sync.Mutex
+	produces int
+	topics   []string
+	attrs    []protocol.Attributes
+	keys     []string
+	values   [][]byte
+}
+
+func (b *fakeBroker) RoundTrip(ctx context.Context, addr net.Addr, req kafka.Request) (kafka.Response, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "127.0.0.1", Port: 9092}}}
+		for _, name := range req.TopicNames {
+			res.Topics = append(res.Topics, metadata.ResponseTopic{
+				Name:       name,
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			})
+		}
+		return res, nil
+
+	case *produce.Request:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.produces++
+		topic, part := req.Topics[0].Topic, req.Topics[0].Partitions[0]
+		res := &produce.Response{Topics: []produce.ResponseTopic{{
+			Topic:      topic,
+			Partitions: []produce.ResponsePartition{{Partition: part.Partition}},
+		}}}
+		if b.produces <= b.failProduces {
+			res.Topics[0].Partitions[0].ErrorCode = int16(kafka.LeaderNotAvailable)
+			return res, nil
+		}
+		for {
+			rec, err := part.RecordSet.Records.ReadRecord()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			key, _ := protocol.ReadAll(rec.Key)
+			value, _ := protocol.ReadAll(rec.Value)
+			b.topics = append(b.topics, topic)
+			b.attrs = append(b.attrs, part.RecordSet.Attributes)
+			b.keys = append(b.keys, string(key))
+			b.values = append(b.values, value)
+		}
+		return res, nil
+	}
+	return nil, errors.New("fake broker: unexpected request")
+}
+
+// runKafkaSink sends rows through a KafkaSink talking to broker and waits
+// for the sink to finish.
+func runKafkaSink(broker *fakeBroker, maxAttempts int, rows []model.DNSLog) *KafkaSink {
+	logChan := make(chan model.DNSLog, len(rows))
+	s := NewKafkaSink("127.0.0.1:9092", "dns", logChan)
+	s.Writer.Transport = broker
+	s.Writer.BatchTimeout = 10 * time.Millisecond
+	s.Writer.WriteBackoffMin = time.Millisecond
+	s.Writer.WriteBackoffMax = 5 * time.Millisecond
+	s.Writer.MaxAttempts = maxAttempts
+	for _, row := range rows {
+		logChan <- row
+	}
+	close(logChan)
+	s.Worker()
+	return s
+}
+
+func TestKafkaSinkWireFormat(t *testing.T) {
+	rows := []model.DNSLog{
+		{Timestamp: "2026-01-02 03:04:05.000006", ClientIP: "::ffff:192.0.2.1", QName: "example.com.", QType: 1, ResponseType: "CQ"},
+		{Timestamp: "2026-01-02 03:04:05.000007", ClientIP: "2001:db8::1", QName: "example.net.", QType: 28, ResponseType: "CQ", Paired: true, LatencyUs: 250},
+	}
+	// The first attempt fails; the retry must deliver every row once.
+	broker := &fakeBroker{failProduces: 1}
+	s := runKafkaSink(broker, 3, rows)
+
+	if broker.produces != 2 {
+		t.Errorf("broker saw %d produce requests, want 2 (one retry)", broker.produces)
+	}
+	if s.Sent.Load() != 2 || s.Dropped.Load() != 0 {
+		t.Errorf("Sent = %d, Dropped = %d, want 2 and 0", s.Sent.Load(), s.Dropped.Load())
+	}
+	if len(broker.values) != len(rows) {
+		t.Fatalf("broker stored %d records, want %d", len(broker.values), len(rows))
+	}
+	for i, want := range rows {
+		if broker.topics[i] != "dns" {
+			t.Errorf("record %d: topic %q, want dns", i, broker.topics[i])
+		}
+		if c := broker.attrs[i].Compression(); c != kafka.Lz4 {
+			t.Errorf("record %d: compression %v, want lz4", i, c)
+		}
+		// Keyed by client so one client's queries stay in one partition.
+		if broker.keys[i] != want.ClientIP {
+			t.Errorf("record %d: key %q, want %q", i, broker.keys[i], want.ClientIP)
+		}
+		var got model.DNSLog
+		if err := json.Unmarshal(broker.values[i], &got); err != nil {
+			t.Fatalf("record %d: value is not a JSON row: %v", i, err)
+		}
+		if got.QName != want.QName || got.QType != want.QType || got.Timestamp != want.Timestamp ||
+			got.Paired != want.Paired || got.LatencyUs != want.LatencyUs {
+			t.Errorf("record %d: value %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestKafkaSinkGivesUp(t *testing.T) {
+	rows := []model.DNSLog{
+		{ClientIP: "::ffff:192.0.2.1", QName: "a.example.", ResponseType: "CQ"},
+		{ClientIP: "::ffff:192.0.2.2", QName: "b.example.", ResponseType: "CQ"},
+		{ClientIP: "::ffff:192.0.2.3", QName: "c.example.", ResponseType: "CQ"},
+	}
+	broker := &fakeBroker{failProduces: 1 << 30}
+	s := runKafkaSink(broker, 3, rows)
+
+	if broker.produces != 3 {
+		t.Errorf("broker saw %d produce requests, want 3 (MaxAttempts)", broker.produces)
+	}
+	if s.Sent.Load() != 0 || s.Dropped.Load() != 3 {
+		t.Errorf("Sent = %d, Dropped = %d, want 0 and 3", s.Sent.Load(), s.Dropped.Load())
+	}
+}
@@ -0,0 +1,298 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"dnsdist-collector/model"
+
+	"github.com/ClickHouse/ch-go"
+	"github.com/ClickHouse/ch-go/proto"
+)
+
+// NativeInserter writes batches over the ClickHouse native TCP protocol as
+// LZ4-compressed columnar blocks. It avoids the per-row JSON encoding of the
+// HTTP path and is selected with the collector's -clickhouse-native flag.
+//
+// Insert is safe for concurrent use: each caller borrows its own
+// connection, so concurrent writer workers insert in parallel.
+type NativeInserter struct {
+	Addr        string // host:port of the native interface, usually 9000 (9440 with TLS)
+	Username    string
+	Password    string
+	Database    string
+	Table       string
+	TLS         *tls.Config
+	DialTimeout time.Duration
+	Timeout     time.Duration // per insert
+
+	idle chan *nativeConn // connections not in use
+}
+
+// nativeConn is one connection with its reusable column block. ch.Client is
+// not safe for concurrent use, so a connection is only used by one insert
+// at a time.
+type nativeConn struct {
+	client *ch.Client
+	block  *dnsBlock
+}
+
+// maxIdleNativeConns bounds the connections kept open between inserts.
+const maxIdleNativeConns = 16
+
+// NewNativeInserter creates an inserter for the table in opts at addr.
+// Connections are opened lazily on demand and re-opened after errors.
+func NewNativeInserter(addr string, opts ClickHouseOptions) *NativeInserter {
+	return &NativeInserter{
+		Addr:        addr,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		Database:    opts.database(),
+		Table:       opts.table(),
+		TLS:         opts.TLS,
+		DialTimeout: 5 * time.Second,
+		Timeout:     10 * time.Second,
+		idle:        make(chan *nativeConn, maxIdleNativeConns),
+	}
+}
+
+// Insert sends logs as a single block.
+func (n *NativeInserter) Insert(logs []model.DNSLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	var conn *nativeConn
+	select {
+	case conn = <-n.idle:
+	default:
+		conn = &nativeConn{block: newDNSBlock()}
+	}
+
+	// Filling first keeps a batch that can never be sent from dialing.
+	if err := conn.block.fill(logs); err != nil {
+		n.release(conn)
+		return fmt.Errorf("%w: %v", errBadRows, err)
+	}
+
+	if conn.client == nil {
+		dialCtx, dialCancel := context.WithTimeout(ctx, n.DialTimeout)
+		client, err := ch.Dial(dialCtx, ch.Options{
+			Address:     n.Addr,
+			User:        n.Username,
+			Password:    n.Password,
+			Database:    n.Database,
+			TLS:         n.TLS,
+			Compression: ch.CompressionLZ4,
+			DialTimeout: n.DialTimeout,
+		})
+		dialCancel()
+		if err != nil {
+			return fmt.Errorf("clickhouse native dial: %w", err)
+		}
+		conn.client = client
+	}
+
+	input := conn.block.input()
+	err := conn.client.Do(ctx, ch.Query{
+		Body:  input.Into(n.Table),
+		Input: input,
+	})
+	if err != nil {
+		// The connection state is unknown after a failed query.
+		_ = conn.client.Close()
+		return fmt.Errorf("clickhouse native insert: %w", err)
+	}
+	n.release(conn)
+	return nil
+}
+
+// release returns conn to the idle pool, closing it if the pool is full.
+func (n *NativeInserter) release(conn *nativeConn) {
+	select {
+	case n.idle <- conn:
+	default:
+		if conn.client != nil {
+			_ = conn.client.Close()
+		}
+	}
+}
+
+// Close closes the idle connections. Call it after the last Insert has
+// returned.
+func (n *NativeInserter) Close() error {
+	var errs []error
+	for {
+		select {
+		case conn := <-n.idle:
+			if err := conn.client.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// dnsBlock holds one column per dns_logs column written by the collector.
+// Columns are reset and reused between batches. Keep in sync with
+// model.DNSLog and clickhouse/schema.sql.
+type dnsBlock struct {
+	timestamp         *proto.ColDateTime64
+	clientIP          proto.ColIPv6
+	qname             *proto.ColLowCardinality[string]
+	qtype             proto.ColUInt16
+	responseType      proto.ColEnum
+	responseSize      proto.ColUInt32
+	rcode             proto.ColUInt8
+	opcode            proto.ColUInt8
+	flags             proto.ColUInt16
+	parseError        *proto.ColLowCardinality[string]
+	socketProtocol    *proto.ColLowCardinality[string]
+	socketFamily      *proto.ColLowCardinality[string]
+	queryPort         proto.ColUInt16
+	serverIdentity    *proto.ColLowCardinality[string]
+	serverVersion     *proto.ColLowCardinality[string]
+	ednsPresent       proto.ColBool
+	ednsUDPSize       proto.ColUInt16
+	ednsDO            proto.ColBool
+	ednsCookie        proto.ColBool
+	ednsPadding       proto.ColBool
+	ecsSourcePrefix   proto.ColUInt8
+	ecsSubnet         proto.ColStr
+	responseTimestamp *proto.ColDateTime64
+	latencyUs         proto.ColUInt32
+	paired            proto.ColBool
+	sampleWeight      proto.ColFloat32
+	anonymized        proto.ColEnum
+	answerTypes       *proto.ColArr[uint16]
+	answerTTLs        *proto.ColArr[uint32]
+	answerData        *proto.ColArr[string]
+}
+
+func newDNSBlock() *dnsBlock {
+	return &dnsBlock{
+		timestamp:         new(proto.ColDateTime64).WithPrecision(proto.PrecisionMicro),
+		qname:             new(proto.ColStr).LowCardinality(),
+		parseError:        new(proto.ColStr).LowCardinality(),
+		socketProtocol:    new(proto.ColStr).LowCardinality(),
+		socketFamily:      new(proto.ColStr).LowCardinality(),
+		serverIdentity:    new(proto.ColStr).LowCardinality(),
+		serverVersion:     new(proto.ColStr).LowCardinality(),
+		responseTimestamp: new(proto.ColDateTime64).WithPrecision(proto.PrecisionMicro),
+		answerTypes:       new(proto.ColUInt16).Array(),
+		answerTTLs:        new(proto.ColUInt32).Array(),
+		answerData:        new(proto.ColStr).Array(),
+	}
+}
+
+func (b *dnsBlock) input() proto.Input {
+	return proto.Input{
+		{Name: "timestamp", Data: b.timestamp},
+		{Name: "client_ip", Data: &b.clientIP},
+		{Name: "qname", Data: b.qname},
+		{Name: "qtype", Data: &b.qtype},
+		{Name: "response_type", Data: &b.responseType},
+		{Name: "response_size", Data: &b.responseSize},
+		{Name: "rcode", Data: &b.rcode},
+		{Name: "opcode", Data: &b.opcode},
+		{Name: "flags", Data: &b.flags},
+		{Name: "parse_error", Data: b.parseError},
+		{Name: "socket_protocol", Data: b.socketProtocol},
+		{Name: "socket_family", Data: b.socketFamily},
+		{Name: "query_port", Data: &b.queryPort},
+		{Name: "server_identity", Data: b.serverIdentity},
+		{Name: "server_version", Data: b.serverVersion},
+		{Name: "edns_present", Data: &b.ednsPresent},
+		{Name: "edns_udp_size", Data: &b.ednsUDPSize},
+		{Name: "edns_do", Data: &b.ednsDO},
+		{Name: "edns_cookie", Data: &b.ednsCookie},
+		{Name: "edns_padding", Data: &b.ednsPadding},
+		{Name: "ecs_source_prefix", Data: &b.ecsSourcePrefix},
+		{Name: "ecs_subnet", Data: &b.ecsSubnet},
+		{Name: "response_timestamp", Data: b.responseTimestamp},
+		{Name: "latency_us", Data: &b.latencyUs},
+		{Name: "paired", Data: &b.paired},
+		{Name: "sample_weight", Data: &b.sampleWeight},
+		{Name: "anonymized", Data: &b.anonymized},
+		{Name: "answer_types", Data: b.answerTypes},
+		{Name: "answer_ttls", Data: b.answerTTLs},
+		{Name: "answer_data", Data: b.answerData},
+	}
+}
+
+// fill resets the block and appends logs.
+func (b *dnsBlock) fill(logs []model.DNSLog) error {
+	for _, col := range b.input() {
+		col.Data.(proto.Resettable).Reset()
+	}
+
+	for i := range logs {
+		l := &logs[i]
+
+		ts, err := parseCHTime(l.Timestamp)
+		if err != nil {
+			return fmt.Errorf("row %d: timestamp: %w", i, err)
+		}
+		ip, err := netip.ParseAddr(l.ClientIP)
+		if err != nil {
+			return fmt.Errorf("row %d: client_ip: %w", i, err)
+		}
+		var respTS time.Time
+		if l.ResponseTimestamp != "" {
+			if respTS, err = parseCHTime(l.ResponseTimestamp); err != nil {
+				return fmt.Errorf("row %d: response_timestamp: %w", i, err)
+			}
+		} else {
+			respTS = time.Unix(0, 0)
+		}
+
+		b.timestamp.Append(ts)
+		b.clientIP.Append(proto.ToIPv6(ip))
+		b.qname.Append(l.QName)
+		b.qtype.Append(l.QType)
+		b.responseType.Append(l.ResponseType)
+		b.responseSize.Append(l.ResponseSize)
+		b.rcode.Append(l.RCode)
+		b.opcode.Append(l.Opcode)
+		b.flags.Append(l.Flags)
+		b.parseError.Append(l.ParseError)
+		b.socketProtocol.Append(l.SocketProtocol)
+		b.socketFamily.Append(l.SocketFamily)
+		b.queryPort.Append(l.QueryPort)
+		b.serverIdentity.Append(l.ServerIdentity)
+		b.serverVersion.Append(l.ServerVersion)
+		b.ednsPresent.Append(l.EDNSPresent)
+		b.ednsUDPSize.Append(l.EDNSUDPSize)
+		b.ednsDO.Append(l.EDNSDO)
+		b.ednsCookie.Append(l.EDNSCookie)
+		b.ednsPadding.Append(l.EDNSPadding)
+		b.ecsSourcePrefix.Append(l.ECSSourcePrefix)
+		b.ecsSubnet.Append(l.ECSSubnet)
+		b.responseTimestamp.Append(respTS)
+		b.latencyUs.Append(l.LatencyUs)
+		b.paired.Append(l.Paired)
+		if l.SampleWeight > 0 {
+			b.sampleWeight.Append(l.SampleWeight)
+		} else {
+			b.sampleWeight.Append(1)
+		}
+		if l.Anonymized != "" {
+			b.anonymized.Append(l.Anonymized)
+		} else {
+			b.anonymized.Append("none")
+		}
+		b.answerTypes.Append(l.AnswerTypes)
+		b.answerTTLs.Append(l.AnswerTTLs)
+		b.answerData.Append(l.AnswerData)
+	}
+	return nil
+}
+
+// parseCHTime parses the DateTime64(6) strings stored in model.DNSLog.
+func parseCHTime(s string) (time.Time, error) {
+	return time.ParseInLocation(chDateTimeFormat, s, time.UTC)
+}
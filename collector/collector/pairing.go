@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// pairKey identifies a client transaction: the same client address, port,
+// DNS ID and qname appear on both the query and its response.
+type pairKey struct {
+	addr  string
+	port  uint32
+	id    uint16
+	qname string
+}
+
+type pendingQuery struct {
+	row       model.DNSLog
+	queryTime time.Time
+	expires   time.Time
+}
+
+// Pairer correlates CLIENT_QUERY and CLIENT_RESPONSE messages into a single
+// row carrying both timestamps and the measured latency. Header flags are
+// taken from the response. Queries wait in a
+// bounded pending table; queries that see no response within Timeout (or
+// that do not fit in the table) are emitted on their own.
+type Pairer struct {
+	Timeout    time.Duration
+	MaxPending int
+	LogChan    chan<- model.DNSLog
+	Dropped    atomic.Uint64 // evicted rows lost because LogChan was full
+
+	// Counters exported on /metrics
+	Paired    atomic.Uint64
+	Unmatched atomic.Uint64 // responses without a pending query
+	Expired   atomic.Uint64 // queries emitted unpaired after Timeout
+	Overflow  atomic.Uint64 // queries emitted unpaired because the table was full
+
+	mu      sync.Mutex
+	pending map[pairKey]pendingQuery
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewPairer creates a Pairer. Rows evicted from the pending table are sent
+// to logChan.
+func NewPairer(timeout time.Duration, maxPending int, logChan chan<- model.DNSLog) *Pairer {
+	return &Pairer{
+		Timeout:    timeout,
+		MaxPending: maxPending,
+		LogChan:    logChan,
+		pending:    make(map[pairKey]pendingQuery),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start launches the timeout sweeper.
+func (p *Pairer) Start() {
+	interval := p.Timeout / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				p.sweep(now)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the sweeper and emits every query still pending.
+func (p *Pairer) Stop() {
+	close(p.stop)
+	<-p.done
+
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[pairKey]pendingQuery)
+	p.mu.Unlock()
+
+	for _, q := range pending {
+		p.emit(q.row)
+	}
+}
+
+// Pending returns the number of queries waiting for a response.
+func (p *Pairer) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// Query records a client query. It returns false if the row is now pending;
+// true means the caller must emit the row itself.
+func (p *Pairer) Query(key pairKey, row model.DNSLog, queryTime time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prev, ok := p.pending[key]; ok {
+		// Retransmission with the same ID: keep the newest, emit the older.
+		delete(p.pending, key)
+		p.Expired.Add(1)
+		p.emit(prev.row)
+	} else if len(p.pending) >= p.MaxPending {
+		p.Overflow.Add(1)
+		return true
+	}
+
+	p.pending[key] = pendingQuery{
+		row:       row,
+		queryTime: queryTime,
+		expires:   time.Now().Add(p.Timeout),
+	}
+	return false
+}
+
+// Response matches a client response against the pending table. If a query
+// is found the merged row is returned; otherwise the response row is
+// returned unchanged.
+func (p *Pairer) Response(key pairKey, row model.DNSLog, responseTime time.Time) model.DNSLog {
+	p.mu.Lock()
+	q, ok := p.pending[key]
+	if ok {
+		delete(p.pending, key)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		p.Unmatched.Add(1)
+		return row
+	}
+	p.Paired.Add(1)
+
+	merged := q.row
+	merged.Paired = true
+	merged.RCode = row.RCode
+	// The response echoes RD, CD and the opcode, and adds AA/TC/RA/AD.
+	merged.Opcode = row.Opcode
+	merged.Flags = row.Flags
+	merged.ResponseSize = row.ResponseSize
+	merged.ResponseTimestamp = row.Timestamp
+	merged.AnswerTypes = row.AnswerTypes
+	merged.AnswerTTLs = row.AnswerTTLs
+	merged.AnswerData = row.AnswerData
+	if latency := responseTime.Sub(q.queryTime); latency > 0 {
+		merged.LatencyUs = uint32(min(latency.Microseconds(), int64(^uint32(0))))
+	}
+	return merged
+}
+
+// emit sends an evicted row without blocking (drop on overflow).
+func (p *Pairer) emit(row model.DNSLog) {
+	select {
+	case p.LogChan <- row:
+	default:
+		p.Dropped.Add(1)
+	}
+}
+
+func (p *Pairer) sweep(now time.Time) {
+	var expired []model.DNSLog
+
+	p.mu.Lock()
+	for key, q := range p.pending {
+		if now.After(q.expires) {
+			expired = append(expired, q.row)
+			delete(p.pending, key)
+		}
+	}
+	p.mu.Unlock()
+
+	p.Expired.Add(uint64(len(expired)))
+	for _, row := range expired {
+		p.emit(row)
+	}
+}
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+func TestPairerPaired(t *testing.T) {
+	logs := make(chan model.DNSLog, 1)
+	p := NewPairer(time.Second, 10, logs)
+	key := pairKey{addr: "192.0.2.1", port: 53000, id: 1, qname: "example.com."}
+	now := time.Now()
+
+	if emit := p.Query(key, model.DNSLog{ResponseType: "CQ"}, now); emit {
+		t.Fatal("Query: row not pending")
+	}
+	// Answered within the same microsecond: paired, but without latency.
+	row := p.Response(key, model.DNSLog{ResponseType: "CR", RCode: 3, Timestamp: "2026-01-02 03:04:05.000006"}, now)
+	if !row.Paired || row.LatencyUs != 0 || row.ResponseType != "CQ" || row.RCode != 3 {
+		t.Errorf("Response = %+v, want a paired CQ row with rcode 3 and no latency", row)
+	}
+
+	row = p.Response(key, model.DNSLog{ResponseType: "CR"}, now)
+	if row.Paired || row.ResponseType != "CR" {
+		t.Errorf("unmatched Response = %+v, want the CR row unchanged", row)
+	}
+}
+
+// received drains the rows the pairer emitted so far.
+func received(logs chan model.DNSLog) []model.DNSLog {
+	var out []model.DNSLog
+	for {
+		select {
+		case l := <-logs:
+			out = append(out, l)
+		default:
+			return out
+		}
+	}
+}
+
+func TestPairerSweep(t *testing.T) {
+	logs := make(chan model.DNSLog, 10)
+	p := NewPairer(time.Second, 10, logs)
+	key := pairKey{addr: "192.0.2.1", port: 53000, id: 7, qname: "slow.example."}
+	p.Query(key, model.DNSLog{QName: "slow.example.", ResponseType: "CQ"}, time.Now())
+
+	p.sweep(time.Now())
+	if got := received(logs); len(got) != 0 || p.Pending() != 1 {
+		t.Fatalf("sweep before the timeout emitted %+v", got)
+	}
+	p.sweep(time.Now().Add(2 * time.Second))
+	got := received(logs)
+	if len(got) != 1 || got[0].Paired || got[0].QName != "slow.example." || got[0].LatencyUs != 0 {
+		t.Fatalf("sweep after the timeout emitted %+v, want the query unpaired", got)
+	}
+	if p.Expired.Load() != 1 || p.Pending() != 0 {
+		t.Errorf("Expired = %d, Pending = %d, want 1 and 0", p.Expired.Load(), p.Pending())
+	}
+
+	// The response arrives too late: it is logged on its own.
+	row := p.Response(key, model.DNSLog{ResponseType: "CR"}, time.Now())
+	if row.Paired || row.ResponseType != "CR" || p.Unmatched.Load() != 1 {
+		t.Errorf("late Response = %+v (Unmatched %d), want the CR row unchanged", row, p.Unmatched.Load())
+	}
+}
+
+func TestPairerOverflow(t *testing.T) {
+	logs := make(chan model.DNSLog, 10)
+	p := NewPairer(time.Second, 2, logs)
+	now := time.Now()
+	keys := []pairKey{
+		{addr: "192.0.2.1", port: 1000, id: 1, qname: "a.example."},
+		{addr: "192.0.2.1", port: 1001, id: 2, qname: "b.example."},
+		{addr: "192.0.2.1", port: 1002, id: 3, qname: "c.example."},
+	}
+	for i, key := range keys {
+		emit := p.Query(key, model.DNSLog{QName: key.qname}, now)
+		if emit != (i == 2) {
+			t.Errorf("Query %d: emit = %v, want the caller to emit only the third", i, emit)
+		}
+	}
+	if p.Overflow.Load() != 1 || p.Pending() != 2 || len(received(logs)) != 0 {
+		t.Errorf("Overflow = %d, Pending = %d, want 1 and 2 with nothing emitted by the pairer", p.Overflow.Load(), p.Pending())
+	}
+
+	// A response frees a slot.
+	if row := p.Response(keys[0], model.DNSLog{}, now); !row.Paired {
+		t.Fatal("pending query not paired")
+	}
+	if emit := p.Query(keys[2], model.DNSLog{}, now); emit {
+		t.Error("Query after a slot was freed: not pending")
+	}
+}
+
+func TestPairerRetransmit(t *testing.T) {
+	logs := make(chan model.DNSLog, 10)
+	p := NewPairer(time.Second, 10, logs)
+	key := pairKey{addr: "2001:db8::1", port: 40000, id: 99, qname: "example.net."}
+	first := time.Now()
+	second := first.Add(time.Second)
+
+	p.Query(key, model.DNSLog{Timestamp: "first"}, first)
+	if emit := p.Query(key, model.DNSLog{Timestamp: "second"}, second); emit {
+		t.Fatal("retransmission not pending")
+	}
+	// The older query goes out unpaired; the response belongs to the newer.
+	got := received(logs)
+	if len(got) != 1 || got[0].Timestamp != "first" || got[0].Paired {
+		t.Fatalf("emitted %+v, want the first query unpaired", got)
+	}
+	row := p.Response(key, model.DNSLog{}, second.Add(3*time.Millisecond))
+	if !row.Paired || row.Timestamp != "second" || row.LatencyUs != 3000 {
+		t.Errorf("Response = %+v, want the second query paired after 3ms", row)
+	}
+	if p.Expired.Load() != 1 || p.Paired.Load() != 1 || p.Pending() != 0 {
+		t.Errorf("Expired = %d, Paired = %d, Pending = %d, want 1, 1, 0", p.Expired.Load(), p.Paired.Load(), p.Pending())
+	}
+
+	// With LogChan full the evicted row is counted as dropped.
+	full := make(chan model.DNSLog)
+	p = NewPairer(time.Second, 10, full)
+	p.Query(key, model.DNSLog{}, first)
+	p.Query(key, model.DNSLog{}, second)
+	if p.Dropped.Load() != 1 {
+		t.Errorf("Dropped = %d, want 1", p.Dropped.Load())
+	}
+}
+
+func TestPairerUnmatched(t *testing.T) {
+	logs := make(chan model.DNSLog, 10)
+	p := NewPairer(time.Second, 10, logs)
+	key := pairKey{addr: "192.0.2.1", port: 53000, id: 1, qname: "example.com."}
+	p.Query(key, model.DNSLog{ResponseType: "CQ"}, time.Now())
+
+	// Any field of the key differing means another transaction.
+	for _, other := range []pairKey{
+		{addr: "192.0.2.2", port: 53000, id: 1, qname: "example.com."},
+		{addr: "192.0.2.1", port: 53001, id: 1, qname: "example.com."},
+		{addr: "192.0.2.1", port: 53000, id: 2, qname: "example.com."},
+		{addr: "192.0.2.1", port: 53000, id: 1, qname: "example.org."},
+	} {
+		row := p.Response(other, model.DNSLog{ResponseType: "CR", RCode: 2}, time.Now())
+		if row.Paired || row.ResponseType != "CR" || row.RCode != 2 {
+			t.Errorf("Response for %+v = %+v, want the CR row unchanged", other, row)
+		}
+	}
+	if p.Unmatched.Load() != 4 || p.Paired.Load() != 0 || p.Pending() != 1 {
+		t.Errorf("Unmatched = %d, Paired = %d, Pending = %d, want 4, 0, 1", p.Unmatched.Load(), p.Paired.Load(), p.Pending())
+	}
+	if got := received(logs); len(got) != 0 {
+		t.Errorf("pairer emitted %+v, want responses left to the caller", got)
+	}
+}
+
+func TestPairerStop(t *testing.T) {
+	logs := make(chan model.DNSLog, 10)
+	p := NewPairer(time.Hour, 10, logs)
+	p.Start()
+	for i := range 3 {
+		p.Query(pairKey{addr: "192.0.2.1", port: uint32(1000 + i), id: 1, qname: "example.com."}, model.DNSLog{QueryPort: uint16(1000 + i)}, time.Now())
+	}
+	p.Stop()
+
+	got := received(logs)
+	if len(got) != 3 || p.Pending() != 0 {
+		t.Fatalf("Stop emitted %d rows with %d pending, want all 3 flushed", len(got), p.Pending())
+	}
+	for _, row := range got {
+		if row.Paired {
+			t.Errorf("flushed row %+v is marked paired", row)
+		}
+	}
+}
@@ -2,75 +2,326 @@ package collector
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
-// ParseHeaderAndQuestion extracts RCODE, QName, and QType from a DNS packet
-// without parsing the entire message (Authorities, Additionals, etc.)
+// Parse errors. Callers count them as malformed packets.
+var (
+	ErrTruncated       = errors.New("buffer overflow: packet truncated")
+	ErrBadPointer      = errors.New("invalid compression pointer")
+	ErrTooManyPointers = errors.New("too many compression pointers")
+	ErrLabelType       = errors.New("unsupported label type")
+	ErrNameTooLong     = errors.New("name exceeds 255 octets")
+)
+
+// maxPointerHops bounds compression pointer chasing. A legitimate name has at
+// most 127 labels, so anything beyond that is a loop or garbage.
+const maxPointerHops = 128
+
+// maxNameWire is the RFC 1035 limit on the wire length of a name.
+const maxNameWire = 255
+
+// Header flag bits, at their position in the second 16-bit word of the
+// DNS header. The stored flags value keeps this layout.
+const (
+	FlagQR uint16 = 1 << 15 // response
+	FlagAA uint16 = 1 << 10 // authoritative answer
+	FlagTC uint16 = 1 << 9  // truncated
+	FlagRD uint16 = 1 << 8  // recursion desired
+	FlagRA uint16 = 1 << 7  // recursion available
+	FlagAD uint16 = 1 << 5  // authentic data
+	FlagCD uint16 = 1 << 4  // checking disabled
+
+	flagMask = FlagQR | FlagAA | FlagTC | FlagRD | FlagRA | FlagAD | FlagCD
+)
+
+// Header holds the decoded flags word of a DNS message.
+type Header struct {
+	RCode  uint8  // 0..15
+	Opcode uint8  // 0 QUERY, 4 NOTIFY, 5 UPDATE, ...
+	Flags  uint16 // FlagQR | FlagAA | ... (opcode and rcode bits cleared)
+}
+
+// ParseHeaderAndQuestion extracts the header flags, QName, and QType from a
+// DNS packet without parsing the entire message (Authorities, Additionals, etc.)
 // This is significantly faster than dns.Msg.Unpack for logging purposes.
-func ParseHeaderAndQuestion(payload []byte) (rcode uint8, qname string, qtype uint16, err error) {
+// Compressed qnames are followed. On error, the header is still returned
+// when it was readable.
+func ParseHeaderAndQuestion(payload []byte) (hdr Header, qname string, qtype uint16, err error) {
 	if len(payload) < 12 {
-		return 0, "", 0, errors.New("packet too short")
+		return hdr, "", 0, ErrTruncated
 	}
 
 	// ID (2), Flags (2), QDCOUNT (2), ANCOUNT (2), NSCOUNT (2), ARCOUNT (2)
-	// Flags is at offset 2. RCODE is lower 4 bits of flags[1] (byte index 3)
-	// Note: Start of payload is index 0.
-	// Flags: payload[2], payload[3]
-	// Rcode is bits 0-3 of payload[3]
-	rcode = payload[3] & 0x0F
+	// Flags: QR(1) OPCODE(4) AA TC RD | RA Z AD CD RCODE(4)
+	flags := binary.BigEndian.Uint16(payload[2:4])
+	hdr = Header{
+		RCode:  uint8(flags & 0x0F),
+		Opcode: uint8(flags>>11) & 0x0F,
+		Flags:  flags & flagMask,
+	}
 
 	qdcount := binary.BigEndian.Uint16(payload[4:6])
 	if qdcount == 0 {
-		return rcode, "", 0, nil
+		return hdr, "", 0, nil
 	}
 
 	// Parse first question
 	// Offset 12 is start of Question section
-	pos := 12
-	var sb strings.Builder
+	qname, pos, err := readName(payload, 12)
+	if err != nil {
+		return hdr, "", 0, err
+	}
+
+	// After QNAME comes QTYPE (2 bytes) and QCLASS (2 bytes)
+	if pos+4 > len(payload) {
+		return hdr, "", 0, ErrTruncated
+	}
+
+	qtype = binary.BigEndian.Uint16(payload[pos : pos+2])
+
+	return hdr, qname, qtype, nil
+}
+
+// readName decodes a (possibly compressed) domain name starting at pos.
+// It returns the name without the trailing dot and the offset just past the
+// name in the original position (i.e. after the first pointer, if any).
+// The name is assembled in a stack buffer so the only allocation is the
+// final string.
+func readName(payload []byte, pos int) (string, int, error) {
+	var buf [maxNameWire]byte
+	n := 0     // bytes written to buf
+	wire := 0  // uncompressed wire length so far
+	next := -1 // offset after the name in the original position
+	hops := 0
+	limit := pos // every pointer must target strictly before this offset
 
-	// Loop over labels
 	for {
 		if pos >= len(payload) {
-			return 0, "", 0, errors.New("buffer overflow parsing qname")
+			return "", 0, ErrTruncated
 		}
 
 		length := int(payload[pos])
-		pos++
+		switch length & 0xC0 {
+		case 0x00:
+			pos++
+			wire += 1 + length
+			if wire > maxNameWire {
+				return "", 0, ErrNameTooLong
+			}
+			if length == 0 {
+				if next < 0 {
+					next = pos
+				}
+				return string(buf[:n]), next, nil
+			}
+			if pos+length > len(payload) {
+				return "", 0, ErrTruncated
+			}
+			if n > 0 {
+				buf[n] = '.'
+				n++
+			}
+			n += copy(buf[n:], payload[pos:pos+length])
+			pos += length
 
-		if length == 0 {
-			break // End of name
-		}
+		case 0xC0:
+			if pos+1 >= len(payload) {
+				return "", 0, ErrTruncated
+			}
+			hops++
+			if hops > maxPointerHops {
+				return "", 0, ErrTooManyPointers
+			}
+			if next < 0 {
+				next = pos + 2
+			}
+			ptr := int(binary.BigEndian.Uint16(payload[pos:pos+2]) & 0x3FFF)
+			// Each pointer must jump before the previous jump target, so the
+			// chain strictly moves backwards and cannot loop.
+			if ptr >= limit {
+				return "", 0, ErrBadPointer
+			}
+			limit = ptr
+			pos = ptr
 
-		// Handle compression pointers (highly unlikely in Question section, but possible in theory)
-		if (length & 0xC0) == 0xC0 {
-			// For logging purposes we just stop here or implement full decompression.
-			// Given its the QUESTION section of a query/response, pointers are rare/illegal for the first name.
-			// We'll treat it as end for simplicity in this optimization.
-			return 0, "", 0, errors.New("compression not supported in fast parser")
+		default:
+			return "", 0, ErrLabelType
 		}
+	}
+}
 
-		if pos+length > len(payload) {
-			return 0, "", 0, errors.New("buffer overflow parsing label")
+// skipName advances past a (possibly compressed) domain name.
+func skipName(payload []byte, pos int) (int, error) {
+	for {
+		if pos >= len(payload) {
+			return 0, ErrTruncated
 		}
+		length := int(payload[pos])
+		switch length & 0xC0 {
+		case 0x00:
+			pos += 1 + length
+			if length == 0 {
+				return pos, nil
+			}
+		case 0xC0:
+			if pos+2 > len(payload) {
+				return 0, ErrTruncated
+			}
+			return pos + 2, nil
+		default:
+			return 0, ErrLabelType
+		}
+	}
+}
 
-		if sb.Len() > 0 {
-			sb.WriteByte('.')
+// Answer is one resource record from the answer section.
+type Answer struct {
+	Type uint16
+	TTL  uint32
+	Data string // presentation format of the RDATA
+}
+
+// ParseAnswers extracts up to maxAnswers records from the answer section.
+// The question section is skipped, compressed names are followed.
+func ParseAnswers(payload []byte, maxAnswers int) ([]Answer, error) {
+	if len(payload) < 12 {
+		return nil, ErrTruncated
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(payload[4:6]))
+	ancount := int(binary.BigEndian.Uint16(payload[6:8]))
+	if ancount == 0 {
+		return nil, nil
+	}
+
+	pos := 12
+	for i := 0; i < qdcount; i++ {
+		var err error
+		if pos, err = skipName(payload, pos); err != nil {
+			return nil, err
 		}
-		sb.Write(payload[pos : pos+length])
-		pos += length
+		pos += 4 // QTYPE + QCLASS
 	}
 
-	qname = sb.String()
+	if ancount > maxAnswers {
+		ancount = maxAnswers
+	}
+	answers := make([]Answer, 0, ancount)
 
-	// After QNAME comes QTYPE (2 bytes) and QCLASS (2 bytes)
-	if pos+4 > len(payload) {
-		return 0, "", 0, errors.New("buffer overflow reading qtype")
+	for i := 0; i < ancount; i++ {
+		var err error
+		if pos, err = skipName(payload, pos); err != nil {
+			return answers, err
+		}
+		// TYPE (2), CLASS (2), TTL (4), RDLENGTH (2)
+		if pos+10 > len(payload) {
+			return answers, ErrTruncated
+		}
+		rrtype := binary.BigEndian.Uint16(payload[pos : pos+2])
+		ttl := binary.BigEndian.Uint32(payload[pos+4 : pos+8])
+		rdlen := int(binary.BigEndian.Uint16(payload[pos+8 : pos+10]))
+		pos += 10
+		if pos+rdlen > len(payload) {
+			return answers, ErrTruncated
+		}
+
+		data, err := formatRData(payload, rrtype, pos, rdlen)
+		if err != nil {
+			return answers, err
+		}
+		answers = append(answers, Answer{Type: rrtype, TTL: ttl, Data: data})
+		pos += rdlen
 	}
 
-	qtype = binary.BigEndian.Uint16(payload[pos : pos+2])
+	return answers, nil
+}
+
+// formatRData renders RDATA in presentation format. Unknown types use the
+// RFC 3597 generic encoding.
+func formatRData(payload []byte, rrtype uint16, pos, rdlen int) (string, error) {
+	rdata := payload[pos : pos+rdlen]
+
+	switch rrtype {
+	case 1: // A
+		if rdlen != 4 {
+			return "", errors.New("bad A rdata length")
+		}
+		return net.IP(rdata).String(), nil
 
-	return rcode, qname, qtype, nil
+	case 28: // AAAA
+		if rdlen != 16 {
+			return "", errors.New("bad AAAA rdata length")
+		}
+		return net.IP(rdata).String(), nil
+
+	case 2, 5, 12, 39: // NS, CNAME, PTR, DNAME
+		name, _, err := readName(payload, pos)
+		return name, err
+
+	case 15: // MX
+		if rdlen < 3 {
+			return "", errors.New("bad MX rdata length")
+		}
+		name, _, err := readName(payload, pos+2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(rdata[0:2]))) + " " + name, nil
+
+	case 33: // SRV
+		if rdlen < 7 {
+			return "", errors.New("bad SRV rdata length")
+		}
+		name, _, err := readName(payload, pos+6)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s",
+			binary.BigEndian.Uint16(rdata[0:2]),
+			binary.BigEndian.Uint16(rdata[2:4]),
+			binary.BigEndian.Uint16(rdata[4:6]),
+			name), nil
+
+	case 16: // TXT
+		var parts []string
+		for i := 0; i < rdlen; {
+			l := int(rdata[i])
+			i++
+			if i+l > rdlen {
+				return "", errors.New("bad TXT rdata length")
+			}
+			parts = append(parts, strconv.Quote(string(rdata[i:i+l])))
+			i += l
+		}
+		return strings.Join(parts, " "), nil
+
+	case 6: // SOA
+		mname, next, err := readName(payload, pos)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := readName(payload, next)
+		if err != nil {
+			return "", err
+		}
+		if next+20 > pos+rdlen {
+			return "", errors.New("bad SOA rdata length")
+		}
+		v := payload[next : next+20]
+		return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname,
+			binary.BigEndian.Uint32(v[0:4]),
+			binary.BigEndian.Uint32(v[4:8]),
+			binary.BigEndian.Uint32(v[8:12]),
+			binary.BigEndian.Uint32(v[12:16]),
+			binary.BigEndian.Uint32(v[16:20])), nil
+
+	default:
+		return fmt.Sprintf("\\# %d %s", rdlen, hex.EncodeToString(rdata)), nil
+	}
 }
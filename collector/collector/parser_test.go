@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// packMsg builds a wire-format message with miekg/dns, compressed when
+// compress is set.
+func packMsg(tb testing.TB, compress bool, qname string, qtype uint16, answers ...string) []byte {
+	tb.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(qname), qtype)
+	m.RecursionDesired = true
+	for _, a := range answers {
+		rr, err := dns.NewRR(a)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	if len(answers) > 0 {
+		m.Response = true
+	}
+	m.Compress = compress
+	b, err := m.Pack()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+// question returns a header asking for one question, followed by body.
+func question(body ...byte) []byte {
+	return append([]byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}, body...)
+}
+
+func TestParseHeaderAndQuestion(t *testing.T) {
+	compressed := packMsg(t, true, "www.example.com", dns.TypeAAAA,
+		"www.example.com. 60 IN CNAME cdn.example.com.",
+		"cdn.example.com. 60 IN AAAA 2001:db8::1")
+
+	tests := []struct {
+		name    string
+		payload []byte
+		qname   string
+		qtype   uint16
+		err     error
+	}{
+		{name: "plain", payload: packMsg(t, false, "www.example.com", dns.TypeA), qname: "www.example.com", qtype: dns.TypeA},
+		{name: "compressed response", payload: compressed, qname: "www.example.com", qtype: dns.TypeAAAA},
+		{name: "root", payload: question(0, 0, 2, 0, 1), qname: "", qtype: dns.TypeNS},
+		{name: "no question", payload: []byte{0, 0, 0x81, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{name: "short header", payload: []byte{0, 0, 1}, err: ErrTruncated},
+		{name: "truncated label", payload: question(3, 'w', 'w'), err: ErrTruncated},
+		{name: "missing qtype", payload: question(1, 'a', 0, 0), err: ErrTruncated},
+		{name: "pointer to itself", payload: question(0xC0, 12, 0, 1, 0, 1), err: ErrBadPointer},
+		{name: "forward pointer", payload: question(0xC0, 14, 0, 0, 1, 0, 1), err: ErrBadPointer},
+		{name: "truncated pointer", payload: question(0xC0), err: ErrTruncated},
+		{name: "extended label type", payload: question(0x40, 0, 0, 1, 0, 1), err: ErrLabelType},
+		{name: "name too long", payload: question(append(append([]byte{}, []byte(strings.Repeat("\x3f"+strings.Repeat("a", 63), 4))...), 0, 0, 1, 0, 1)...), err: ErrNameTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, qname, qtype, err := ParseHeaderAndQuestion(tt.payload)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && (qname != tt.qname || qtype != tt.qtype) {
+				t.Fatalf("got %q/%d, want %q/%d", qname, qtype, tt.qname, tt.qtype)
+			}
+		})
+	}
+}
+
+func TestParseHeaderAndQuestionPointerChain(t *testing.T) {
+	// example.com at 12, then "www" and a pointer back to it at 25.
+	payload := question(7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0)
+	payload = append(payload, 3, 'w', 'w', 'w', 0xC0, 12, 0, 1, 0, 1)
+	qname, next, err := readName(payload, 25)
+	if err != nil || qname != "www.example.com" || next != 31 {
+		t.Fatalf("readName = %q, %d, %v", qname, next, err)
+	}
+}
+
+func TestParseHeaderAndQuestionAllocs(t *testing.T) {
+	payload := packMsg(t, true, "www.example.com", dns.TypeA, "www.example.com. 60 IN A 192.0.2.1")
+	allocs := testing.AllocsPerRun(1000, func() {
+		ParseHeaderAndQuestion(payload)
+	})
+	if allocs > 1 {
+		t.Fatalf("%v allocations per parse, want at most 1 (the qname)", allocs)
+	}
+}
+
+// FuzzParse checks that the fast parser never panics and, whenever both
+// it and miekg/dns decode a packet, agrees with dns.Msg.Unpack on the
+// first question. The fast parser may reject packets miekg/dns accepts
+// (forward compression pointers), never the other way round for the
+// question itself.
+func FuzzParse(f *testing.F) {
+	f.Add(packMsg(f, false, "www.example.com", dns.TypeA))
+	f.Add(packMsg(f, true, "www.example.com", dns.TypeAAAA,
+		"www.example.com. 60 IN CNAME cdn.example.com.",
+		"cdn.example.com. 60 IN AAAA 2001:db8::1"))
+	f.Add(packMsg(f, true, "_dns-sd._udp.local", dns.TypePTR))
+	f.Add(question(0xC0, 12, 0, 1, 0, 1))
+	f.Add(question(7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 3, 'w', 'w', 'w', 0xC0, 12, 0, 1, 0, 1))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		_, qname, qtype, err := ParseHeaderAndQuestion(payload)
+		ParseAnswers(payload, 16)
+		if err != nil {
+			return
+		}
+
+		var m dns.Msg
+		if m.Unpack(payload) != nil || len(m.Question) == 0 {
+			return
+		}
+		want := m.Question[0].Name
+		if strings.ContainsRune(want, '\\') {
+			// miekg/dns escapes unusual bytes; the fast parser keeps them raw.
+			return
+		}
+		if got := qname + "."; got != want && !(qname == "" && want == ".") {
+			t.Fatalf("qname = %q, miekg/dns = %q", got, want)
+		}
+		if qtype != m.Question[0].Qtype {
+			t.Fatalf("qtype = %d, miekg/dns = %d", qtype, m.Question[0].Qtype)
+		}
+	})
+}
+
+// The benchmarks come in pairs: the fast parser and dns.Msg.Unpack on the
+// same packet.
+
+func BenchmarkParse(b *testing.B) {
+	payload := packMsg(b, false, "www.example.com", dns.TypeA)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, _, err := ParseHeaderAndQuestion(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMiekgUnpack(b *testing.B) {
+	payload := packMsg(b, false, "www.example.com", dns.TypeA)
+	b.ReportAllocs()
+	for b.Loop() {
+		var m dns.Msg
+		if err := m.Unpack(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseCompressed(b *testing.B) {
+	payload := packMsg(b, true, "www.example.com", dns.TypeAAAA,
+		"www.example.com. 60 IN CNAME cdn.example.com.",
+		"cdn.example.com. 60 IN AAAA 2001:db8::1")
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, _, err := ParseHeaderAndQuestion(payload); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ParseAnswers(payload, 16); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMiekgUnpackCompressed(b *testing.B) {
+	payload := packMsg(b, true, "www.example.com", dns.TypeAAAA,
+		"www.example.com. 60 IN CNAME cdn.example.com.",
+		"cdn.example.com. 60 IN AAAA 2001:db8::1")
+	b.ReportAllocs()
+	for b.Loop() {
+		var m dns.Msg
+		if err := m.Unpack(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
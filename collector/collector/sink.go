@@ -0,0 +1,179 @@
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// Sink is an output for DNS rows. Each sink reads its own channel, created
+// by Fanout.Add, so a slow sink only fills its own buffer.
+type Sink interface {
+	// Worker consumes the sink's channel until it is closed, flushes and
+	// returns.
+	Worker()
+}
+
+// FanoutOutput is one sink attached to a Fanout.
+type FanoutOutput struct {
+	Name    string
+	C       chan model.DNSLog
+	Sink    Sink
+	Dropped atomic.Uint64 // rows dropped because C was full
+
+	// Anonymizer, if set, rewrites client addresses of this output's copy
+	// of each row. It can be swapped while running (config reload).
+	Anonymizer atomic.Pointer[Anonymizer]
+}
+
+// Fanout copies every row from In to all outputs. Sends are non-blocking:
+// when an output's buffer is full the row is dropped for that sink only.
+type Fanout struct {
+	In      <-chan model.DNSLog
+	Outputs []*FanoutOutput
+
+	// Filter, if set, drops excluded rows and samples the rest. It can be
+	// swapped while running (config reload).
+	Filter      atomic.Pointer[RowFilter]
+	Filtered    atomic.Uint64 // rows dropped by exclusion rules
+	SampledOut  atomic.Uint64 // rows dropped by sampling
+	RateLimited atomic.Uint64 // rows folded into collapsed rows by rate limits
+	Collapsed   atomic.Uint64 // collapsed rows sent
+
+	wg sync.WaitGroup
+}
+
+// NewFanout creates a Fanout reading from in.
+func NewFanout(in <-chan model.DNSLog) *Fanout {
+	return &Fanout{In: in}
+}
+
+// Add creates an output channel with the given buffer size. The caller
+// builds the sink on top of out.C and sets out.Sink before Start.
+func (f *Fanout) Add(name string, bufferSize int) *FanoutOutput {
+	out := &FanoutOutput{
+		Name: name,
+		C:    make(chan model.DNSLog, bufferSize),
+	}
+	f.Outputs = append(f.Outputs, out)
+	return out
+}
+
+// Start launches every sink worker and the distribution loop.
+func (f *Fanout) Start() {
+	for _, out := range f.Outputs {
+		f.wg.Add(1)
+		go func(s Sink) {
+			defer f.wg.Done()
+			s.Worker()
+		}(out.Sink)
+	}
+
+	go func() {
+		// Rate-limited rows are released as collapsed rows every second.
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var rf *RowFilter
+		for {
+			select {
+			case row, ok := <-f.In:
+				if !ok {
+					// In closed: release held rows, then let every sink
+					// drain and exit.
+					f.flushHeld(rf)
+					for _, out := range f.Outputs {
+						close(out.C)
+					}
+					return
+				}
+				if next := f.Filter.Load(); next != rf {
+					f.flushHeld(rf)
+					rf = next
+				}
+				if rf != nil {
+					if rf.Exclude(&row) {
+						f.Filtered.Add(1)
+						continue
+					}
+					switch rf.Sample(&row) {
+					case SampleDrop:
+						f.SampledOut.Add(1)
+						continue
+					case SampleFolded:
+						f.RateLimited.Add(1)
+						continue
+					}
+				}
+				f.send(row)
+
+			case <-ticker.C:
+				f.flushHeld(rf)
+				rf = f.Filter.Load()
+			}
+		}
+	}()
+}
+
+// send copies row to every output without blocking.
+func (f *Fanout) send(row model.DNSLog) {
+	for _, out := range f.Outputs {
+		r := row
+		out.Anonymizer.Load().Apply(&r)
+		select {
+		case out.C <- r:
+		default:
+			out.Dropped.Add(1)
+		}
+	}
+}
+
+// flushHeld sends the collapsed rows of rate-limited clients and names.
+func (f *Fanout) flushHeld(rf *RowFilter) {
+	if rf == nil {
+		return
+	}
+	rf.Flush(func(row model.DNSLog) {
+		f.Collapsed.Add(1)
+		f.send(row)
+	})
+}
+
+// Wait blocks until In is closed and every sink has flushed.
+func (f *Fanout) Wait() {
+	f.wg.Wait()
+}
+
+// batchLoop collects rows from in and calls flush when size rows are
+// buffered, every interval, and once more when in is closed. flush must
+// not keep the slice.
+func batchLoop(in <-chan model.DNSLog, size int, interval time.Duration, flush func([]model.DNSLog)) {
+	batch := make([]model.DNSLog, 0, size)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case row, ok := <-in:
+			if !ok {
+				if len(batch) > 0 {
+					flush(batch)
+				}
+				return
+			}
+			batch = append(batch, row)
+			if len(batch) >= size {
+				flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spoolPrefix = "batch-"
+	spoolSuffix = ".ndjson"
+
+	// deadLetterDir is the subdirectory of Dir holding segments that
+	// ClickHouse rejected for good.
+	deadLetterDir = "dead-letter"
+)
+
+// Spool is an on-disk write-ahead queue for batches that could not be
+// delivered to ClickHouse. Every batch is stored as a single NDJSON segment
+// file; segments are replayed oldest first and survive collector restarts.
+// Replay runs alongside the live inserts, so the order between spooled and
+// live batches is not kept.
+// The spool is bounded by total size and segment age: when a bound is
+// exceeded the oldest segments are discarded and counted in DroppedBatches
+// and DroppedRows. Segments that can never be inserted are moved to the
+// dead-letter subdirectory instead (see DeadLetter), which is not bounded.
+type Spool struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+
+	DroppedBatches    atomic.Uint64
+	DroppedRows       atomic.Uint64
+	DeadLetterBatches atomic.Uint64
+	DeadLetterRows    atomic.Uint64
+
+	mu       sync.Mutex
+	segments []spoolSegment // oldest first
+	size     int64
+	nextSeq  uint64
+	ready    chan struct{}
+}
+
+type spoolSegment struct {
+	name    string
+	seq     uint64
+	rows    int
+	size    int64
+	created time.Time
+}
+
+// NewSpool opens (or creates) a spool directory and indexes the segments
+// left over from a previous run.
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir %s: %w", dir, err)
+	}
+
+	s := &Spool{
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		MaxAge:   maxAge,
+		ready:    make(chan struct{}, 1),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool dir %s: %w", dir, err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		// Leftovers of an interrupted write: never renamed, never complete.
+		if strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, rows, ok := parseSegmentName(name)
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{
+			name:    name,
+			seq:     seq,
+			rows:    rows,
+			size:    info.Size(),
+			created: info.ModTime(),
+		})
+		s.size += info.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	s.mu.Lock()
+	s.pruneLocked(time.Now())
+	s.mu.Unlock()
+
+	if len(s.segments) > 0 {
+		log.Printf("Spool: recovered %d pending batches (%d bytes) from %s", len(s.segments), s.size, dir)
+		s.signal()
+	}
+
+	return s, nil
+}
+
+// segmentName encodes the sequence number and row count so that the index
+// can be rebuilt from a directory listing alone.
+func segmentName(seq uint64, rows int) string {
+	return fmt.Sprintf("%s%020d-%d%s", spoolPrefix, seq, rows, spoolSuffix)
+}
+
+func parseSegmentName(name string) (seq uint64, rows int, ok bool) {
+	if !strings.HasPrefix(name, spoolPrefix) || !strings.HasSuffix(name, spoolSuffix) {
+		return 0, 0, false
+	}
+	core := strings.TrimSuffix(strings.TrimPrefix(name, spoolPrefix), spoolSuffix)
+	seqStr, rowsStr, found := strings.Cut(core, "-")
+	if !found {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	rows, err = strconv.Atoi(rowsStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	return seq, rows, true
+}
+
+// Put persists an encoded batch. The file is written under a temporary
+// name, synced and then renamed, so a crash never leaves a partial segment.
+func (s *Spool) Put(body []byte, rows int) error {
+	s.mu.Lock()
+	seq := s.nextSeq
+	s.nextSeq++
+	s.mu.Unlock()
+
+	name := segmentName(seq, rows)
+	path := filepath.Join(s.Dir, name)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	s.mu.Lock()
+	s.segments = append(s.segments, spoolSegment{
+		name:    name,
+		seq:     seq,
+		rows:    rows,
+		size:    int64(len(body)),
+		created: time.Now(),
+	})
+	s.size += int64(len(body))
+	s.pruneLocked(time.Now())
+	s.mu.Unlock()
+
+	s.signal()
+	return nil
+}
+
+// Peek returns the oldest pending segment and its contents.
+func (s *Spool) Peek() (spoolSegment, []byte, bool) {
+	for {
+		s.mu.Lock()
+		s.pruneLocked(time.Now())
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return spoolSegment{}, nil, false
+		}
+		seg := s.segments[0]
+		s.mu.Unlock()
+
+		body, err := os.ReadFile(filepath.Join(s.Dir, seg.name))
+		if err == nil {
+			return seg, body, true
+		}
+
+		// Unreadable segment: count it as lost and move on.
+		log.Printf("Spool: dropping unreadable segment %s: %v", seg.name, err)
+		if s.Remove(seg) {
+			s.DroppedBatches.Add(1)
+			s.DroppedRows.Add(uint64(seg.rows))
+		}
+	}
+}
+
+// Remove deletes a segment after it has been delivered. It reports false if
+// the segment was already gone (e.g. evicted by the size or age bound).
+func (s *Spool) Remove(seg spoolSegment) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.segments {
+		if s.segments[i].seq == seg.seq {
+			s.size -= s.segments[i].size
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			_ = os.Remove(filepath.Join(s.Dir, seg.name))
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter moves a segment that ClickHouse rejected permanently out of
+// the replay queue into Dir/dead-letter, next to a .err file with the
+// reason. The segment keeps its name, so after fixing the cause it can be
+// moved back into Dir and is replayed on the next start.
+func (s *Spool) DeadLetter(seg spoolSegment, reason error) error {
+	dir := filepath.Join(s.Dir, deadLetterDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.segments {
+		if s.segments[i].seq != seg.seq {
+			continue
+		}
+		if err := os.Rename(filepath.Join(s.Dir, seg.name), filepath.Join(dir, seg.name)); err != nil {
+			return err
+		}
+		_ = os.WriteFile(filepath.Join(dir, strings.TrimSuffix(seg.name, spoolSuffix)+".err"), []byte(reason.Error()+"\n"), 0640)
+		s.size -= s.segments[i].size
+		s.segments = append(s.segments[:i], s.segments[i+1:]...)
+		s.DeadLetterBatches.Add(1)
+		s.DeadLetterRows.Add(uint64(seg.rows))
+		return nil
+	}
+	return nil
+}
+
+// Len returns the number of pending segments.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments)
+}
+
+// Bytes returns the total size of pending segments.
+func (s *Spool) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Ready is signalled whenever a new segment is available for replay.
+func (s *Spool) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pruneLocked enforces MaxAge and MaxBytes by discarding the oldest segments.
+// The newest segment is always kept so that a single oversized batch is
+// still retried.
+func (s *Spool) pruneLocked(now time.Time) {
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		expired := s.MaxAge > 0 && now.Sub(seg.created) > s.MaxAge
+		overflow := s.MaxBytes > 0 && s.size > s.MaxBytes && len(s.segments) > 1
+		if !expired && !overflow {
+			return
+		}
+
+		s.segments = s.segments[1:]
+		s.size -= seg.size
+		_ = os.Remove(filepath.Join(s.Dir, seg.name))
+		s.DroppedBatches.Add(1)
+		s.DroppedRows.Add(uint64(seg.rows))
+
+		reason := "size limit"
+		if expired {
+			reason = "max age"
+		}
+		log.Printf("Spool: discarded %d rows (%s)", seg.rows, reason)
+	}
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"flag"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/ch-go/compress"
+	"github.com/ClickHouse/ch-go/proto"
+
+	"dnsdist-collector/model"
+)
+
+// Addresses of a scratch ClickHouse for the end-to-end writer benchmarks;
+// rows are inserted into dns.dns_logs.
+//
+//	go test ./collector -run '^$' -bench Writer -benchtime 2000000x -bench.http 127.0.0.1:8123 -bench.native 127.0.0.1:9000
+//
+// The encoding cost comparison times both encoders, which is too noisy on
+// a busy machine for the default run:
+//
+//	go test ./collector -run TestNativeEncodingCheaper -v -bench.compare
+var (
+	benchHTTP    = flag.String("bench.http", "", "BenchmarkWriter: ClickHouse HTTP address (empty skips the JSONEachRow run)")
+	benchNative  = flag.String("bench.native", "", "BenchmarkWriter: ClickHouse native address (empty skips the native run)")
+	benchCompare = flag.Bool("bench.compare", false, "TestNativeEncodingCheaper: compare the encoders' cost per row")
+)
+
+const benchBatch = 10000
+
+// encodeNative does the native writer's CPU work for one batch: fill the
+// column block, serialize it and LZ4-compress it, as ch-go does on send.
+func encodeNative(b *dnsBlock, buf *proto.Buffer, cw *compress.Writer, logs []model.DNSLog) error {
+	if err := b.fill(logs); err != nil {
+		return err
+	}
+	input := b.input()
+	buf.Reset()
+	block := proto.Block{Columns: len(input), Rows: len(logs)}
+	if err := block.EncodeBlock(buf, proto.Version, input); err != nil {
+		return err
+	}
+	return cw.Compress(buf.Buf)
+}
+
+func BenchmarkEncodeJSONEachRow(b *testing.B) {
+	logs := syntheticLogs(benchBatch)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := encodeBatch(logs); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBatch), "ns/row")
+}
+
+func BenchmarkEncodeNative(b *testing.B) {
+	logs := syntheticLogs(benchBatch)
+	block, buf, cw := newDNSBlock(), new(proto.Buffer), compress.NewWriter(compress.LevelZero, compress.LZ4)
+	// On a real connection the server supplies the enum types.
+	if err := block.responseType.Infer("Enum8('CQ' = 1, 'CR' = 2)"); err != nil {
+		b.Fatal(err)
+	}
+	if err := block.anonymized.Infer("Enum8('none' = 0, 'truncate' = 1, 'hmac' = 2)"); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := encodeNative(block, buf, cw, logs); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBatch), "ns/row")
+}
+
+// TestNativeEncodingCheaper holds the reason the native writer exists: its
+// per-row encoding cost must stay well below JSONEachRow's.
+func TestNativeEncodingCheaper(t *testing.T) {
+	if !*benchCompare {
+		t.Skip("needs -bench.compare")
+	}
+	jsonRes := testing.Benchmark(BenchmarkEncodeJSONEachRow)
+	nativeRes := testing.Benchmark(BenchmarkEncodeNative)
+	if jsonRes.N == 0 || nativeRes.N == 0 {
+		t.Fatal("benchmark failed; run it with -bench Encode for the error")
+	}
+	jsonNs, nativeNs := jsonRes.Extra["ns/row"], nativeRes.Extra["ns/row"]
+	t.Logf("JSONEachRow %.0f ns/row, native %.0f ns/row", jsonNs, nativeNs)
+	if nativeNs > jsonNs/2 {
+		t.Errorf("native encoding takes %.0f ns/row, want at most half of JSONEachRow's %.0f", nativeNs, jsonNs)
+	}
+}
+
+// BenchmarkWriter pushes rows through a ClickHouseWriter exactly as the
+// collector does and reports throughput and process CPU time per million
+// rows for each insert path.
+func BenchmarkWriter(b *testing.B) {
+	if *benchHTTP == "" && *benchNative == "" {
+		b.Skip("needs -bench.http and/or -bench.native")
+	}
+	run := func(b *testing.B, native *NativeInserter) {
+		logs := syntheticLogs(b.N)
+		logChan := make(chan model.DNSLog, benchBatch)
+		w, err := NewClickHouseWriter(*benchHTTP, ClickHouseOptions{}, logChan)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w.BatchSize = benchBatch
+		w.FlushInterval = time.Hour // flush on size only
+		w.Native = native
+
+		cpuStart := cpuTime()
+		b.ResetTimer()
+		go w.Worker()
+		for _, l := range logs {
+			logChan <- l
+		}
+		close(logChan)
+		<-w.Done
+		b.StopTimer()
+		cpu := cpuTime() - cpuStart
+		if native != nil {
+			_ = native.Close()
+		}
+
+		if failed := w.BatchesFailed.Load(); failed > 0 {
+			b.Fatalf("%d batches failed", failed)
+		}
+		inserted := float64(w.RowsInserted.Load())
+		b.ReportMetric(inserted/b.Elapsed().Seconds(), "rows/s")
+		b.ReportMetric(float64(cpu.Milliseconds())*1e6/inserted, "cpu-ms/1M-rows")
+	}
+	if *benchHTTP != "" {
+		b.Run("jsoneachrow", func(b *testing.B) { run(b, nil) })
+	}
+	if *benchNative != "" {
+		b.Run("native-lz4", func(b *testing.B) {
+			run(b, NewNativeInserter(*benchNative, ClickHouseOptions{}))
+		})
+	}
+}
+
+// cpuTime returns user+system CPU time of this process.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
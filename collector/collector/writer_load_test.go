@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+// Load test knobs. The load test depends on the machine, so it only runs
+// when given a rate; gate a release with the target rate, e.g.
+//
+//	go test ./collector -run TestWriterLoad -load.qps 200000 -load.duration 60s
+var (
+	loadQPS         = flag.Int("load.qps", 0, "TestWriterLoad: rows per second to offer (0 skips the test)")
+	loadDuration    = flag.Duration("load.duration", 3*time.Second, "TestWriterLoad: how long to generate load")
+	loadWorkers     = flag.Int("load.workers", 4, "TestWriterLoad: writer workers")
+	loadFakeLatency = flag.Duration("load.fake-latency", 200*time.Millisecond, "TestWriterLoad: response time of the fake ClickHouse per insert")
+	loadClickHouse  = flag.String("load.clickhouse", "", "TestWriterLoad: ClickHouse HTTP address (empty uses a fake; rows go to dns.dns_logs)")
+)
+
+// TestWriterLoad offers rows to the writer pipeline at a fixed rate, the
+// way a dnstap listener does, and fails if any row is dropped: on the way
+// in because the channel was full, or by the writer. The fake ClickHouse
+// reads each body and answers after -load.fake-latency, which isolates the
+// collector's own encoding and batching cost.
+func TestWriterLoad(t *testing.T) {
+	if *loadQPS <= 0 {
+		t.Skip("needs -load.qps")
+	}
+
+	addr := *loadClickHouse
+	var received atomic.Uint64
+	if addr == "" {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sc := bufio.NewScanner(r.Body)
+			sc.Buffer(make([]byte, 64*1024), 1<<20)
+			var n uint64
+			for sc.Scan() {
+				n++
+			}
+			time.Sleep(*loadFakeLatency)
+			received.Add(n)
+		}))
+		defer srv.Close()
+		addr = strings.TrimPrefix(srv.URL, "http://")
+	}
+
+	logChan := make(chan model.DNSLog, 100000)
+	w, err := NewClickHouseWriter(addr, ClickHouseOptions{}, logChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Workers = *loadWorkers
+	go w.Worker()
+
+	rows := syntheticLogs(100000)
+	var offered, dropped uint64
+
+	// Send in 1ms slices so the rate stays smooth at high QPS.
+	const tick = time.Millisecond
+	perTick := float64(*loadQPS) * tick.Seconds()
+	start := time.Now()
+	next := start
+	var owed float64
+	for time.Since(start) < *loadDuration {
+		owed += perTick
+		for ; owed >= 1; owed-- {
+			select {
+			case logChan <- rows[offered%uint64(len(rows))]:
+			default:
+				dropped++
+			}
+			offered++
+		}
+		next = next.Add(tick)
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	elapsed := time.Since(start)
+	close(logChan)
+	<-w.Done
+
+	t.Logf("offered %d rows in %s (%.0f rows/s), inserted %d, early flushes %d, stalls %d",
+		offered, elapsed.Round(time.Millisecond), float64(offered)/elapsed.Seconds(),
+		w.RowsInserted.Load(), w.EarlyFlushes.Load(), w.Stalls.Load())
+	if dropped > 0 || w.DroppedRows.Load() > 0 {
+		t.Errorf("dropped %d rows at the channel and %d in the writer, want 0", dropped, w.DroppedRows.Load())
+	}
+	if got := w.RowsInserted.Load(); got != offered-dropped {
+		t.Errorf("inserted %d rows, want %d", got, offered-dropped)
+	}
+	if *loadClickHouse == "" && received.Load() != w.RowsInserted.Load() {
+		t.Errorf("fake ClickHouse received %d rows, writer counted %d", received.Load(), w.RowsInserted.Load())
+	}
+}
+
+// TestWriterPipeline is the load test's default-run counterpart: a fixed
+// number of rows through several senders, with no timing involved. Every
+// row must reach the fake ClickHouse exactly once, in batches of at most
+// BatchSize, from at most Workers senders at a time.
+func TestWriterPipeline(t *testing.T) {
+	const rows, batchSize, workers = 20000, 1000, 4
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var inFlight, maxInFlight, maxBatch int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		time.Sleep(5 * time.Millisecond)
+		dec := json.NewDecoder(r.Body)
+		var n int
+		for {
+			var l model.DNSLog
+			if err := dec.Decode(&l); err == io.EOF {
+				break
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			seen[l.Timestamp]++
+			mu.Unlock()
+			n++
+		}
+		mu.Lock()
+		maxBatch = max(maxBatch, n)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	logChan := make(chan model.DNSLog, 5000)
+	w, err := NewClickHouseWriter(strings.TrimPrefix(srv.URL, "http://"), ClickHouseOptions{}, logChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.BatchSize, w.Workers = batchSize, workers
+	go w.Worker()
+	logs := syntheticLogs(rows)
+	for _, l := range logs {
+		logChan <- l
+	}
+	close(logChan)
+	<-w.Done
+
+	if got := w.RowsInserted.Load(); got != rows || w.DroppedRows.Load() != 0 || w.BatchesFailed.Load() != 0 {
+		t.Errorf("RowsInserted = %d, DroppedRows = %d, BatchesFailed = %d, want %d, 0, 0",
+			got, w.DroppedRows.Load(), w.BatchesFailed.Load(), rows)
+	}
+	if len(seen) != rows {
+		t.Errorf("fake ClickHouse received %d distinct rows, want %d", len(seen), rows)
+	}
+	for ts, n := range seen {
+		if n != 1 {
+			t.Errorf("row %s inserted %d times", ts, n)
+			break
+		}
+	}
+	if maxBatch > batchSize || maxInFlight > workers {
+		t.Errorf("largest batch %d rows, %d concurrent inserts, want at most %d and %d", maxBatch, maxInFlight, batchSize, workers)
+	}
+}
+
+// syntheticLogs builds rows with a realistic mix of names, clients and
+// answers. Half of them are paired queries carrying a response.
+func syntheticLogs(n int) []model.DNSLog {
+	rnd := rand.New(rand.NewSource(1))
+	names := make([]string, 5000)
+	for i := range names {
+		names[i] = fmt.Sprintf("host%d.example%d.com", rnd.Intn(100), i)
+	}
+	qtypes := []uint16{1, 1, 1, 28, 28, 65, 12, 15, 16}
+	protocols := []string{"UDP", "UDP", "UDP", "TCP", "DOH", "DOT"}
+
+	now := time.Now().UTC()
+	logs := make([]model.DNSLog, n)
+	for i := range logs {
+		ts := now.Add(time.Duration(i) * time.Microsecond)
+		l := model.DNSLog{
+			Timestamp:      ts.Format(chDateTimeFormat),
+			ClientIP:       fmt.Sprintf("::ffff:10.%d.%d.%d", rnd.Intn(4), rnd.Intn(256), rnd.Intn(256)),
+			QName:          names[rnd.Intn(len(names))],
+			QType:          qtypes[rnd.Intn(len(qtypes))],
+			ResponseType:   "CQ",
+			ResponseSize:   uint32(40 + rnd.Intn(200)),
+			Flags:          FlagRD,
+			SocketProtocol: protocols[rnd.Intn(len(protocols))],
+			SocketFamily:   "INET",
+			QueryPort:      uint16(1024 + rnd.Intn(60000)),
+			ServerIdentity: "bench",
+			EDNSPresent:    true,
+			EDNSUDPSize:    1232,
+		}
+		if rnd.Intn(2) == 0 {
+			l.Paired = true
+			l.ResponseTimestamp = ts.Add(2 * time.Millisecond).Format(chDateTimeFormat)
+			l.LatencyUs = uint32(200 + rnd.Intn(20000))
+			l.Flags |= FlagQR | FlagRA
+			l.AnswerTypes = []uint16{1, 1}
+			l.AnswerTTLs = []uint32{300, 300}
+			l.AnswerData = []string{"192.0.2.1", "192.0.2.2"}
+		}
+		logs[i] = l
+	}
+	return logs
+}
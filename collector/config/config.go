@@ -0,0 +1,699 @@
+// Package config holds the collector configuration: defaults, the YAML
+// config file, environment overrides and validation. Command-line flags are
+// bound to the same struct in main and take precedence over both.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the full collector configuration. The YAML keys are the
+// documented config file format (see dnsdist-collector.example.yaml).
+type Config struct {
+	Listen     []string   `yaml:"listen"` // unix:///path, tcp://host:port, tls://host:port
+	Socket     string     `yaml:"socket"` // used when Listen is empty
+	TLS        ServerTLS  `yaml:"tls"`
+	Buffer     int        `yaml:"buffer"` // rows between listeners and sinks
+	Metrics    string     `yaml:"metrics"`
+	Pairing    Pairing    `yaml:"pairing"`
+	Answers    Answers    `yaml:"answers"`
+	Sampling   Sampling   `yaml:"sampling"`
+	Filters    Filters    `yaml:"filters"`
+	Anonymize  Anonymize  `yaml:"anonymize"`
+	Sinks      Sinks      `yaml:"sinks"`
+	ClickHouse ClickHouse `yaml:"clickhouse"`
+	File       File       `yaml:"file"`
+	Syslog     Syslog     `yaml:"syslog"`
+	Kafka      Kafka      `yaml:"kafka"`
+}
+
+// ServerTLS configures tls:// listeners.
+type ServerTLS struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+type Pairing struct {
+	Enabled    bool          `yaml:"enabled"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MaxPending int           `yaml:"max_pending"`
+}
+
+type Answers struct {
+	Enabled bool `yaml:"enabled"`
+	Max     int  `yaml:"max"`
+}
+
+// Sampling keeps a uniform fraction of rows and rate-limits floods. Rows
+// matching Keep bypass both. Kept rows carry sample_weight = 1/Rate, and
+// rows held back by a rate limit are folded into one row per client or
+// name per second whose sample_weight is the number of rows it stands for,
+// so sum(sample_weight) still estimates the real count.
+type Sampling struct {
+	Rate        float64   `yaml:"rate"` // 0 < Rate <= 1
+	Keep        Keep      `yaml:"keep"`
+	ClientLimit RateLimit `yaml:"client_limit"` // per client IP
+	QNameLimit  RateLimit `yaml:"qname_limit"`  // per query name
+	MaxBuckets  int       `yaml:"max_buckets"`  // per limit; further keys are not limited
+}
+
+// Keep lists rows that are never sampled or rate-limited.
+type Keep struct {
+	Errors    bool     `yaml:"errors"`    // rcode other than NOERROR
+	Blocklist string   `yaml:"blocklist"` // dnsdist blocklist file, re-read on reload
+	Domains   []string `yaml:"domains"`   // suffix match
+	QTypes    []string `yaml:"qtypes"`    // names or numbers, e.g. rare types like ANY
+}
+
+// RateLimit is a token bucket: Rate rows per second with bursts of Burst.
+// A zero Rate disables it.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Filters drop rows before they reach any sink.
+type Filters struct {
+	ExcludeDomains       []string `yaml:"exclude_domains"`        // suffix match, e.g. "example.internal"
+	ExcludeQTypes        []string `yaml:"exclude_qtypes"`         // names ("PTR") or numbers
+	ExcludeClients       []string `yaml:"exclude_clients"`        // CIDRs or addresses
+	ExcludeResponseTypes []string `yaml:"exclude_response_types"` // "CQ" or "CR"
+}
+
+// Anonymize rewrites client addresses before a sink writes them: truncate
+// keeps the first IPv4Prefix / IPv6Prefix bits, hmac replaces addresses
+// with keyed pseudonyms. ECS subnets are truncated in both modes.
+type Anonymize struct {
+	Mode       string            `yaml:"mode"`  // none, truncate or hmac; default for every sink
+	Sinks      map[string]string `yaml:"sinks"` // per-sink mode, e.g. {file: truncate}
+	IPv4Prefix int               `yaml:"ipv4_prefix"`
+	IPv6Prefix int               `yaml:"ipv6_prefix"`
+	KeyFile    string            `yaml:"key_file"` // hmac secret, re-read on reload
+	Rotate     time.Duration     `yaml:"rotate"`   // hmac: new derived key every period, 0 never
+}
+
+// ModeFor returns the anonymize mode of sink.
+func (a Anonymize) ModeFor(sink string) string {
+	if m, ok := a.Sinks[sink]; ok {
+		return m
+	}
+	return a.Mode
+}
+
+// Uses reports whether any of sinks is set to mode.
+func (a Anonymize) Uses(sinks []string, mode string) bool {
+	for _, s := range sinks {
+		if a.ModeFor(s) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadKey reads the hmac secret: the first line of KeyFile, at least 16
+// bytes.
+func (a Anonymize) LoadKey() ([]byte, error) {
+	data, err := os.ReadFile(a.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	key := []byte(strings.TrimSpace(line))
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%s: key must be at least 16 bytes", a.KeyFile)
+	}
+	return key, nil
+}
+
+type Sinks struct {
+	Outputs []string `yaml:"outputs"` // clickhouse, file, stdout, syslog, kafka
+	Buffer  int      `yaml:"buffer"`  // per-sink buffer
+}
+
+type ClickHouse struct {
+	HTTP          string        `yaml:"http"`
+	Native        string        `yaml:"native"`
+	User          string        `yaml:"user"`
+	Password      string        `yaml:"password"`
+	PasswordFile  string        `yaml:"password_file"` // wins over password
+	Database      string        `yaml:"database"`
+	Table         string        `yaml:"table"`
+	AutoMigrate   bool          `yaml:"auto_migrate"` // apply pending schema migrations at startup
+	Retention     Retention     `yaml:"retention"`
+	TLS           ClientTLS     `yaml:"tls"`
+	Workers       int           `yaml:"workers"`       // concurrent insert senders
+	MaxInFlight   int           `yaml:"max_in_flight"` // batches queued or sending, >= workers
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Retry         Retry         `yaml:"retry"`
+	Spool         Spool         `yaml:"spool"`
+}
+
+// Retention is the TTL of each data tier, applied by "dnsdist-collector
+// migrate" and by auto_migrate. 0 keeps a tier forever.
+type Retention struct {
+	RawDays      int  `yaml:"raw_days"`      // dns_logs
+	MinuteMonths int  `yaml:"minute_months"` // dns_stats_1m
+	HourlyMonths int  `yaml:"hourly_months"` // dns_top_1h, dns_uniques_1h
+	Cold         Cold `yaml:"cold"`
+}
+
+// Cold moves raw rows to another volume of the table's storage policy
+// before they expire.
+type Cold struct {
+	Volume    string `yaml:"volume"` // empty disables
+	AfterDays int    `yaml:"after_days"`
+}
+
+type ClientTLS struct {
+	Enabled bool   `yaml:"enabled"`
+	CA      string `yaml:"ca"`
+	Cert    string `yaml:"cert"`
+	Key     string `yaml:"key"`
+}
+
+// Retry is the insert retry policy. Without a spool a batch is tried
+// Attempts times and then dropped; spooled batches are replayed with
+// exponential backoff between MinBackoff and MaxBackoff.
+type Retry struct {
+	Attempts   int           `yaml:"attempts"`
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+type Spool struct {
+	Dir      string        `yaml:"dir"`
+	MaxBytes int64         `yaml:"max_bytes"`
+	MaxAge   time.Duration `yaml:"max_age"`
+}
+
+type File struct {
+	Path     string `yaml:"path"`
+	MaxBytes int64  `yaml:"max_bytes"`
+	MaxFiles int    `yaml:"max_files"`
+}
+
+type Syslog struct {
+	Address string `yaml:"address"`
+}
+
+type Kafka struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+}
+
+// Default returns the built-in defaults.
+func Default() Config {
+	return Config{
+		Socket:  "/run/dnsdist/dnstap.sock",
+		Buffer:  100000,
+		Pairing: Pairing{Timeout: 2 * time.Second, MaxPending: 200000},
+		Answers: Answers{Max: 32},
+		Sampling: Sampling{
+			Rate:       1,
+			Keep:       Keep{Errors: true},
+			MaxBuckets: 100000,
+		},
+		Anonymize: Anonymize{Mode: "none", IPv4Prefix: 24, IPv6Prefix: 48},
+		Sinks:     Sinks{Outputs: []string{"clickhouse"}, Buffer: 100000},
+		ClickHouse: ClickHouse{
+			HTTP:          "127.0.0.1:8123",
+			Database:      "dns",
+			Table:         "dns_logs",
+			Retention:     Retention{RawDays: 30, MinuteMonths: 12, HourlyMonths: 12},
+			Workers:       4,
+			MaxInFlight:   8,
+			BatchSize:     50000,
+			FlushInterval: 5 * time.Second,
+			Retry:         Retry{Attempts: 2, MinBackoff: 500 * time.Millisecond, MaxBackoff: 60 * time.Second},
+			Spool:         Spool{MaxBytes: 1 << 30, MaxAge: 24 * time.Hour},
+		},
+		File:  File{Path: "/var/log/dnsdist-collector/dns.ndjson", MaxBytes: 100 << 20, MaxFiles: 10},
+		Kafka: Kafka{Brokers: []string{"127.0.0.1:9092"}, Topic: "dnsdist"},
+	}
+}
+
+// LoadFile reads a YAML file over cfg. Keys missing from the file keep
+// their current value; unknown keys are an error so typos do not pass
+// silently.
+func LoadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// envVars maps environment variables to the settings they override.
+var envVars = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"COLLECTOR_LISTEN", func(c *Config, v string) error { c.Listen = splitList(v); return nil }},
+	{"COLLECTOR_SINKS", func(c *Config, v string) error { c.Sinks.Outputs = splitList(v); return nil }},
+	{"COLLECTOR_BUFFER", intVar(func(c *Config) *int { return &c.Buffer })},
+	{"COLLECTOR_METRICS", func(c *Config, v string) error { c.Metrics = v; return nil }},
+	{"COLLECTOR_ANONYMIZE", func(c *Config, v string) error { c.Anonymize.Mode = v; return nil }},
+	{"COLLECTOR_SAMPLE_RATE", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		c.Sampling.Rate = f
+		return err
+	}},
+	{"COLLECTOR_WRITERS", intVar(func(c *Config) *int { return &c.ClickHouse.Workers })},
+	{"COLLECTOR_BATCH_SIZE", intVar(func(c *Config) *int { return &c.ClickHouse.BatchSize })},
+	{"COLLECTOR_FLUSH_INTERVAL", durationVar(func(c *Config) *time.Duration { return &c.ClickHouse.FlushInterval })},
+	{"COLLECTOR_SPOOL_DIR", func(c *Config, v string) error { c.ClickHouse.Spool.Dir = v; return nil }},
+	{"CLICKHOUSE_ADDR", func(c *Config, v string) error { c.ClickHouse.HTTP = v; return nil }},
+	{"CLICKHOUSE_NATIVE", func(c *Config, v string) error { c.ClickHouse.Native = v; return nil }},
+	{"CLICKHOUSE_USER", func(c *Config, v string) error { c.ClickHouse.User = v; return nil }},
+	{"CLICKHOUSE_PASSWORD", func(c *Config, v string) error { c.ClickHouse.Password = v; return nil }},
+	{"CLICKHOUSE_PASSWORD_FILE", func(c *Config, v string) error { c.ClickHouse.PasswordFile = v; return nil }},
+	{"CLICKHOUSE_DATABASE", func(c *Config, v string) error { c.ClickHouse.Database = v; return nil }},
+	{"CLICKHOUSE_TABLE", func(c *Config, v string) error { c.ClickHouse.Table = v; return nil }},
+	{"CLICKHOUSE_RETENTION_DAYS", intVar(func(c *Config) *int { return &c.ClickHouse.Retention.RawDays })},
+	{"KAFKA_BROKERS", func(c *Config, v string) error { c.Kafka.Brokers = splitList(v); return nil }},
+}
+
+// ApplyEnv overrides cfg from the environment variables above.
+func ApplyEnv(cfg *Config) error {
+	var errs []error
+	for _, e := range envVars {
+		v, ok := os.LookupEnv(e.name)
+		if !ok {
+			continue
+		}
+		if err := e.set(cfg, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		*field(c) = n
+		return err
+	}
+}
+
+func durationVar(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		*field(c) = d
+		return err
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+var volumeName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+var validSinks = map[string]bool{"clickhouse": true, "file": true, "stdout": true, "syslog": true, "kafka": true}
+
+var validAnonymize = map[string]bool{"none": true, "truncate": true, "hmac": true}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	for _, l := range c.Listen {
+		if !strings.HasPrefix(l, "unix://") && !strings.HasPrefix(l, "tcp://") &&
+			!strings.HasPrefix(l, "tls://") && !strings.HasPrefix(l, "/") {
+			fail("listen", "%q must be unix:///path, tcp://host:port or tls://host:port", l)
+		}
+		if strings.HasPrefix(l, "tls://") && (c.TLS.Cert == "" || c.TLS.Key == "") {
+			fail("tls", "cert and key are required for %s", l)
+		}
+	}
+	if len(c.Listen) == 0 && c.Socket == "" {
+		fail("listen", "no listener configured")
+	}
+	if c.Buffer <= 0 {
+		fail("buffer", "must be > 0, got %d", c.Buffer)
+	}
+	if c.Pairing.Enabled {
+		if c.Pairing.Timeout <= 0 {
+			fail("pairing.timeout", "must be > 0")
+		}
+		if c.Pairing.MaxPending <= 0 {
+			fail("pairing.max_pending", "must be > 0")
+		}
+	}
+	if c.Answers.Enabled && c.Answers.Max <= 0 {
+		fail("answers.max", "must be > 0")
+	}
+	if _, err := c.Sampling.Compile(); err != nil {
+		errs = append(errs, prefixErrors("sampling.", err)...)
+	}
+	if _, err := c.Filters.Compile(); err != nil {
+		errs = append(errs, prefixErrors("filters.", err)...)
+	}
+
+	if len(c.Sinks.Outputs) == 0 {
+		fail("sinks.outputs", "at least one sink is required")
+	}
+	seen := map[string]bool{}
+	for _, s := range c.Sinks.Outputs {
+		if !validSinks[s] {
+			fail("sinks.outputs", "unknown sink %q (clickhouse, file, stdout, syslog, kafka)", s)
+		}
+		if seen[s] {
+			fail("sinks.outputs", "sink %q listed twice", s)
+		}
+		seen[s] = true
+	}
+	if c.Sinks.Buffer <= 0 {
+		fail("sinks.buffer", "must be > 0, got %d", c.Sinks.Buffer)
+	}
+
+	an := c.Anonymize
+	if !validAnonymize[an.Mode] {
+		fail("anonymize.mode", "%q must be none, truncate or hmac", an.Mode)
+	}
+	for sink, mode := range an.Sinks {
+		if !validSinks[sink] {
+			fail("anonymize.sinks", "unknown sink %q", sink)
+		}
+		if !validAnonymize[mode] {
+			fail("anonymize.sinks."+sink, "%q must be none, truncate or hmac", mode)
+		}
+	}
+	if an.IPv4Prefix < 0 || an.IPv4Prefix > 32 {
+		fail("anonymize.ipv4_prefix", "must be 0-32, got %d", an.IPv4Prefix)
+	}
+	if an.IPv6Prefix < 0 || an.IPv6Prefix > 128 {
+		fail("anonymize.ipv6_prefix", "must be 0-128, got %d", an.IPv6Prefix)
+	}
+	if an.Uses(c.Sinks.Outputs, "hmac") {
+		if an.KeyFile == "" {
+			fail("anonymize.key_file", "required for hmac")
+		} else if _, err := an.LoadKey(); err != nil {
+			fail("anonymize.key_file", "%v", err)
+		}
+	}
+	if an.Rotate < 0 {
+		fail("anonymize.rotate", "must be >= 0")
+	}
+
+	if seen["clickhouse"] {
+		ch := c.ClickHouse
+		if ch.HTTP == "" && ch.Native == "" {
+			fail("clickhouse", "http or native address is required")
+		}
+		if ch.Database == "" || ch.Table == "" {
+			fail("clickhouse", "database and table are required")
+		}
+		if (ch.TLS.Cert == "") != (ch.TLS.Key == "") {
+			fail("clickhouse.tls", "cert and key must be set together")
+		}
+		if ch.Workers < 1 {
+			fail("clickhouse.workers", "must be >= 1, got %d", ch.Workers)
+		}
+		if ch.MaxInFlight < ch.Workers {
+			fail("clickhouse.max_in_flight", "must be >= workers (%d), got %d", ch.Workers, ch.MaxInFlight)
+		}
+		if ch.BatchSize <= 0 {
+			fail("clickhouse.batch_size", "must be > 0, got %d", ch.BatchSize)
+		}
+		if ch.FlushInterval <= 0 {
+			fail("clickhouse.flush_interval", "must be > 0")
+		}
+		if ch.Retry.Attempts < 1 {
+			fail("clickhouse.retry.attempts", "must be >= 1")
+		}
+		if ch.Retry.MinBackoff <= 0 || ch.Retry.MaxBackoff < ch.Retry.MinBackoff {
+			fail("clickhouse.retry", "need 0 < min_backoff <= max_backoff")
+		}
+		if ch.Spool.Dir != "" && (ch.Spool.MaxBytes <= 0 || ch.Spool.MaxAge <= 0) {
+			fail("clickhouse.spool", "max_bytes and max_age must be > 0")
+		}
+	}
+	// Used by the migrate command whichever sinks are enabled
+	if r := c.ClickHouse.Retention; r.RawDays < 0 || r.MinuteMonths < 0 || r.HourlyMonths < 0 {
+		fail("clickhouse.retention", "raw_days, minute_months and hourly_months must be >= 0")
+	}
+	if cold := c.ClickHouse.Retention.Cold; cold.Volume != "" {
+		raw := c.ClickHouse.Retention.RawDays
+		if !volumeName.MatchString(cold.Volume) {
+			fail("clickhouse.retention.cold.volume", "%q is not a volume name", cold.Volume)
+		}
+		if cold.AfterDays < 1 || (raw > 0 && cold.AfterDays >= raw) {
+			fail("clickhouse.retention.cold.after_days", "must be >= 1 and below raw_days (%d), got %d", raw, cold.AfterDays)
+		}
+	}
+	if seen["file"] && c.File.Path == "" {
+		fail("file.path", "required for the file sink")
+	}
+	if seen["syslog"] && c.Syslog.Address != "" &&
+		!strings.HasPrefix(c.Syslog.Address, "udp://") && !strings.HasPrefix(c.Syslog.Address, "tcp://") {
+		fail("syslog.address", "must be udp://host:port or tcp://host:port")
+	}
+	if seen["kafka"] && (len(c.Kafka.Brokers) == 0 || c.Kafka.Topic == "") {
+		fail("kafka", "brokers and topic are required for the kafka sink")
+	}
+
+	return errors.Join(errs...)
+}
+
+// prefixErrors puts prefix in front of each error joined in err, so every
+// line of the report names its full key.
+func prefixErrors(prefix string, err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{fmt.Errorf("%s%w", prefix, err)}
+	}
+	var out []error
+	for _, e := range joined.Unwrap() {
+		out = append(out, fmt.Errorf("%s%w", prefix, e))
+	}
+	return out
+}
+
+// CompiledSampling is the parsed form of Sampling, with the blocklist
+// loaded into KeepDomains.
+type CompiledSampling struct {
+	Rate        float64
+	KeepErrors  bool
+	KeepDomains []string // lower-case, no trailing dot
+	KeepQTypes  []uint16
+	ClientLimit RateLimit
+	QNameLimit  RateLimit
+	MaxBuckets  int
+}
+
+// Compile checks the sampling settings and reads the blocklist.
+func (s Sampling) Compile() (CompiledSampling, error) {
+	out := CompiledSampling{
+		Rate:        s.Rate,
+		KeepErrors:  s.Keep.Errors,
+		ClientLimit: s.ClientLimit,
+		QNameLimit:  s.QNameLimit,
+		MaxBuckets:  s.MaxBuckets,
+	}
+	var errs []error
+
+	if s.Rate <= 0 || s.Rate > 1 {
+		errs = append(errs, fmt.Errorf("rate: must be in (0, 1], got %g", s.Rate))
+	}
+	for _, l := range []struct {
+		key string
+		RateLimit
+	}{{"client_limit", s.ClientLimit}, {"qname_limit", s.QNameLimit}} {
+		if l.Rate < 0 {
+			errs = append(errs, fmt.Errorf("%s.rate: must be >= 0, got %g", l.key, l.Rate))
+		}
+		if l.Rate > 0 && l.Burst < 1 {
+			errs = append(errs, fmt.Errorf("%s.burst: must be >= 1 when rate is set", l.key))
+		}
+	}
+	if (s.ClientLimit.Rate > 0 || s.QNameLimit.Rate > 0) && s.MaxBuckets < 1 {
+		errs = append(errs, fmt.Errorf("max_buckets: must be >= 1, got %d", s.MaxBuckets))
+	}
+
+	for _, d := range s.Keep.Domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			errs = append(errs, errors.New("keep.domains: empty domain"))
+			continue
+		}
+		out.KeepDomains = append(out.KeepDomains, d)
+	}
+	if s.Keep.Blocklist != "" {
+		domains, err := loadSuffixList(s.Keep.Blocklist)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keep.blocklist: %w", err))
+		}
+		out.KeepDomains = append(out.KeepDomains, domains...)
+	}
+	for _, t := range s.Keep.QTypes {
+		qt, ok := ParseQType(t)
+		if !ok {
+			errs = append(errs, fmt.Errorf("keep.qtypes: unknown type %q", t))
+			continue
+		}
+		out.KeepQTypes = append(out.KeepQTypes, qt)
+	}
+
+	return out, errors.Join(errs...)
+}
+
+// loadSuffixList reads a dnsdist list file: one domain per line, written
+// as "bad.example", "*.bad.example" or ".bad.example", with # comments.
+func loadSuffixList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.ToLower(strings.TrimSpace(line))
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "*"), ".")
+		if line = strings.TrimSuffix(line, "."); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out, nil
+}
+
+// RestartRequired lists the top-level sections that differ between old
+// and cur, ignoring the settings a SIGHUP reload applies in place
+// (sampling, filters, anonymize, clickhouse.batch_size and
+// clickhouse.flush_interval).
+func RestartRequired(old, cur Config) []string {
+	for _, c := range []*Config{&old, &cur} {
+		c.Sampling = Sampling{}
+		c.Filters = Filters{}
+		c.Anonymize = Anonymize{}
+		c.ClickHouse.BatchSize = 0
+		c.ClickHouse.FlushInterval = 0
+	}
+
+	var changed []string
+	ov, cv := reflect.ValueOf(old), reflect.ValueOf(cur)
+	for i := 0; i < ov.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), cv.Field(i).Interface()) {
+			key, _, _ := strings.Cut(ov.Type().Field(i).Tag.Get("yaml"), ",")
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// CompiledFilters is the parsed form of Filters.
+type CompiledFilters struct {
+	Domains       []string // lower-case, no trailing dot
+	QTypes        []uint16
+	Clients       []netip.Prefix
+	ResponseTypes []string
+}
+
+// Compile parses the filter lists.
+func (f Filters) Compile() (CompiledFilters, error) {
+	var out CompiledFilters
+	var errs []error
+
+	for _, d := range f.ExcludeDomains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			errs = append(errs, errors.New("exclude_domains: empty domain"))
+			continue
+		}
+		out.Domains = append(out.Domains, d)
+	}
+	for _, t := range f.ExcludeQTypes {
+		qt, ok := ParseQType(t)
+		if !ok {
+			errs = append(errs, fmt.Errorf("exclude_qtypes: unknown type %q", t))
+			continue
+		}
+		out.QTypes = append(out.QTypes, qt)
+	}
+	for _, c := range f.ExcludeClients {
+		p, err := parsePrefix(c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exclude_clients: %w", err))
+			continue
+		}
+		out.Clients = append(out.Clients, p)
+	}
+	for _, r := range f.ExcludeResponseTypes {
+		r = strings.ToUpper(strings.TrimSpace(r))
+		if r != "CQ" && r != "CR" {
+			errs = append(errs, fmt.Errorf("exclude_response_types: %q must be CQ or CR", r))
+			continue
+		}
+		out.ResponseTypes = append(out.ResponseTypes, r)
+	}
+
+	return out, errors.Join(errs...)
+}
+
+// parsePrefix accepts a CIDR or a single address. IPv4 prefixes are mapped
+// into ::ffff:0:0/96 to match the collector's IPv6 client addresses.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	var p netip.Prefix
+	if strings.Contains(s, "/") {
+		var err error
+		if p, err = netip.ParsePrefix(s); err != nil {
+			return p, err
+		}
+	} else {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return p, err
+		}
+		p = netip.PrefixFrom(a, a.BitLen())
+	}
+	if p.Addr().Is4() {
+		p = netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+	}
+	return p.Masked(), nil
+}
+
+var qtypeByName = map[string]uint16{
+	"A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "PTR": 12, "HINFO": 13, "MX": 15, "TXT": 16,
+	"AAAA": 28, "SRV": 33, "NAPTR": 35, "DS": 43, "RRSIG": 46, "NSEC": 47, "DNSKEY": 48,
+	"NSEC3": 50, "TLSA": 52, "SVCB": 64, "HTTPS": 65, "SPF": 99, "AXFR": 252, "IXFR": 251,
+	"ANY": 255, "CAA": 257,
+}
+
+// ParseQType accepts a type name or number.
+func ParseQType(s string) (uint16, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(n), true
+	}
+	qt, ok := qtypeByName[s]
+	return qt, ok
+}
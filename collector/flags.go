@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"dnsdist-collector/config"
+)
+
+// listFlag is a repeatable or comma-separated flag. The first Set replaces
+// the value from the config file instead of appending to it.
+type listFlag struct {
+	list *[]string
+	set  bool
+}
+
+func (l *listFlag) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l.list = append(*l.list, s)
+		}
+	}
+	return nil
+}
+
+// bindFlags defines the command-line flags on fs, writing into cfg. The
+// current cfg values become the defaults, so flags only override what the
+// user actually passes.
+func bindFlags(fs *flag.FlagSet, cfg *config.Config, configPath *string) {
+	fs.StringVar(configPath, "config", *configPath, "YAML config file (flags override it, environment overrides are applied before flags)")
+
+	fs.StringVar(&cfg.Socket, "socket", cfg.Socket, "Path to dnstap unix socket (used when no -listen is given)")
+	fs.Var(&listFlag{list: &cfg.Listen}, "listen", "dnstap listen address: unix:///path, tcp://host:port or tls://host:port (repeatable)")
+	fs.StringVar(&cfg.TLS.Cert, "tls-cert", cfg.TLS.Cert, "Server certificate for tls:// listeners")
+	fs.StringVar(&cfg.TLS.Key, "tls-key", cfg.TLS.Key, "Server private key for tls:// listeners")
+	fs.StringVar(&cfg.TLS.ClientCA, "tls-client-ca", cfg.TLS.ClientCA, "CA bundle for verifying client certificates on tls:// listeners (empty disables client auth)")
+
+	ch := &cfg.ClickHouse
+	// HTTP address for ClickHouse (e.g. 8123)
+	fs.StringVar(&ch.HTTP, "clickhouse", ch.HTTP, "ClickHouse HTTP address (env CLICKHOUSE_ADDR)")
+	fs.StringVar(&ch.Native, "clickhouse-native", ch.Native, "ClickHouse native protocol address (e.g. 127.0.0.1:9000); inserts LZ4-compressed column blocks instead of HTTP JSONEachRow")
+	fs.StringVar(&ch.User, "clickhouse-user", ch.User, "ClickHouse user (env CLICKHOUSE_USER; empty uses the server default)")
+	fs.StringVar(&ch.PasswordFile, "clickhouse-password-file", ch.PasswordFile, "File holding the ClickHouse password (env CLICKHOUSE_PASSWORD_FILE; CLICKHOUSE_PASSWORD is used when unset)")
+	fs.StringVar(&ch.Database, "clickhouse-database", ch.Database, "ClickHouse database (env CLICKHOUSE_DATABASE)")
+	fs.StringVar(&ch.Table, "clickhouse-table", ch.Table, "ClickHouse table (env CLICKHOUSE_TABLE)")
+	fs.BoolVar(&ch.AutoMigrate, "migrate", ch.AutoMigrate, "Apply pending ClickHouse schema migrations at startup (see the migrate subcommand)")
+	fs.BoolVar(&ch.TLS.Enabled, "clickhouse-tls", ch.TLS.Enabled, "Connect to ClickHouse over TLS (HTTPS or native TLS port)")
+	fs.StringVar(&ch.TLS.CA, "clickhouse-ca", ch.TLS.CA, "CA bundle for verifying ClickHouse (empty uses system roots; implies -clickhouse-tls)")
+	fs.StringVar(&ch.TLS.Cert, "clickhouse-cert", ch.TLS.Cert, "Client certificate for ClickHouse (implies -clickhouse-tls)")
+	fs.StringVar(&ch.TLS.Key, "clickhouse-key", ch.TLS.Key, "Client private key for ClickHouse")
+	fs.IntVar(&ch.Workers, "writers", ch.Workers, "Concurrent ClickHouse insert workers (env COLLECTOR_WRITERS)")
+	fs.IntVar(&ch.MaxInFlight, "max-in-flight", ch.MaxInFlight, "Batches queued or being inserted at once; when all are taken the writer applies backpressure")
+	fs.IntVar(&ch.BatchSize, "batch-size", ch.BatchSize, "Rows per ClickHouse insert (env COLLECTOR_BATCH_SIZE)")
+	fs.DurationVar(&ch.FlushInterval, "flush-interval", ch.FlushInterval, "Insert a partial batch after this long (env COLLECTOR_FLUSH_INTERVAL)")
+	fs.IntVar(&ch.Retry.Attempts, "retry-attempts", ch.Retry.Attempts, "Insert attempts per batch before dropping it (without spool)")
+	fs.StringVar(&ch.Spool.Dir, "spool-dir", ch.Spool.Dir, "Directory for batches that failed to insert (empty disables spooling)")
+	fs.Int64Var(&ch.Spool.MaxBytes, "spool-max-bytes", ch.Spool.MaxBytes, "Maximum total size of the spool directory in bytes")
+	fs.DurationVar(&ch.Spool.MaxAge, "spool-max-age", ch.Spool.MaxAge, "Discard spooled batches older than this")
+
+	fs.IntVar(&cfg.Buffer, "buffer", cfg.Buffer, "Size of the log channel buffer")
+	fs.Var(&listFlag{list: &cfg.Sinks.Outputs}, "sinks", "Comma-separated outputs: clickhouse, file, stdout, syslog, kafka")
+	fs.IntVar(&cfg.Sinks.Buffer, "sink-buffer", cfg.Sinks.Buffer, "Per-sink buffer size; a full buffer drops rows for that sink only")
+	fs.StringVar(&cfg.File.Path, "file-path", cfg.File.Path, "NDJSON output file for the file sink")
+	fs.Int64Var(&cfg.File.MaxBytes, "file-max-bytes", cfg.File.MaxBytes, "Rotate the file sink output at this size")
+	fs.IntVar(&cfg.File.MaxFiles, "file-max-files", cfg.File.MaxFiles, "Rotated files kept by the file sink (0 keeps all)")
+	fs.StringVar(&cfg.Syslog.Address, "syslog", cfg.Syslog.Address, "Syslog receiver for the CEF sink: udp://host:514 or tcp://host:514 (empty uses the local daemon)")
+	fs.Var(&listFlag{list: &cfg.Kafka.Brokers}, "kafka-brokers", "Comma-separated Kafka brokers for the kafka sink")
+	fs.StringVar(&cfg.Kafka.Topic, "kafka-topic", cfg.Kafka.Topic, "Kafka topic for the kafka sink")
+
+	fs.BoolVar(&cfg.Pairing.Enabled, "pair", cfg.Pairing.Enabled, "Pair CLIENT_QUERY with CLIENT_RESPONSE and record latency (requires response logging in dnsdist)")
+	fs.DurationVar(&cfg.Pairing.Timeout, "pair-timeout", cfg.Pairing.Timeout, "How long a query waits for its response before it is written unpaired")
+	fs.IntVar(&cfg.Pairing.MaxPending, "pair-max", cfg.Pairing.MaxPending, "Maximum number of queries waiting for a response")
+	fs.BoolVar(&cfg.Answers.Enabled, "answers", cfg.Answers.Enabled, "Extract the answer section of responses (type, TTL, rdata)")
+	fs.IntVar(&cfg.Answers.Max, "max-answers", cfg.Answers.Max, "Maximum answer records stored per response")
+	fs.Float64Var(&cfg.Sampling.Rate, "sample-rate", cfg.Sampling.Rate, "Fraction of rows to keep, 0 < rate <= 1 (env COLLECTOR_SAMPLE_RATE)")
+	fs.StringVar(&cfg.Metrics, "metrics", cfg.Metrics, "Listen address for the Prometheus /metrics endpoint (e.g. 127.0.0.1:9108, empty disables)")
+}
+
+// loadConfig builds the effective configuration from defaults, the config
+// file, the environment and finally the command-line flags. It is called
+// again on SIGHUP.
+func loadConfig(args []string) (config.Config, string, error) {
+	// First pass only finds -config; errors are reported by the second.
+	var configPath string
+	scratch := config.Default()
+	pre := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	pre.SetOutput(io.Discard)
+	bindFlags(pre, &scratch, &configPath)
+	_ = pre.Parse(args)
+
+	cfg := config.Default()
+	if configPath != "" {
+		if err := config.LoadFile(configPath, &cfg); err != nil {
+			return cfg, configPath, fmt.Errorf("config file: %w", err)
+		}
+	}
+	if err := config.ApplyEnv(&cfg); err != nil {
+		return cfg, configPath, fmt.Errorf("environment: %w", err)
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	bindFlags(fs, &cfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return cfg, configPath, err
+	}
+
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{"unix://" + cfg.Socket}
+	}
+	// A CA or client certificate only makes sense over TLS
+	if cfg.ClickHouse.TLS.CA != "" || cfg.ClickHouse.TLS.Cert != "" {
+		cfg.ClickHouse.TLS.Enabled = true
+	}
+
+	return cfg, configPath, cfg.Validate()
+}
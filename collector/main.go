@@ -1,75 +1,368 @@
 package main
 
 import (
-	"flag"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"dnsdist-collector/collector"
+	"dnsdist-collector/config"
 	"dnsdist-collector/model"
 )
 
 func main() {
-	socketPath := flag.String("socket", "/run/dnsdist/dnstap.sock", "Path to dnstap unix socket")
-	// HTTP address for ClickHouse (e.g. 8123)
-	clickhouseAddr := flag.String("clickhouse", "127.0.0.1:8123", "ClickHouse HTTP address")
-	bufferSize := flag.Int("buffer", 100000, "Size of the log channel buffer")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	cfg, configPath, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	log.Printf("Starting dnsdist-collector... Listen: %s, Sinks: %s\n",
+		strings.Join(cfg.Listen, ","), strings.Join(cfg.Sinks.Outputs, ","))
+	if configPath != "" {
+		log.Printf("Loaded config from %s\n", configPath)
+	}
 
-	log.Printf("Starting dnsdist-collector... Socket: %s, ClickHouse HTTP: %s\n", *socketPath, *clickhouseAddr)
+	logChan := make(chan model.DNSLog, cfg.Buffer)
 
-	logChan := make(chan model.DNSLog, *bufferSize)
+	// ClickHouse connection settings (clickhouse sink)
+	chCfg := cfg.ClickHouse
+	chOpts, err := clickHouseOptions(chCfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	// Initialize ClickHouse Writer
-	writer, err := collector.NewClickHouseWriter(*clickhouseAddr, logChan)
+	// Outputs: every sink gets its own buffered copy of the stream
+	fanout := collector.NewFanout(logChan)
+	var writer *collector.ClickHouseWriter
+	rowFilter, err := newRowFilter(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize ClickHouse writer: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	fanout.Filter.Store(rowFilter)
+	for _, name := range cfg.Sinks.Outputs {
+		out := fanout.Add(name, cfg.Sinks.Buffer)
+
+		switch name {
+		case "clickhouse":
+			var err error
+			checkSchema(chCfg, chOpts)
+
+			writer, err = collector.NewClickHouseWriter(chCfg.HTTP, chOpts, out.C)
+			if err != nil {
+				log.Fatalf("Failed to initialize ClickHouse writer: %v", err)
+			}
+			writer.Workers = chCfg.Workers
+			writer.MaxInFlight = chCfg.MaxInFlight
+			writer.BatchSize = chCfg.BatchSize
+			writer.FlushInterval = chCfg.FlushInterval
+			writer.RetryAttempts = chCfg.Retry.Attempts
+			writer.ReplayMinBackoff = chCfg.Retry.MinBackoff
+			writer.ReplayMaxBackoff = chCfg.Retry.MaxBackoff
 
-	// Initialize Dnstap Listener
-	listener := collector.NewDnsTapListener(*socketPath, logChan)
+			if chCfg.Native != "" {
+				writer.Native = collector.NewNativeInserter(chCfg.Native, chOpts)
+				log.Printf("Inserting over the native protocol at %s (LZ4, tls=%t)\n", chCfg.Native, chOpts.TLS != nil)
+			} else {
+				log.Printf("Inserting over ClickHouse HTTP at %s (tls=%t)\n", chCfg.HTTP, chOpts.TLS != nil)
+			}
+			log.Printf("Writer: %d workers, %d batches in flight, batch size %d\n", chCfg.Workers, chCfg.MaxInFlight, chCfg.BatchSize)
 
-	// Start Writer Worker
-	// We wait on writer.Done channel
-	go writer.Worker()
+			// Optional write-ahead spool for failed batches
+			if sc := chCfg.Spool; sc.Dir != "" {
+				spool, err := collector.NewSpool(sc.Dir, sc.MaxBytes, sc.MaxAge)
+				if err != nil {
+					log.Fatalf("Failed to initialize spool: %v", err)
+				}
+				writer.Spool = spool
+				log.Printf("Spooling failed batches to %s (max %d bytes, max age %s)\n", sc.Dir, sc.MaxBytes, sc.MaxAge)
+			}
+			out.Sink = writer
 
-	// Start Listener
-	if err := listener.Start(); err != nil {
-		log.Fatalf("Failed to start listener: %v", err)
+		case "file":
+			fc := cfg.File
+			sink, err := collector.NewFileSink(fc.Path, fc.MaxBytes, fc.MaxFiles, out.C)
+			if err != nil {
+				log.Fatalf("Failed to initialize file sink: %v", err)
+			}
+			out.Sink = sink
+			log.Printf("Writing NDJSON to %s (rotate at %d bytes, keep %d)\n", fc.Path, fc.MaxBytes, fc.MaxFiles)
+
+		case "stdout":
+			out.Sink = collector.NewStdoutSink(out.C)
+
+		case "syslog":
+			sink, err := collector.NewSyslogSink(cfg.Syslog.Address, out.C)
+			if err != nil {
+				log.Fatalf("Failed to initialize syslog sink: %v", err)
+			}
+			out.Sink = sink
+			log.Printf("Forwarding CEF events to syslog %s\n", cfg.Syslog.Address)
+
+		case "kafka":
+			brokers := strings.Join(cfg.Kafka.Brokers, ",")
+			out.Sink = collector.NewKafkaSink(brokers, cfg.Kafka.Topic, out.C)
+			log.Printf("Publishing to Kafka topic %s on %s\n", cfg.Kafka.Topic, brokers)
+
+		default:
+			log.Fatalf("Unknown sink %q", name)
+		}
+	}
+	if err := setAnonymizers(cfg, fanout); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Optional query/response pairing (shared by all listeners)
+	var pairer *collector.Pairer
+	if pc := cfg.Pairing; pc.Enabled {
+		pairer = collector.NewPairer(pc.Timeout, pc.MaxPending, logChan)
+		log.Printf("Pairing queries with responses (timeout %s, max pending %d)\n", pc.Timeout, pc.MaxPending)
+	}
+
+	// Initialize Dnstap Listeners (all feed the same channel)
+	var tlsConfig *tls.Config
+	var listeners []*collector.DnsTapListener
+	for _, addr := range cfg.Listen {
+		listener, err := collector.NewDnsTapListener(addr, logChan)
+		if err != nil {
+			log.Fatalf("Invalid listen address: %v", err)
+		}
+		if listener.Network == "tls" {
+			if tlsConfig == nil {
+				tlsConfig, err = collector.LoadServerTLSConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA)
+				if err != nil {
+					log.Fatalf("Failed to load TLS config: %v", err)
+				}
+			}
+			listener.TLSConfig = tlsConfig
+		}
+		listener.Pairer = pairer
+		listener.ParseAnswers = cfg.Answers.Enabled
+		listener.MaxAnswers = cfg.Answers.Max
+		listeners = append(listeners, listener)
+	}
+
+	// Start sink workers
+	fanout.Start()
+
+	if pairer != nil {
+		pairer.Start()
+	}
+
+	// Start Listeners
+	for _, listener := range listeners {
+		if err := listener.Start(); err != nil {
+			log.Fatalf("Failed to start listener: %v", err)
+		}
+		log.Printf("Listening for dnstap streams on %s\n", listener)
+	}
+
+	// Optional Prometheus endpoint
+	var metricsServer *http.Server
+	if cfg.Metrics != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", newMetricsRegistry(listeners, pairer, fanout, writer, logChan))
+		metricsServer = &http.Server{Addr: cfg.Metrics, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+		log.Printf("Serving metrics on http://%s/metrics\n", cfg.Metrics)
 	}
-	log.Println("Listening for dnstap streams...")
 
 	// Metrics ticker
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
 		for range ticker.C {
-			dropped := listener.Dropped.Load()
-			log.Printf("Metrics: Dropped=%d BufferLen=%d\n", dropped, len(logChan))
+			dropped := sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.Dropped.Load() })()
+			var sinkDropped uint64
+			for _, out := range fanout.Outputs {
+				sinkDropped += out.Dropped.Load()
+			}
+			if writer == nil {
+				log.Printf("Metrics: Dropped=%d BufferLen=%d SinkDropped=%d\n", dropped, len(logChan), sinkDropped)
+			} else if writer.Spool != nil {
+				log.Printf("Metrics: Dropped=%d BufferLen=%d SinkDropped=%d WriterDropped=%d SpoolBatches=%d SpoolBytes=%d SpoolDropped=%d SpoolDeadLetter=%d\n",
+					dropped, len(logChan), sinkDropped, writer.DroppedRows.Load(),
+					writer.Spool.Len(), writer.Spool.Bytes(), writer.Spool.DroppedRows.Load(), writer.Spool.DeadLetterRows.Load())
+			} else {
+				log.Printf("Metrics: Dropped=%d BufferLen=%d SinkDropped=%d WriterDropped=%d\n", dropped, len(logChan), sinkDropped, writer.DroppedRows.Load())
+			}
 		}
 	}()
 
-	// Wait for SIGTERM or SIGINT
+	// Wait for SIGTERM or SIGINT; SIGHUP reloads the config
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		cfg = reload(cfg, fanout, writer)
+		sig = <-sigChan
+	}
 	log.Printf("Received signal %v, shutting down...\n", sig)
 
 	// Graceful Shutdown Sequence
 
-	// 1) Stop Listener (closes socket, waits for all active handlers to finish)
-	log.Println("Stopping listener...")
-	listener.Stop()
-	log.Println("Listener stopped.")
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
+
+	// 1) Stop Listeners (close sockets, wait for all active handlers to finish)
+	log.Println("Stopping listeners...")
+	for _, listener := range listeners {
+		listener.Stop()
+	}
+	log.Println("Listeners stopped.")
+
+	// Flush queries still waiting for a response
+	if pairer != nil {
+		pairer.Stop()
+	}
 
 	// 2) Close channel (no new logs will be sent)
 	close(logChan)
 	log.Println("Channel closed, draining buffer...")
 
-	// 3) Wait for Writer to drain channel and finish
-	<-writer.Done
-	log.Println("Writer finished. Shutdown complete.")
+	// 3) Wait for every sink to drain its buffer and finish
+	fanout.Wait()
+	if writer != nil && writer.Native != nil {
+		_ = writer.Native.Close()
+	}
+	log.Println("Sinks finished. Shutdown complete.")
+}
+
+// clickHouseOptions resolves the ClickHouse credentials and TLS settings.
+func clickHouseOptions(ch config.ClickHouse) (collector.ClickHouseOptions, error) {
+	opts := collector.ClickHouseOptions{
+		Username: ch.User,
+		Password: ch.Password,
+		Database: ch.Database,
+		Table:    ch.Table,
+	}
+	if ch.PasswordFile != "" {
+		password, err := readSecretFile(ch.PasswordFile)
+		if err != nil {
+			return opts, fmt.Errorf("failed to read ClickHouse password: %w", err)
+		}
+		opts.Password = password
+	}
+	if ch.TLS.Enabled {
+		tlsCfg, err := collector.LoadClientTLSConfig(ch.TLS.CA, ch.TLS.Cert, ch.TLS.Key)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load ClickHouse TLS config: %w", err)
+		}
+		opts.TLS = tlsCfg
+	}
+	return opts, nil
+}
+
+// newRowFilter builds the exclusion and sampling stage from cfg. The
+// sampling blocklist is read here, so it is picked up again on reload.
+func newRowFilter(cfg config.Config) (*collector.RowFilter, error) {
+	f, err := cfg.Filters.Compile()
+	if err != nil {
+		return nil, err
+	}
+	s, err := cfg.Sampling.Compile()
+	if err != nil {
+		return nil, err
+	}
+	policy := collector.SamplingPolicy{
+		Rate:        s.Rate,
+		KeepErrors:  s.KeepErrors,
+		KeepDomains: s.KeepDomains,
+		KeepQTypes:  s.KeepQTypes,
+		ClientLimit: collector.RateLimit(s.ClientLimit),
+		QNameLimit:  collector.RateLimit(s.QNameLimit),
+		MaxBuckets:  s.MaxBuckets,
+	}
+	if len(s.KeepDomains) > 0 {
+		log.Printf("Sampling keeps %d domains unconditionally\n", len(s.KeepDomains))
+	}
+	return collector.NewRowFilter(f.Domains, f.QTypes, f.Clients, f.ResponseTypes, policy), nil
+}
+
+// setAnonymizers installs the anonymize mode of every output. The hmac key
+// is read here, so a replaced key file is picked up on reload.
+func setAnonymizers(cfg config.Config, fanout *collector.Fanout) error {
+	an := cfg.Anonymize
+	var key []byte
+	if an.Uses(cfg.Sinks.Outputs, collector.AnonymizeHMAC) {
+		var err error
+		if key, err = an.LoadKey(); err != nil {
+			return fmt.Errorf("anonymize.key_file: %w", err)
+		}
+	}
+
+	for _, out := range fanout.Outputs {
+		mode := an.ModeFor(out.Name)
+		if mode == collector.AnonymizeNone {
+			out.Anonymizer.Store(nil)
+			continue
+		}
+		out.Anonymizer.Store(collector.NewAnonymizer(mode, an.IPv4Prefix, an.IPv6Prefix, key, an.Rotate))
+		log.Printf("Sink %s: client addresses anonymized (%s)\n", out.Name, mode)
+	}
+	return nil
+}
+
+// reload re-reads the configuration and applies the settings that can
+// change while running: filters, sampling, anonymization, batch size and
+// flush interval.
+// Everything else keeps its current value until the next restart.
+func reload(cur config.Config, fanout *collector.Fanout, writer *collector.ClickHouseWriter) config.Config {
+	next, _, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Printf("Config reload failed, keeping the running config:\n%v", err)
+		return cur
+	}
+
+	rowFilter, err := newRowFilter(next)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the running config:\n%v", err)
+		return cur
+	}
+	if err := setAnonymizers(next, fanout); err != nil {
+		log.Printf("Config reload failed, keeping the running config:\n%v", err)
+		return cur
+	}
+	fanout.Filter.Store(rowFilter)
+	if writer != nil {
+		writer.SetBatching(next.ClickHouse.BatchSize, next.ClickHouse.FlushInterval)
+	}
+	log.Printf("Config reloaded: sample rate %g, batch size %d, flush interval %s\n",
+		next.Sampling.Rate, next.ClickHouse.BatchSize, next.ClickHouse.FlushInterval)
+	if changed := config.RestartRequired(cur, next); len(changed) > 0 {
+		log.Printf("Config reload: changes to %s need a restart to take effect\n", strings.Join(changed, ", "))
+	}
+
+	// Keep the running values for everything that was not applied.
+	cur.Sampling = next.Sampling
+	cur.Filters = next.Filters
+	cur.Anonymize = next.Anonymize
+	cur.ClickHouse.BatchSize = next.ClickHouse.BatchSize
+	cur.ClickHouse.FlushInterval = next.ClickHouse.FlushInterval
+	return cur
+}
+
+// readSecretFile returns the first line of a secret file (e.g. a systemd
+// credential or a mounted Kubernetes secret).
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret, _, _ := strings.Cut(string(b), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"dnsdist-collector/collector"
+	"dnsdist-collector/metrics"
+	"dnsdist-collector/model"
+)
+
+// sumListeners returns a callback adding up one counter across listeners.
+func sumListeners(listeners []*collector.DnsTapListener, get func(*collector.DnsTapListener) uint64) func() uint64 {
+	return func() uint64 {
+		var total uint64
+		for _, l := range listeners {
+			total += get(l)
+		}
+		return total
+	}
+}
+
+// newMetricsRegistry wires the listener, sink, writer and spool counters
+// into a Prometheus registry. Listener counters are summed over all listeners.
+func newMetricsRegistry(listeners []*collector.DnsTapListener, pairer *collector.Pairer, fanout *collector.Fanout, writer *collector.ClickHouseWriter, logChan chan model.DNSLog) *metrics.Registry {
+	reg := metrics.NewRegistry()
+
+	// Listener
+	reg.CounterFunc("dnsdist_collector_frames_decoded_total",
+		"Dnstap frames read from framestream connections.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.FramesDecoded.Load() }))
+	reg.CounterFunc("dnsdist_collector_unmarshal_failures_total",
+		"Dnstap frames that failed protobuf decoding.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.UnmarshalFailures.Load() }))
+	reg.CounterFunc("dnsdist_collector_parse_failures_total",
+		"DNS messages ParseHeaderAndQuestion could not parse.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.ParseFailures.Load() }))
+	reg.CounterFunc("dnsdist_collector_answer_parse_failures_total",
+		"Responses whose answer section could not be fully parsed.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.AnswerFailures.Load() }))
+	reg.CounterFunc("dnsdist_collector_edns_parse_failures_total",
+		"Queries whose EDNS0 OPT record could not be parsed.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.EDNSFailures.Load() }))
+	reg.CounterFunc("dnsdist_collector_channel_dropped_total",
+		"Rows dropped because the log channel was full.",
+		sumListeners(listeners, func(l *collector.DnsTapListener) uint64 { return l.Dropped.Load() }))
+	reg.GaugeFunc("dnsdist_collector_active_connections",
+		"Open dnstap connections.", func() float64 {
+			var total int64
+			for _, l := range listeners {
+				total += l.ActiveConns.Load()
+			}
+			return float64(total)
+		})
+
+	// Pairing
+	if pairer != nil {
+		reg.CounterFunc("dnsdist_collector_pair_matched_total",
+			"Queries paired with their response.", pairer.Paired.Load)
+		reg.CounterFunc("dnsdist_collector_pair_unmatched_total",
+			"Responses without a pending query.", pairer.Unmatched.Load)
+		reg.CounterFunc("dnsdist_collector_pair_expired_total",
+			"Queries written unpaired after the pairing timeout.", pairer.Expired.Load)
+		reg.CounterFunc("dnsdist_collector_pair_overflow_total",
+			"Queries written unpaired because the pending table was full.", pairer.Overflow.Load)
+		reg.CounterFunc("dnsdist_collector_pair_dropped_total",
+			"Evicted queries dropped because the log channel was full.", pairer.Dropped.Load)
+		reg.GaugeFunc("dnsdist_collector_pair_pending",
+			"Queries waiting for a response.", func() float64 { return float64(pairer.Pending()) })
+	}
+
+	// Channel
+	reg.GaugeFunc("dnsdist_collector_channel_depth",
+		"Rows waiting in the log channel.", func() float64 { return float64(len(logChan)) })
+	reg.GaugeFunc("dnsdist_collector_channel_capacity",
+		"Capacity of the log channel.", func() float64 { return float64(cap(logChan)) })
+
+	// Filters and sampling
+	reg.CounterFunc("dnsdist_collector_filtered_total",
+		"Rows dropped by exclusion filters.", fanout.Filtered.Load)
+	reg.CounterFunc("dnsdist_collector_sampled_out_total",
+		"Rows dropped by sampling.", fanout.SampledOut.Load)
+	reg.CounterFunc("dnsdist_collector_rate_limited_total",
+		"Rows folded into collapsed rows by per-client or per-name rate limits.", fanout.RateLimited.Load)
+	reg.CounterFunc("dnsdist_collector_collapsed_rows_total",
+		"Collapsed rows sent in place of rate-limited rows.", fanout.Collapsed.Load)
+
+	// Sinks
+	reg.CounterVecFunc("dnsdist_collector_sink_dropped_total",
+		"Rows dropped because a sink's buffer was full.", "sink", func() map[string]uint64 {
+			values := make(map[string]uint64, len(fanout.Outputs))
+			for _, out := range fanout.Outputs {
+				values[out.Name] = out.Dropped.Load()
+			}
+			return values
+		})
+	reg.GaugeVecFunc("dnsdist_collector_sink_buffer_depth",
+		"Rows waiting in a sink's buffer.", "sink", func() map[string]float64 {
+			values := make(map[string]float64, len(fanout.Outputs))
+			for _, out := range fanout.Outputs {
+				values[out.Name] = float64(len(out.C))
+			}
+			return values
+		})
+
+	// Writer (only with the clickhouse sink)
+	if writer == nil {
+		return reg
+	}
+	reg.CounterFunc("dnsdist_collector_batches_sent_total",
+		"Batches inserted into ClickHouse.", writer.BatchesSent.Load)
+	reg.CounterFunc("dnsdist_collector_batches_failed_total",
+		"Failed ClickHouse insert attempts, including retries.", writer.BatchesFailed.Load)
+	reg.CounterFunc("dnsdist_collector_batches_dropped_total",
+		"Batches lost by the writer without being spooled.", writer.BatchesDropped.Load)
+	reg.CounterFunc("dnsdist_collector_writer_dropped_rows_total",
+		"Rows lost by the writer without being spooled.", writer.DroppedRows.Load)
+	reg.CounterFunc("dnsdist_collector_rows_inserted_total",
+		"Rows inserted into ClickHouse.", writer.RowsInserted.Load)
+	reg.CounterFunc("dnsdist_collector_writer_early_flushes_total",
+		"Partial batches sent early because the writer channel was filling.", writer.EarlyFlushes.Load)
+	reg.CounterFunc("dnsdist_collector_writer_stalls_total",
+		"Times the writer waited for a free in-flight batch slot (backpressure).", writer.Stalls.Load)
+	reg.GaugeFunc("dnsdist_collector_writer_inflight_batches",
+		"Batches queued or being inserted.", func() float64 { return float64(writer.InFlight()) })
+	reg.GaugeFunc("dnsdist_collector_writer_workers",
+		"Concurrent insert workers.", func() float64 { return float64(writer.Workers) })
+	reg.Histogram(writer.InsertLatency)
+
+	// Spool
+	if spool := writer.Spool; spool != nil {
+		reg.GaugeFunc("dnsdist_collector_spool_batches",
+			"Batches waiting in the spool directory.", func() float64 { return float64(spool.Len()) })
+		reg.GaugeFunc("dnsdist_collector_spool_bytes",
+			"Size of the spool directory in bytes.", func() float64 { return float64(spool.Bytes()) })
+		reg.CounterFunc("dnsdist_collector_spool_dropped_batches_total",
+			"Spooled batches discarded by the size or age limit.", spool.DroppedBatches.Load)
+		reg.CounterFunc("dnsdist_collector_spool_dropped_rows_total",
+			"Spooled rows discarded by the size or age limit.", spool.DroppedRows.Load)
+		reg.CounterFunc("dnsdist_collector_spool_dead_letter_batches_total",
+			"Spooled batches moved to the dead-letter directory after ClickHouse rejected them.", spool.DeadLetterBatches.Load)
+		reg.CounterFunc("dnsdist_collector_spool_dead_letter_rows_total",
+			"Spooled rows moved to the dead-letter directory after ClickHouse rejected them.", spool.DeadLetterRows.Load)
+	}
+
+	return reg
+}
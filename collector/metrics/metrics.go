@@ -0,0 +1,156 @@
+// Package metrics exposes collector counters in the Prometheus text
+// exposition format without pulling in the full client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds the metrics served on /metrics. Values are read through
+// callbacks so that components keep owning their own atomic counters.
+type Registry struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+type entry struct {
+	name  string
+	help  string
+	kind  string
+	write func(w io.Writer, name string)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(e entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+	sort.SliceStable(r.entries, func(i, j int) bool { return r.entries[i].name < r.entries[j].name })
+}
+
+// CounterFunc registers a monotonically increasing value.
+func (r *Registry) CounterFunc(name, help string, fn func() uint64) {
+	r.add(entry{name: name, help: help, kind: "counter", write: func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, fn())
+	}})
+}
+
+// CounterVecFunc registers a counter with one label. fn returns the current
+// value per label value.
+func (r *Registry) CounterVecFunc(name, help, label string, fn func() map[string]uint64) {
+	r.add(entry{name: name, help: help, kind: "counter", write: func(w io.Writer, name string) {
+		values := fn()
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, strconv.Quote(k), values[k])
+		}
+	}})
+}
+
+// GaugeVecFunc registers a gauge with one label.
+func (r *Registry) GaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.add(entry{name: name, help: help, kind: "gauge", write: func(w io.Writer, name string) {
+		values := fn()
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s{%s=%s} %s\n", name, label, strconv.Quote(k), formatFloat(values[k]))
+		}
+	}})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GaugeFunc registers a value that can go up and down.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(entry{name: name, help: help, kind: "gauge", write: func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(fn()))
+	}})
+}
+
+// Histogram registers h under its own name.
+func (r *Registry) Histogram(h *Histogram) {
+	r.add(entry{name: h.name, help: h.help, kind: "histogram", write: h.write})
+}
+
+// Write renders all registered metrics.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	entries := append([]entry(nil), r.entries...)
+	r.mu.Unlock()
+
+	for _, e := range entries {
+		fmt.Fprintf(w, "# HELP %s %s\n", e.name, e.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", e.name, e.kind)
+		e.write(w, e.name)
+	}
+}
+
+// ServeHTTP implements http.Handler for the /metrics endpoint.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Histogram is a fixed-bucket, lock-free histogram.
+type Histogram struct {
+	name    string
+	help    string
+	bounds  []float64
+	buckets []atomic.Uint64 // non-cumulative; last bucket is +Inf
+	sumBits atomic.Uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds (ascending).
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		bounds:  bounds,
+		buckets: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.buckets[i].Add(1)
+	for {
+		old := h.sumBits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if h.sumBits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.buckets[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(b), cumulative)
+	}
+	cumulative += h.buckets[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(math.Float64frombits(h.sumBits.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
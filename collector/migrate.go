@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"dnsdist-collector/collector"
+	"dnsdist-collector/config"
+	"dnsdist-collector/migrate"
+)
+
+// runMigrate implements "dnsdist-collector migrate [up|status] [flags]".
+// It takes the same flags and config file as the collector and talks to
+// ClickHouse over HTTP.
+func runMigrate(args []string) int {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	if action != "up" && action != "status" {
+		fmt.Fprintf(os.Stderr, "usage: %s migrate [up|status] [flags]\n", os.Args[0])
+		return 2
+	}
+
+	cfg, _, err := loadConfig(args)
+	if err != nil {
+		log.Printf("Invalid configuration:\n%v", err)
+		return 1
+	}
+	opts, err := clickHouseOptions(cfg.ClickHouse)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	runner, err := newMigrationRunner(cfg.ClickHouse, opts)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	if action == "up" {
+		n, err := runner.Up(log.Printf)
+		if err != nil {
+			log.Printf("Migration failed: %v", err)
+			return 1
+		}
+		log.Printf("Applied %d migrations\n", n)
+
+		n, err = runner.ApplyRetention(retentionPolicy(cfg.ClickHouse), log.Printf)
+		if err != nil {
+			log.Printf("Applying retention failed: %v", err)
+			return 1
+		}
+		log.Printf("Changed the TTL of %d tables\n", n)
+	}
+
+	states, err := runner.Status()
+	if err != nil {
+		log.Printf("Reading migration status failed: %v", err)
+		return 1
+	}
+	for _, s := range states {
+		status := "pending"
+		if s.Applied {
+			status = "applied " + s.AppliedAt
+		}
+		if s.Modified {
+			status += " (file changed since)"
+		}
+		fmt.Printf("%04d_%-28s %s\n", s.Version, s.Name, status)
+	}
+
+	ttls, err := runner.RetentionStatus(retentionPolicy(cfg.ClickHouse))
+	if err != nil {
+		log.Printf("Reading table TTLs failed: %v", err)
+		return 1
+	}
+	fmt.Println()
+	for _, t := range ttls {
+		switch {
+		case t.Missing:
+			fmt.Printf("%-16s missing\n", t.Table)
+		case t.Pending():
+			fmt.Printf("%-16s TTL %s (pending: %s)\n", t.Table, orNone(t.Current), orNone(t.Want))
+		default:
+			fmt.Printf("%-16s TTL %s\n", t.Table, orNone(t.Current))
+		}
+	}
+
+	missing, err := runner.MissingColumns()
+	if err != nil {
+		log.Printf("Reading table columns failed: %v", err)
+		return 1
+	}
+	if len(missing) > 0 {
+		fmt.Printf("\n%s.%s is missing columns written by this collector: %s\n",
+			cfg.ClickHouse.Database, cfg.ClickHouse.Table, strings.Join(missing, ", "))
+		return 1
+	}
+	return 0
+}
+
+// checkSchema runs at startup when the clickhouse sink is enabled. With
+// auto_migrate it applies pending migrations; otherwise it only warns.
+// ClickHouse being down is not fatal: the writer spools or retries anyway.
+func checkSchema(ch config.ClickHouse, opts collector.ClickHouseOptions) {
+	runner, err := newMigrationRunner(ch, opts)
+	if err != nil {
+		log.Printf("Schema check skipped: %v", err)
+		return
+	}
+
+	// Don't hold up startup for long if only checking
+	if !ch.AutoMigrate {
+		runner.Client.Timeout = 10 * time.Second
+	}
+
+	if ch.AutoMigrate {
+		n, err := runner.Up(log.Printf)
+		if err != nil {
+			log.Printf("Schema migration failed (continuing): %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("Applied %d schema migrations\n", n)
+		}
+		if _, err := runner.ApplyRetention(retentionPolicy(ch), log.Printf); err != nil {
+			log.Printf("Applying retention failed (continuing): %v", err)
+		}
+	} else {
+		states, err := runner.Status()
+		if err != nil {
+			log.Printf("Schema check failed (continuing): %v", err)
+			return
+		}
+		pending := 0
+		for _, s := range states {
+			if !s.Applied {
+				pending++
+			}
+		}
+		if pending > 0 {
+			log.Printf("WARNING: %d schema migrations pending; run '%s migrate' or set clickhouse.auto_migrate\n", pending, os.Args[0])
+		}
+		if ttls, err := runner.RetentionStatus(retentionPolicy(ch)); err == nil {
+			for _, t := range ttls {
+				if t.Pending() {
+					log.Printf("WARNING: TTL of %s.%s differs from clickhouse.retention; run '%s migrate' to apply it\n", ch.Database, t.Table, os.Args[0])
+				}
+			}
+		}
+	}
+
+	if missing, err := runner.MissingColumns(); err == nil && len(missing) > 0 {
+		log.Printf("WARNING: %s.%s is missing columns %s; inserts will fail until it is migrated\n",
+			ch.Database, ch.Table, strings.Join(missing, ", "))
+	}
+}
+
+func newMigrationRunner(ch config.ClickHouse, opts collector.ClickHouseOptions) (*migrate.Runner, error) {
+	if ch.HTTP == "" {
+		return nil, fmt.Errorf("migrations need the ClickHouse HTTP address (clickhouse.http)")
+	}
+	return migrate.NewRunner(ch.HTTP, opts.Username, opts.Password, ch.Database, ch.Table, opts.TLS)
+}
+
+func retentionPolicy(ch config.ClickHouse) migrate.Retention {
+	r := ch.Retention
+	return migrate.Retention{
+		RawDays:       r.RawDays,
+		MinuteMonths:  r.MinuteMonths,
+		HourlyMonths:  r.HourlyMonths,
+		ColdVolume:    r.Cold.Volume,
+		ColdAfterDays: r.Cold.AfterDays,
+	}
+}
+
+func orNone(ttl string) string {
+	if ttl == "" {
+		return "none"
+	}
+	return ttl
+}
@@ -0,0 +1,422 @@
+// Package migrate applies the versioned ClickHouse DDL in migrations/ and
+// records every applied version in the schema_migrations table.
+//
+// Migration files are named NNNN_description.sql and run in version order.
+// Statements are separated by semicolons and must be safe to run twice
+// (IF NOT EXISTS, IF EXISTS), because a migration that fails halfway is
+// retried from the start. {database} and {table} are replaced with the
+// configured names. A first line of the form
+//
+//	-- only-if: SELECT ...
+//
+// makes the migration conditional: it runs only if the query returns a
+// non-zero number, and is recorded as applied either way. The same line
+// anywhere else guards only the statement that follows it, which lets a
+// multi-step migration pick up where an interrupted run stopped.
+//
+// Never edit a migration that has shipped; add a new one. New model.DNSLog
+// fields need a migration adding the column, and type changes use
+// ALTER TABLE ... MODIFY COLUMN.
+//
+// Table TTLs are not migrations: they come from the configured Retention
+// and are recorded in schema_retention (see retention.go).
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnsdist-collector/model"
+)
+
+//go:embed migrations/*.sql
+var files embed.FS
+
+// Migration is one embedded migration file.
+type Migration struct {
+	Version  int
+	Name     string
+	SQL      string // with {database} / {table} placeholders
+	Checksum string // of the file, to detect edits after it was applied
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// Load returns the embedded migrations in version order.
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_description.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if prev, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration %s: version %d already used by %s", e.Name(), version, prev)
+		}
+		seen[version] = e.Name()
+
+		data, err := files.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		out = append(out, Migration{
+			Version:  version,
+			Name:     m[2],
+			SQL:      string(data),
+			Checksum: hex.EncodeToString(sum[:8]),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// statement is one statement of a migration and the condition guarding it.
+type statement struct {
+	SQL    string
+	OnlyIf string // empty: always run
+}
+
+// statements splits the migration into statements, dropping comments.
+func (m Migration) statements(expand func(string) string) []statement {
+	var out []statement
+	var sql strings.Builder
+	onlyIf := ""
+	add := func() {
+		if stmt := strings.TrimSpace(sql.String()); stmt != "" {
+			out = append(out, statement{SQL: expand(stmt), OnlyIf: expand(onlyIf)})
+			onlyIf = ""
+		}
+		sql.Reset()
+	}
+
+	for i, line := range strings.Split(m.SQL, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if cond, ok := strings.CutPrefix(trimmed, "-- only-if:"); ok && i > 0 {
+			onlyIf = strings.TrimSpace(cond)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		for {
+			before, after, found := strings.Cut(line, ";")
+			sql.WriteString(before)
+			if !found {
+				sql.WriteByte('\n')
+				break
+			}
+			add()
+			line = after
+		}
+	}
+	add()
+	return out
+}
+
+// onlyIf returns the condition query of a conditional migration.
+func (m Migration) onlyIf() string {
+	first, _, _ := strings.Cut(m.SQL, "\n")
+	cond, ok := strings.CutPrefix(strings.TrimSpace(first), "-- only-if:")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(cond)
+}
+
+// Runner applies migrations over the ClickHouse HTTP interface.
+type Runner struct {
+	URL      string // http(s)://host:port/
+	Username string
+	Password string
+	Database string
+	Table    string
+	Client   *http.Client
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewRunner creates a Runner for the given HTTP address. Database and
+// table are substituted into the migrations unquoted, so they must be
+// plain identifiers.
+func NewRunner(httpAddr, username, password, database, table string, tlsConfig *tls.Config) (*Runner, error) {
+	for _, name := range []string{database, table} {
+		if !identifier.MatchString(name) {
+			return nil, fmt.Errorf("migrations need a plain identifier for database and table, got %q", name)
+		}
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	return &Runner{
+		URL:      fmt.Sprintf("%s://%s/", scheme, httpAddr),
+		Username: username,
+		Password: password,
+		Database: database,
+		Table:    table,
+		Client: &http.Client{
+			// DDL such as the timestamp table swap can take a while
+			Timeout: 30 * time.Minute,
+			Transport: &http.Transport{
+				DialContext:     (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+// State is a migration and whether it has been applied.
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+	Modified  bool // file changed since it was applied
+}
+
+// Status reports every known migration and whether it has been applied.
+func (r *Runner) Status() ([]State, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.query(r.expand("SELECT version, checksum, toString(applied_at) FROM {database}.schema_migrations FINAL ORDER BY version"))
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int][]string, len(rows))
+	for _, row := range rows {
+		if len(row) != 3 {
+			continue
+		}
+		v, err := strconv.Atoi(row[0])
+		if err != nil {
+			continue
+		}
+		applied[v] = row[1:]
+	}
+
+	states := make([]State, 0, len(migrations))
+	for _, m := range migrations {
+		s := State{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.Modified = a[0] != m.Checksum
+			s.AppliedAt = a[1]
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Up applies every pending migration in order and returns how many ran.
+// It stops at the first failure; fixing the cause and running Up again
+// retries that migration.
+func (r *Runner) Up(logf func(format string, args ...any)) (int, error) {
+	states, err := r.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range states {
+		if s.Applied {
+			if s.Modified {
+				logf("Migration %04d_%s changed after it was applied; add a new migration instead", s.Version, s.Name)
+			}
+			continue
+		}
+		if err := r.apply(s.Migration, logf); err != nil {
+			return n, fmt.Errorf("migration %04d_%s: %w", s.Version, s.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (r *Runner) apply(m Migration, logf func(format string, args ...any)) error {
+	run := true
+	if cond := m.onlyIf(); cond != "" {
+		var err error
+		if run, err = r.holds(r.expand(cond)); err != nil {
+			return err
+		}
+	}
+
+	if run {
+		logf("Applying migration %04d_%s", m.Version, m.Name)
+		for _, stmt := range m.statements(r.expand) {
+			if stmt.OnlyIf != "" {
+				ok, err := r.holds(stmt.OnlyIf)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					logf("  skipped (not needed): %s", firstLine(stmt.SQL))
+					continue
+				}
+			}
+			if err := r.exec(stmt.SQL); err != nil {
+				return err
+			}
+		}
+	} else {
+		logf("Skipping migration %04d_%s (not needed on this server)", m.Version, m.Name)
+	}
+
+	return r.exec(fmt.Sprintf("INSERT INTO %s.schema_migrations (version, name, checksum) VALUES (%d, '%s', '%s')",
+		r.Database, m.Version, m.Name, m.Checksum))
+}
+
+// holds runs an only-if query and reports whether it returned a non-zero
+// number.
+func (r *Runner) holds(cond string) (bool, error) {
+	rows, err := r.query(cond)
+	if err != nil {
+		return false, fmt.Errorf("only-if: %w", err)
+	}
+	return len(rows) > 0 && len(rows[0]) > 0 && rows[0][0] != "0", nil
+}
+
+// MissingColumns lists model.DNSLog fields that have no column in the
+// table, i.e. a migration for a new field is missing or not applied.
+func (r *Runner) MissingColumns() ([]string, error) {
+	rows, err := r.query(fmt.Sprintf("SELECT name FROM system.columns WHERE database = '%s' AND table = '%s'", r.Database, r.Table))
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		have[row[0]] = true
+	}
+
+	var missing []string
+	for _, col := range modelColumns() {
+		if !have[col] {
+			missing = append(missing, col)
+		}
+	}
+	return missing, nil
+}
+
+// modelColumns returns the column names the collector writes: the JSON
+// names of model.DNSLog, which JSONEachRow maps to columns.
+func modelColumns() []string {
+	t := reflect.TypeOf(model.DNSLog{})
+	var cols []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
+
+func (r *Runner) ensureTable() error {
+	for _, stmt := range []string{
+		"CREATE DATABASE IF NOT EXISTS {database}",
+		`CREATE TABLE IF NOT EXISTS {database}.schema_migrations
+(
+  version UInt32,
+  name String,
+  checksum String,
+  applied_at DateTime DEFAULT now()
+)
+ENGINE = ReplacingMergeTree
+ORDER BY version`,
+		`CREATE TABLE IF NOT EXISTS {database}.schema_retention
+(
+  table String,
+  ttl String,
+  applied_at DateTime DEFAULT now()
+)
+ENGINE = ReplacingMergeTree(applied_at)
+ORDER BY table`,
+	} {
+		if err := r.exec(r.expand(stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) expand(sql string) string {
+	return strings.NewReplacer("{database}", r.Database, "{table}", r.Table).Replace(sql)
+}
+
+// exec runs one statement.
+func (r *Runner) exec(stmt string) error {
+	_, err := r.do(stmt)
+	return err
+}
+
+// query runs a SELECT and returns its rows as tab-separated fields.
+func (r *Runner) query(stmt string) ([][]string, error) {
+	body, err := r.do(stmt + " FORMAT TabSeparated")
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		rows = append(rows, strings.Split(sc.Text(), "\t"))
+	}
+	return rows, sc.Err()
+}
+
+func (r *Runner) do(stmt string) ([]byte, error) {
+	req, err := http.NewRequest("POST", r.URL+"?"+neturl.Values{"wait_end_of_query": {"1"}}.Encode(), strings.NewReader(stmt))
+	if err != nil {
+		return nil, err
+	}
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clickhouse status=%s body=%q statement=%q", resp.Status, strings.TrimSpace(string(body)), firstLine(stmt))
+	}
+	return body, nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
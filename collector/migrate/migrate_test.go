@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{Database: "dns", Table: "dns_logs"}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("%04d_%s: versions must be consecutive, want %d", m.Version, m.Name, i+1)
+		}
+		stmts := m.statements(r.expand)
+		if len(stmts) == 0 {
+			t.Errorf("%04d_%s: no statements", m.Version, m.Name)
+		}
+		for _, stmt := range stmts {
+			if strings.ContainsAny(stmt.SQL+stmt.OnlyIf, "{}") {
+				t.Errorf("%04d_%s: unexpanded placeholder in %q", m.Version, m.Name, firstLine(stmt.SQL))
+			}
+		}
+	}
+}
+
+func TestOnlyIf(t *testing.T) {
+	m := Migration{SQL: "-- only-if: SELECT count() > 0 FROM {database}.{table}\n-- comment\nSELECT 1;\n"}
+	if got := m.onlyIf(); got != "SELECT count() > 0 FROM {database}.{table}" {
+		t.Errorf("onlyIf() = %q", got)
+	}
+	if got := (Migration{SQL: "-- comment\n-- only-if: SELECT 1\n"}).onlyIf(); got != "" {
+		t.Errorf("only-if after the first line = %q, want none", got)
+	}
+	r := &Runner{Database: "dns", Table: "dns_logs"}
+	if got := m.statements(r.expand); len(got) != 1 || got[0] != (statement{SQL: "SELECT 1"}) {
+		t.Errorf("statements() = %q", got)
+	}
+}
+
+func TestStatementOnlyIf(t *testing.T) {
+	m := Migration{SQL: `-- first line
+CREATE TABLE {table}_new (x UInt8);
+-- only-if: SELECT count() FROM system.tables WHERE name = '{table}_old'
+-- the guard applies to the next statement only
+INSERT INTO {table}_new SELECT x FROM {table}_old; DROP TABLE IF EXISTS {table}_old;
+`}
+	r := &Runner{Database: "dns", Table: "dns_logs"}
+	want := []statement{
+		{SQL: "CREATE TABLE dns_logs_new (x UInt8)"},
+		{SQL: "INSERT INTO dns_logs_new SELECT x FROM dns_logs_old", OnlyIf: "SELECT count() FROM system.tables WHERE name = 'dns_logs_old'"},
+		{SQL: "DROP TABLE IF EXISTS dns_logs_old"},
+	}
+	got := m.statements(r.expand)
+	if len(got) != len(want) {
+		t.Fatalf("statements() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTimestampMigrationResumes(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{Database: "dns", Table: "dns_logs"}
+	// 0002 has shipped: its only guard is the DateTime check, and 0018
+	// finishes a run that stopped after the swap.
+	for _, stmt := range migrations[1].statements(r.expand) {
+		if stmt.OnlyIf != "" {
+			t.Errorf("0002 step guarded by %q: %s", stmt.OnlyIf, firstLine(stmt.SQL))
+		}
+	}
+	leftover := migrations[17]
+	if leftover.Version != 18 || !strings.Contains(leftover.onlyIf(), "{table}_migrate") {
+		t.Fatalf("migration %d guard %q does not check for a leftover dns_logs_migrate", leftover.Version, leftover.onlyIf())
+	}
+	// The copy resumes after a time cutoff, and the drop waits for every
+	// old row to be there.
+	stmts := leftover.statements(r.expand)
+	if len(stmts) != 2 || strings.Contains(stmts[0].SQL, "NOT IN") || stmts[0].OnlyIf != "" ||
+		!strings.HasPrefix(stmts[1].SQL, "DROP TABLE") || !strings.Contains(stmts[1].OnlyIf, "count()") {
+		t.Errorf("0018 steps: %+v", stmts)
+	}
+}
+
+func TestBackfillCutoff(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{Database: "dns", Table: "dns_logs"}
+	// The cutoffs are taken once, from the rollups: a retry keeps them, and
+	// a view that was altered or recreated since (0017) does not move them.
+	backfill := migrations[15]
+	stmts := backfill.statements(r.expand)
+	if backfill.Version != 16 || !strings.HasPrefix(stmts[0].SQL, "CREATE TABLE dns.rollups_backfill_cutoff") ||
+		!strings.Contains(stmts[0].OnlyIf, "rollups_backfill_cutoff") {
+		t.Fatalf("migration %d starts with %q guarded by %q", backfill.Version, firstLine(stmts[0].SQL), stmts[0].OnlyIf)
+	}
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.SQL, "metadata_modification_time") {
+			t.Errorf("cutoff from the view's metadata: %s", firstLine(stmt.SQL))
+		}
+	}
+	if last := stmts[len(stmts)-1]; !strings.HasPrefix(last.SQL, "DROP TABLE IF EXISTS dns.rollups_backfill_cutoff") {
+		t.Errorf("last step %q, want the cutoffs dropped", firstLine(last.SQL))
+	}
+}
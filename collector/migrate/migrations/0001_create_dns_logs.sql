@@ -0,0 +1,45 @@
+-- dns_logs with every column the collector wrote when migrations were
+-- introduced. Existing tables are left alone; the following migrations
+-- bring older ones up to date.
+
+CREATE DATABASE IF NOT EXISTS {database};
+
+CREATE TABLE IF NOT EXISTS {database}.{table}
+(
+  `timestamp` DateTime64(6),
+  `client_ip` IPv6,
+  `qname` LowCardinality(String),
+  `qtype` UInt16,
+  `response_type` Enum8('CQ' = 1, 'CR' = 2),
+  `response_size` UInt32,
+  `rcode` UInt8,
+  `opcode` UInt8,
+  `flags` UInt16,
+  `parse_error` LowCardinality(String),
+  `socket_protocol` LowCardinality(String),
+  `socket_family` LowCardinality(String),
+  `query_port` UInt16,
+  `server_identity` LowCardinality(String),
+  `server_version` LowCardinality(String),
+  `edns_present` Bool,
+  `edns_udp_size` UInt16,
+  `edns_do` Bool,
+  `edns_cookie` Bool,
+  `edns_padding` Bool,
+  `ecs_source_prefix` UInt8,
+  `ecs_subnet` String,
+  `response_timestamp` DateTime64(6) DEFAULT toDateTime64(0, 6),
+  `latency_us` UInt32 DEFAULT 0,
+  `sample_weight` Float32 DEFAULT 1,
+  `answer_types` Array(UInt16),
+  `answer_ttls` Array(UInt32),
+  `answer_data` Array(String),
+  INDEX idx_qname qname TYPE bloom_filter GRANULARITY 4,
+  INDEX idx_client client_ip TYPE minmax GRANULARITY 4,
+  INDEX idx_answer_data answer_data TYPE bloom_filter GRANULARITY 4
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (toStartOfHour(timestamp), client_ip, qname)
+TTL toDateTime(timestamp) + INTERVAL 30 DAY
+SETTINGS index_granularity = 8192;
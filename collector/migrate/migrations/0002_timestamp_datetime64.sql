@@ -0,0 +1,44 @@
+-- only-if: SELECT count() FROM system.columns WHERE database = '{database}' AND table = '{table}' AND name = 'timestamp' AND type = 'DateTime'
+--
+-- Installs from before microsecond timestamps: dns_logs.timestamp DateTime
+-- -> DateTime64(6). `timestamp` is part of the partition and sorting key,
+-- so it cannot be changed with ALTER ... MODIFY COLUMN. Instead a new table
+-- is created and swapped in atomically; the collector keeps inserting
+-- throughout, so no rows are lost. Historical rows are then copied back.
+
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `response_timestamp` DateTime DEFAULT toDateTime(0) AFTER `rcode`,
+ADD COLUMN IF NOT EXISTS `latency_us` UInt32 DEFAULT 0 AFTER `response_timestamp`;
+
+DROP TABLE IF EXISTS {database}.{table}_migrate;
+
+CREATE TABLE {database}.{table}_migrate
+(
+  `timestamp` DateTime64(6),
+  `client_ip` IPv6,
+  `qname` LowCardinality(String),
+  `qtype` UInt16,
+  `response_type` Enum8('CQ' = 1, 'CR' = 2),
+  `response_size` UInt32,
+  `rcode` UInt8,
+  `response_timestamp` DateTime64(6) DEFAULT toDateTime64(0, 6),
+  `latency_us` UInt32 DEFAULT 0,
+  INDEX idx_qname qname TYPE bloom_filter GRANULARITY 4,
+  INDEX idx_client client_ip TYPE minmax GRANULARITY 4
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (toStartOfHour(timestamp), client_ip, qname)
+TTL toDateTime(timestamp) + INTERVAL 30 DAY
+SETTINGS index_granularity = 8192;
+
+-- New (empty) table becomes {database}.{table}; old data moves to {database}.{table}_migrate
+EXCHANGE TABLES {database}.{table} AND {database}.{table}_migrate;
+
+INSERT INTO {database}.{table}
+  (timestamp, client_ip, qname, qtype, response_type, response_size, rcode, response_timestamp, latency_us)
+SELECT
+  timestamp, client_ip, qname, qtype, response_type, response_size, rcode, response_timestamp, latency_us
+FROM {database}.{table}_migrate;
+
+DROP TABLE {database}.{table}_migrate;
@@ -0,0 +1,3 @@
+-- Expire raw rows after 30 days
+ALTER TABLE {database}.{table}
+MODIFY TTL toDateTime(timestamp) + INTERVAL 30 DAY;
@@ -0,0 +1,4 @@
+-- Query/response pairing (collector --pair)
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `response_timestamp` DateTime64(6) DEFAULT toDateTime64(0, 6) AFTER `rcode`,
+ADD COLUMN IF NOT EXISTS `latency_us` UInt32 DEFAULT 0 AFTER `response_timestamp`;
@@ -0,0 +1,6 @@
+-- Answer section (collector --answers)
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `answer_types` Array(UInt16) AFTER `latency_us`,
+ADD COLUMN IF NOT EXISTS `answer_ttls` Array(UInt32) AFTER `answer_types`,
+ADD COLUMN IF NOT EXISTS `answer_data` Array(String) AFTER `answer_ttls`,
+ADD INDEX IF NOT EXISTS idx_answer_data answer_data TYPE bloom_filter GRANULARITY 4;
@@ -0,0 +1,3 @@
+-- Malformed DNS messages (reason from the collector's parser)
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `parse_error` LowCardinality(String) AFTER `rcode`;
@@ -0,0 +1,7 @@
+-- Transport and dnstap sender identity
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `socket_protocol` LowCardinality(String) AFTER `parse_error`,
+ADD COLUMN IF NOT EXISTS `socket_family` LowCardinality(String) AFTER `socket_protocol`,
+ADD COLUMN IF NOT EXISTS `query_port` UInt16 AFTER `socket_family`,
+ADD COLUMN IF NOT EXISTS `server_identity` LowCardinality(String) AFTER `query_port`,
+ADD COLUMN IF NOT EXISTS `server_version` LowCardinality(String) AFTER `server_identity`;
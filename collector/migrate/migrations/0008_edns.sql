@@ -0,0 +1,9 @@
+-- EDNS0 from client queries
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `edns_present` Bool AFTER `server_version`,
+ADD COLUMN IF NOT EXISTS `edns_udp_size` UInt16 AFTER `edns_present`,
+ADD COLUMN IF NOT EXISTS `edns_do` Bool AFTER `edns_udp_size`,
+ADD COLUMN IF NOT EXISTS `edns_cookie` Bool AFTER `edns_do`,
+ADD COLUMN IF NOT EXISTS `edns_padding` Bool AFTER `edns_cookie`,
+ADD COLUMN IF NOT EXISTS `ecs_source_prefix` UInt8 AFTER `edns_padding`,
+ADD COLUMN IF NOT EXISTS `ecs_subnet` String AFTER `ecs_source_prefix`;
@@ -0,0 +1,4 @@
+-- Header opcode and flag bits (QR/AA/TC/RD/RA/AD/CD at their wire positions)
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `opcode` UInt8 AFTER `rcode`,
+ADD COLUMN IF NOT EXISTS `flags` UInt16 AFTER `opcode`;
@@ -0,0 +1,3 @@
+-- Sampling weight (1 / collector sampling rate)
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `sample_weight` Float32 DEFAULT 1 AFTER `latency_us`;
@@ -0,0 +1,92 @@
+-- Dashboard rollups
+-- Filled by materialized views on every insert into dns_logs. They keep a
+-- year of trends while raw rows expire after 30 days. Counts are
+-- sum(sample_weight), so collector sampling is already scaled back up.
+
+-- Per-minute counts by response type, qtype and rcode
+CREATE TABLE IF NOT EXISTS {database}.dns_stats_1m
+(
+  `minute` DateTime,
+  `response_type` Enum8('CQ' = 1, 'CR' = 2),
+  `paired` UInt8,
+  `qtype` UInt16,
+  `rcode` UInt8,
+  `queries` Float64,
+  `latency_us_sum` UInt64,
+  `latency_count` UInt64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(minute)
+ORDER BY (minute, response_type, paired, qtype, rcode)
+TTL minute + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_stats_1m_mv TO {database}.dns_stats_1m AS
+SELECT
+  toStartOfMinute(timestamp) AS minute,
+  response_type,
+  toUInt8(latency_us > 0) AS paired,
+  qtype,
+  rcode,
+  sum(sample_weight) AS queries,
+  sum(toUInt64(latency_us)) AS latency_us_sum,
+  countIf(latency_us > 0) AS latency_count
+FROM {database}.{table}
+GROUP BY minute, response_type, paired, qtype, rcode;
+
+-- Per-hour query counts by domain and by client (client queries only).
+-- Exact sums rather than topK states, because the top lists show counts.
+CREATE TABLE IF NOT EXISTS {database}.dns_domains_1h
+(
+  `hour` DateTime,
+  `qname` LowCardinality(String),
+  `queries` Float64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (hour, qname)
+TTL hour + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_domains_1h_mv TO {database}.dns_domains_1h AS
+SELECT toStartOfHour(timestamp) AS hour, qname, sum(sample_weight) AS queries
+FROM {database}.{table}
+WHERE response_type = 'CQ' AND qname != ''
+GROUP BY hour, qname;
+
+CREATE TABLE IF NOT EXISTS {database}.dns_clients_1h
+(
+  `hour` DateTime,
+  `client_ip` IPv6,
+  `queries` Float64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (hour, client_ip)
+TTL hour + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_clients_1h_mv TO {database}.dns_clients_1h AS
+SELECT toStartOfHour(timestamp) AS hour, client_ip, sum(sample_weight) AS queries
+FROM {database}.{table}
+WHERE response_type = 'CQ'
+GROUP BY hour, client_ip;
+
+-- Per-hour distinct clients and domains (client queries only). Read with
+-- uniqMerge, which also combines hours into a day.
+CREATE TABLE IF NOT EXISTS {database}.dns_uniques_1h
+(
+  `hour` DateTime,
+  `clients` AggregateFunction(uniq, IPv6),
+  `domains` AggregateFunction(uniq, String)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY hour
+TTL hour + INTERVAL 365 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_uniques_1h_mv TO {database}.dns_uniques_1h AS
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  uniqState(client_ip) AS clients,
+  uniqState(qname) AS domains
+FROM {database}.{table}
+WHERE response_type = 'CQ'
+GROUP BY hour;
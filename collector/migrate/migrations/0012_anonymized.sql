@@ -0,0 +1,3 @@
+-- Client address anonymization applied by the collector sink
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `anonymized` Enum8('none' = 0, 'truncate' = 1, 'hmac' = 2) DEFAULT 'none' AFTER `sample_weight`;
@@ -0,0 +1,19 @@
+-- Per-hour top domains and clients as topKWeighted states
+-- Replaces dns_domains_1h and dns_clients_1h, which kept one row per
+-- (hour, qname) and (hour, client_ip) and grew with the number of distinct
+-- names and clients. A state keeps 300 counters per hour; merged over any
+-- range it gives the top 100 with approximate counts, of which the
+-- dashboard shows 20. Weights are sample_weight in hundredths, because
+-- topKWeighted only takes integer weights. Read with
+-- topKWeightedMerge(100, 3, 'counts'), which returns (value, weight, error).
+-- 0014 converts the old tables and 0015 switches the views over.
+CREATE TABLE IF NOT EXISTS {database}.dns_top_1h
+(
+  `hour` DateTime,
+  `domains` AggregateFunction(topKWeighted(100, 3, 'counts'), String, UInt64),
+  `clients` AggregateFunction(topKWeighted(100, 3, 'counts'), IPv6, UInt64)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY hour
+TTL hour + INTERVAL 365 DAY;
@@ -0,0 +1,24 @@
+-- only-if: SELECT count() = 2 FROM system.tables WHERE database = '{database}' AND name IN ('dns_domains_1h', 'dns_clients_1h')
+-- Convert the exact hourly sums of dns_domains_1h and dns_clients_1h into
+-- dns_top_1h, one row per hour. Nothing else writes to dns_top_1h before
+-- 0015 creates its view, so an hour that is already there was converted by
+-- an earlier, interrupted run and is skipped.
+INSERT INTO {database}.dns_top_1h (hour, domains, clients)
+SELECT
+  hour,
+  d.domains,
+  c.clients
+FROM
+(
+  SELECT hour, topKWeightedState(100, 3, 'counts')(toString(qname), toUInt64(round(queries * 100))) AS domains
+  FROM {database}.dns_domains_1h
+  GROUP BY hour
+) AS d
+FULL OUTER JOIN
+(
+  SELECT hour, topKWeightedState(100, 3, 'counts')(client_ip, toUInt64(round(queries * 100))) AS clients
+  FROM {database}.dns_clients_1h
+  GROUP BY hour
+) AS c USING (hour)
+WHERE hour NOT IN (SELECT hour FROM {database}.dns_top_1h)
+SETTINGS join_use_nulls = 0;
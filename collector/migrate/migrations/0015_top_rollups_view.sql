@@ -0,0 +1,14 @@
+-- Fill dns_top_1h on every insert and retire the exact hourly tables
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_top_1h_mv TO {database}.dns_top_1h AS
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  topKWeightedState(100, 3, 'counts')(toString(qname), toUInt64(round(sample_weight * 100))) AS domains,
+  topKWeightedState(100, 3, 'counts')(client_ip, toUInt64(round(sample_weight * 100))) AS clients
+FROM {database}.{table}
+WHERE response_type = 'CQ'
+GROUP BY hour;
+
+DROP VIEW IF EXISTS {database}.dns_domains_1h_mv;
+DROP VIEW IF EXISTS {database}.dns_clients_1h_mv;
+DROP TABLE IF EXISTS {database}.dns_domains_1h;
+DROP TABLE IF EXISTS {database}.dns_clients_1h;
@@ -0,0 +1,67 @@
+-- only-if: SELECT count() > 0 FROM {database}.{table}
+-- Backfill the rollups from rows that were in dns_logs before their views
+-- existed. The cutoff of each rollup is the first period its view wrote
+-- (now if it wrote none yet); later rows reach the rollups through it. The
+-- cutoffs are kept in rollups_backfill_cutoff until the end, so a retry
+-- does not take a period this migration filled in for one. Periods that
+-- already have rollup rows before the cutoff are skipped: they were filled
+-- by hand with the statements that schema.sql used to carry, or by an
+-- interrupted run of this migration.
+-- On a new install dns_logs is empty and the migration is skipped.
+-- only-if: SELECT count() = 0 FROM system.tables WHERE database = '{database}' AND name = 'rollups_backfill_cutoff'
+CREATE TABLE {database}.rollups_backfill_cutoff
+ENGINE = MergeTree ORDER BY tuple()
+AS SELECT
+  (SELECT ifNull(minOrNull(minute), toStartOfMinute(now())) FROM {database}.dns_stats_1m) AS stats,
+  (SELECT ifNull(minOrNull(hour), toStartOfHour(now())) FROM {database}.dns_uniques_1h) AS uniques,
+  (SELECT ifNull(minOrNull(hour), toStartOfHour(now())) FROM {database}.dns_top_1h) AS top;
+
+INSERT INTO {database}.dns_stats_1m
+SELECT
+  toStartOfMinute(timestamp) AS minute,
+  response_type,
+  toUInt8(latency_us > 0) AS paired,
+  qtype,
+  rcode,
+  sum(sample_weight) AS queries,
+  sum(toUInt64(latency_us)) AS latency_us_sum,
+  countIf(latency_us > 0) AS latency_count
+FROM {database}.{table}
+WHERE timestamp < (SELECT stats FROM {database}.rollups_backfill_cutoff)
+  AND toStartOfMinute(timestamp) NOT IN (
+    SELECT minute FROM {database}.dns_stats_1m
+    WHERE minute < (SELECT stats FROM {database}.rollups_backfill_cutoff)
+  )
+GROUP BY minute, response_type, paired, qtype, rcode;
+
+INSERT INTO {database}.dns_uniques_1h
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  uniqState(client_ip) AS clients,
+  uniqState(qname) AS domains
+FROM {database}.{table}
+WHERE response_type = 'CQ'
+  AND timestamp < (SELECT uniques FROM {database}.rollups_backfill_cutoff)
+  AND toStartOfHour(timestamp) NOT IN (
+    SELECT hour FROM {database}.dns_uniques_1h
+    WHERE hour < (SELECT uniques FROM {database}.rollups_backfill_cutoff)
+  )
+GROUP BY hour;
+
+-- dns_top_1h starts with the converted dns_domains_1h and dns_clients_1h
+-- (0014), so its cutoff is the first hour those views wrote.
+INSERT INTO {database}.dns_top_1h
+SELECT
+  toStartOfHour(timestamp) AS hour,
+  topKWeightedState(100, 3, 'counts')(toString(qname), toUInt64(round(sample_weight * 100))) AS domains,
+  topKWeightedState(100, 3, 'counts')(client_ip, toUInt64(round(sample_weight * 100))) AS clients
+FROM {database}.{table}
+WHERE response_type = 'CQ'
+  AND timestamp < (SELECT top FROM {database}.rollups_backfill_cutoff)
+  AND toStartOfHour(timestamp) NOT IN (
+    SELECT hour FROM {database}.dns_top_1h
+    WHERE hour < (SELECT top FROM {database}.rollups_backfill_cutoff)
+  )
+GROUP BY hour;
+
+DROP TABLE IF EXISTS {database}.rollups_backfill_cutoff;
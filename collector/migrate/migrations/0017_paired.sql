@@ -0,0 +1,24 @@
+-- Explicit pairing flag (collector --pair)
+-- dns_stats_1m used to derive `paired` from latency_us > 0, which counted
+-- paired queries answered within the same microsecond as unpaired. The
+-- collector now sets the column; older rows default to whether a response
+-- was merged in (response_timestamp is set on every paired row).
+ALTER TABLE {database}.{table}
+ADD COLUMN IF NOT EXISTS `paired` Bool DEFAULT response_timestamp != toDateTime64(0, 6) AFTER `latency_us`;
+
+-- Recreate the view on the new column. Rows inserted between the two
+-- statements miss the rollup.
+DROP VIEW IF EXISTS {database}.dns_stats_1m_mv;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {database}.dns_stats_1m_mv TO {database}.dns_stats_1m AS
+SELECT
+  toStartOfMinute(timestamp) AS minute,
+  response_type,
+  toUInt8(paired) AS paired,
+  qtype,
+  rcode,
+  sum(sample_weight) AS queries,
+  sum(toUInt64(latency_us)) AS latency_us_sum,
+  countIf(paired) AS latency_count
+FROM {database}.{table}
+GROUP BY minute, response_type, paired, qtype, rcode;
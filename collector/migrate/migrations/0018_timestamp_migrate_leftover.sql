@@ -0,0 +1,26 @@
+-- only-if: SELECT count() FROM system.columns WHERE database = '{database}' AND table = '{table}_migrate' AND name = 'timestamp' AND type = 'DateTime'
+--
+-- Finish a 0002_timestamp_datetime64 that stopped after swapping the tables:
+-- its retry is skipped, as {table}.timestamp is no longer DateTime, and the
+-- old rows were left in {table}_migrate.
+--
+-- The old rows end at the swap, at the newest row of {table}_migrate. Rows
+-- of {table} in whole seconds up to that cutoff are copies, and the copy
+-- resumes after the newest of them. Usually the copy had finished and only
+-- the drop is left. A copy cut short does not go in time order, though, and
+-- may have skipped older rows: then the counts differ and {table}_migrate is
+-- kept for copying those by hand.
+
+INSERT INTO {database}.{table}
+  (timestamp, client_ip, qname, qtype, response_type, response_size, rcode, response_timestamp, latency_us)
+SELECT
+  timestamp, client_ip, qname, qtype, response_type, response_size, rcode, response_timestamp, latency_us
+FROM {database}.{table}_migrate
+WHERE timestamp > (
+  SELECT max(timestamp) FROM {database}.{table}
+  WHERE toUnixTimestamp64Micro(timestamp) % 1000000 = 0
+    AND timestamp <= (SELECT max(timestamp) FROM {database}.{table}_migrate)
+);
+
+-- only-if: SELECT (SELECT count() FROM {database}.{table} WHERE toUnixTimestamp64Micro(timestamp) % 1000000 = 0 AND timestamp <= (SELECT max(timestamp) FROM {database}.{table}_migrate)) >= (SELECT count() FROM {database}.{table}_migrate)
+DROP TABLE IF EXISTS {database}.{table}_migrate;
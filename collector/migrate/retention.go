@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Retention is the TTL of each data tier. Zero keeps a tier forever.
+// Raw rows can also be moved to a colder storage volume before they
+// expire; the table's storage policy must contain that volume.
+type Retention struct {
+	RawDays       int // {table}
+	MinuteMonths  int // dns_stats_1m
+	HourlyMonths  int // dns_top_1h, dns_uniques_1h
+	ColdVolume    string
+	ColdAfterDays int
+}
+
+// TableTTL is the wanted and current TTL of one table.
+type TableTTL struct {
+	Table   string
+	Want    string // TTL expression, empty for none
+	Current string // from system.tables, as ClickHouse formats it
+	Applied string // as last set by ApplyRetention
+	Missing bool   // table does not exist (migrations not applied)
+
+	recorded bool // Applied is known
+}
+
+// Pending reports whether the table's TTL needs to be changed.
+func (t TableTTL) Pending() bool {
+	if t.Missing || (t.recorded && t.Applied == t.Want) {
+		return false
+	}
+	return normalizeTTL(t.Current) != normalizeTTL(t.Want)
+}
+
+// ttls returns the TTL expression per table. Intervals are written the way
+// ClickHouse prints them back, so an unchanged TTL compares equal.
+func (p Retention) ttls(table string) []TableTTL {
+	var raw []string
+	if p.ColdVolume != "" {
+		raw = append(raw, fmt.Sprintf("toDateTime(timestamp) + toIntervalDay(%d) TO VOLUME %s", p.ColdAfterDays, quote(p.ColdVolume)))
+	}
+	if p.RawDays > 0 {
+		raw = append(raw, fmt.Sprintf("toDateTime(timestamp) + toIntervalDay(%d)", p.RawDays))
+	}
+
+	months := func(col string, n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return fmt.Sprintf("%s + toIntervalMonth(%d)", col, n)
+	}
+	return []TableTTL{
+		{Table: table, Want: strings.Join(raw, ", ")},
+		{Table: "dns_stats_1m", Want: months("minute", p.MinuteMonths)},
+		{Table: "dns_top_1h", Want: months("hour", p.HourlyMonths)},
+		{Table: "dns_uniques_1h", Want: months("hour", p.HourlyMonths)},
+	}
+}
+
+// RetentionStatus compares the policy with the TTL each table has now.
+func (r *Runner) RetentionStatus(p Retention) ([]TableTTL, error) {
+	if err := r.ensureTable(); err != nil {
+		return nil, err
+	}
+	tables := p.ttls(r.Table)
+
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = quote(t.Table)
+	}
+	rows, err := r.query(fmt.Sprintf("SELECT name, engine_full FROM system.tables WHERE database = %s AND name IN (%s)",
+		quote(r.Database), strings.Join(names, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string, len(rows))
+	for _, row := range rows {
+		if len(row) == 2 {
+			current[row[0]] = ttlClause(row[1])
+		}
+	}
+
+	rows, err = r.query(r.expand("SELECT table, ttl FROM {database}.schema_retention FINAL"))
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]string, len(rows))
+	for _, row := range rows {
+		if len(row) == 2 {
+			applied[row[0]] = row[1]
+		}
+	}
+
+	for i := range tables {
+		t := &tables[i]
+		cur, ok := current[t.Table]
+		t.Current, t.Missing = cur, !ok
+		t.Applied, t.recorded = applied[t.Table]
+	}
+	return tables, nil
+}
+
+// ApplyRetention sets the TTL of every table whose TTL differs from the
+// policy and returns how many were changed. ClickHouse rewrites the TTL
+// information of existing parts in the background afterwards.
+func (r *Runner) ApplyRetention(p Retention, logf func(format string, args ...any)) (int, error) {
+	tables, err := r.RetentionStatus(p)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, t := range tables {
+		if t.Missing {
+			logf("Retention: %s.%s does not exist, skipped", r.Database, t.Table)
+			continue
+		}
+		if t.Pending() {
+			if t.Table == r.Table && p.ColdVolume != "" {
+				if err := r.checkVolume(p.ColdVolume); err != nil {
+					return n, err
+				}
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s.%s REMOVE TTL", r.Database, t.Table)
+			if t.Want != "" {
+				stmt = fmt.Sprintf("ALTER TABLE %s.%s MODIFY TTL %s", r.Database, t.Table, t.Want)
+			}
+			logf("Retention: %s.%s TTL %q -> %q", r.Database, t.Table, t.Current, t.Want)
+			if err := r.exec(stmt); err != nil {
+				return n, fmt.Errorf("retention for %s: %w", t.Table, err)
+			}
+			n++
+		}
+		if !t.recorded || t.Applied != t.Want {
+			if err := r.exec(fmt.Sprintf("INSERT INTO %s.schema_retention (table, ttl) VALUES (%s, %s)",
+				r.Database, quote(t.Table), quote(t.Want))); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// checkVolume fails unless the storage policy of the raw table has volume.
+func (r *Runner) checkVolume(volume string) error {
+	rows, err := r.query(fmt.Sprintf(`SELECT t.storage_policy, countIf(v.volume_name = %s)
+FROM system.tables AS t LEFT JOIN system.storage_policies AS v ON v.policy_name = t.storage_policy
+WHERE t.database = %s AND t.name = %s GROUP BY t.storage_policy`, quote(volume), quote(r.Database), quote(r.Table)))
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || len(rows[0]) != 2 {
+		return fmt.Errorf("retention: %s.%s not found", r.Database, r.Table)
+	}
+	if rows[0][1] == "0" {
+		return fmt.Errorf("retention: storage policy %q of %s.%s has no volume %q; switch the table to a policy that has it (ALTER TABLE ... MODIFY SETTING storage_policy = '...')",
+			rows[0][0], r.Database, r.Table, volume)
+	}
+	return nil
+}
+
+// ttlClause extracts the TTL expression from system.tables.engine_full.
+func ttlClause(engine string) string {
+	_, ttl, ok := strings.Cut(engine, " TTL ")
+	if !ok {
+		return ""
+	}
+	ttl, _, _ = strings.Cut(ttl, " SETTINGS ")
+	return strings.TrimSpace(ttl)
+}
+
+// normalizeTTL drops the formatting differences that do not change a TTL.
+func normalizeTTL(ttl string) string {
+	return strings.Join(strings.Fields(strings.ToLower(ttl)), "")
+}
+
+// quote returns s as a ClickHouse string literal.
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
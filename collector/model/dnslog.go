@@ -2,11 +2,48 @@ package model
 
 // DNSLog represents a single DNS query/response event to be stored in ClickHouse.
 type DNSLog struct {
-	Timestamp    string `json:"timestamp"` // ClickHouse DateTime format: "2006-01-02 15:04:05"
+	Timestamp    string `json:"timestamp"` // ClickHouse DateTime64(6) format: "2006-01-02 15:04:05.000000"
 	ClientIP     string `json:"client_ip"` // ClickHouse IPv6 format (IPv4-mapped if needed)
 	QName        string `json:"qname"`
 	QType        uint16 `json:"qtype"`         // numeric DNS type
 	ResponseType string `json:"response_type"` // "CQ" or "CR" (Enum8 in CH)
 	ResponseSize uint32 `json:"response_size"`
-	RCode        uint8  `json:"rcode"` // 0..15
+	RCode        uint8  `json:"rcode"`                 // 0..15
+	Opcode       uint8  `json:"opcode"`                // 0 QUERY, 4 NOTIFY, 5 UPDATE
+	Flags        uint16 `json:"flags"`                 // QR/AA/TC/RD/RA/AD/CD bits as in the header word
+	ParseError   string `json:"parse_error,omitempty"` // set when the DNS message is malformed
+
+	// Transport and sender (dnstap Message / Dnstap fields)
+	SocketProtocol string `json:"socket_protocol"` // "UDP", "TCP", "DOT", "DOH", ...
+	SocketFamily   string `json:"socket_family"`   // "INET" or "INET6"
+	QueryPort      uint16 `json:"query_port"`      // client source port
+	ServerIdentity string `json:"server_identity"` // dnstap identity of the dnsdist frontend
+	ServerVersion  string `json:"server_version"`
+
+	// EDNS0 from the query's OPT record.
+	EDNSPresent     bool   `json:"edns_present"`
+	EDNSUDPSize     uint16 `json:"edns_udp_size"`
+	EDNSDO          bool   `json:"edns_do"` // DNSSEC OK bit
+	EDNSCookie      bool   `json:"edns_cookie"`
+	EDNSPadding     bool   `json:"edns_padding"`
+	ECSSourcePrefix uint8  `json:"ecs_source_prefix"`
+	ECSSubnet       string `json:"ecs_subnet"` // e.g. "192.0.2.0/24", empty without ECS
+
+	// 1/sampling rate; omitted (ClickHouse default 1) when sampling is off.
+	SampleWeight float32 `json:"sample_weight,omitempty"`
+
+	// "truncate" or "hmac" when the sink rewrote client_ip and ecs_subnet;
+	// omitted (ClickHouse default 'none') for real addresses.
+	Anonymized string `json:"anonymized,omitempty"`
+
+	// Set on CQ rows that were paired with their CLIENT_RESPONSE. Paired is
+	// the flag; LatencyUs can be 0 for a response within the microsecond.
+	Paired            bool   `json:"paired"`
+	ResponseTimestamp string `json:"response_timestamp,omitempty"`
+	LatencyUs         uint32 `json:"latency_us"`
+
+	// Answer section of responses (collector -answers); parallel arrays.
+	AnswerTypes []uint16 `json:"answer_types,omitempty"`
+	AnswerTTLs  []uint32 `json:"answer_ttls,omitempty"`
+	AnswerData  []string `json:"answer_data,omitempty"`
 }
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audit actions.
+const (
+	ActionLogin        = "login"
+	ActionLoginFailed  = "login_failed"
+	ActionLogout       = "logout"
+	ActionClientLookup = "client_lookup"
+	ActionUserCreate   = "user_create"
+	ActionUserUpdate   = "user_update"
+	ActionUserDelete   = "user_delete"
+	ActionPassword     = "password_change"
+	ActionTokenCreate  = "token_create"
+	ActionTokenRevoke  = "token_revoke"
+	ActionListAdd      = "list_add"
+	ActionListRemove   = "list_remove"
+	ActionListApply    = "list_apply"
+)
+
+// Event is one line of the audit log.
+type Event struct {
+	Time   time.Time         `json:"time"`
+	User   string            `json:"user"`
+	Role   string            `json:"role,omitempty"`
+	Action string            `json:"action"`
+	Remote string            `json:"remote"`
+	Client string            `json:"client,omitempty"` // the client address looked up
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+// AuditLog appends events as JSON lines to a file. Without a path events
+// go to the process log only.
+type AuditLog struct {
+	Path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenAuditLog opens path for appending, creating it if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{Path: path}
+	if path == "" {
+		return a, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	a.f = f
+	return a, nil
+}
+
+// Record writes ev. Failures are logged; they do not fail the request.
+func (a *AuditLog) Record(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if a == nil || a.f == nil {
+		log.Printf("audit: %s", line)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		log.Printf("Writing audit log %s failed: %v (event %s)", a.Path, err, line)
+	}
+}
+
+// Kept reports whether events go to a file, so Tail can read them back.
+func (a *AuditLog) Kept() bool {
+	return a != nil && a.Path != ""
+}
+
+// Tail returns the last limit events for which match returns true, oldest
+// first.
+func (a *AuditLog) Tail(limit int, match func(Event) bool) ([]Event, error) {
+	out := []Event{}
+	if a == nil || a.Path == "" {
+		return out, nil
+	}
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var ev Event
+		if json.Unmarshal(sc.Bytes(), &ev) != nil || !match(ev) {
+			continue
+		}
+		out = append(out, ev)
+		if len(out) >= 2*limit {
+			out = append(out[:0], out[len(out)-limit:]...)
+		}
+	}
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, sc.Err()
+}
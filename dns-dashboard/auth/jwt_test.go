@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testRSAKey = sync.OnceValue(func() *rsa.PrivateKey {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	})
+	testECKey = sync.OnceValue(func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	})
+)
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+// signJWT builds a compact JWS of claims. "none" gets an empty signature
+// and HS* an HMAC keyed with "secret", the forms an attacker would send.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	return signed + "." + b64(signJWS(t, alg, key, []byte(signed)))
+}
+
+func signJWS(t *testing.T, alg string, key crypto.Signer, signed []byte) []byte {
+	t.Helper()
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[len(alg)-3:]]
+	switch {
+	case alg == "none":
+		return nil
+	case strings.HasPrefix(alg, "HS"):
+		m := hmac.New(hash.New, []byte("secret"))
+		m.Write(signed)
+		return m.Sum(nil)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg[0] == 'P' {
+			sig, err = rsa.SignPSS(rand.Reader, k, hash, digest, nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		if err == nil {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// publicJWK is key as a JWKS entry.
+func publicJWK(kid string, key crypto.Signer) jwk {
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{Kid: kid, Kty: "RSA", Use: "sig", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jwk{Kid: kid, Kty: "EC", Use: "sig", Crv: k.Curve.Params().Name,
+			X: b64(k.X.FillBytes(make([]byte, size))), Y: b64(k.Y.FillBytes(make([]byte, size)))}
+	}
+	panic("unsupported key")
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, ecKey := testRSAKey(), testECKey()
+	for _, tc := range []struct {
+		name    string
+		alg     string // header alg
+		signAlg string // how the token is really signed, if different
+		signer  crypto.Signer
+		key     crypto.PublicKey // to verify with
+		ok      bool
+	}{
+		{"RS256", "RS256", "", rsaKey, rsaKey.Public(), true},
+		{"RS512", "RS512", "", rsaKey, rsaKey.Public(), true},
+		{"PS256", "PS256", "", rsaKey, rsaKey.Public(), true},
+		{"ES256", "ES256", "", ecKey, ecKey.Public(), true},
+		{"alg none", "none", "", nil, rsaKey.Public(), false},
+		{"HS256", "HS256", "", nil, rsaKey.Public(), false},
+		{"RSA key with an ES alg", "ES256", "RS256", rsaKey, rsaKey.Public(), false},
+		{"EC key with an RS alg", "RS256", "ES256", ecKey, ecKey.Public(), false},
+		{"PS signature as RS", "RS256", "PS256", rsaKey, rsaKey.Public(), false},
+		{"another RSA key", "RS256", "", rsaKey, &rsa.PublicKey{N: big.NewInt(0).Lsh(big.NewInt(1), 2047), E: 65537}, false},
+	} {
+		signAlg := tc.signAlg
+		if signAlg == "" {
+			signAlg = tc.alg
+		}
+		signed := []byte("header.claims")
+		sig := signJWS(t, signAlg, tc.signer, signed)
+		err := verifySignature(tc.alg, tc.key, signed, sig)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: verifySignature = %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if tc.ok {
+			if err := verifySignature(tc.alg, tc.key, []byte("header.claimz"), sig); err == nil {
+				t.Errorf("%s: signature valid for altered content", tc.name)
+			}
+		}
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	tok := signJWT(t, "RS256", "k1", testRSAKey(), map[string]any{"sub": "alice", "exp": 1767312000, "aud": []string{"a", "b"}})
+	h, claims, signed, sig, err := parseJWT(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Alg != "RS256" || h.Kid != "k1" {
+		t.Errorf("header = %+v", h)
+	}
+	if exp, ok := claimTime(claims, "exp"); !ok || exp != 1767312000 {
+		t.Errorf("exp = %d, %v", exp, ok)
+	}
+	if aud := claimStrings(claims, "aud"); len(aud) != 2 || aud[1] != "b" {
+		t.Errorf("aud = %q", aud)
+	}
+	if string(signed) != tok[:strings.LastIndex(tok, ".")] || len(sig) != 256 {
+		t.Errorf("signed part or signature split wrongly")
+	}
+
+	parts := strings.Split(tok, ".")
+	for name, bad := range map[string]string{
+		"two parts":        parts[0] + "." + parts[1],
+		"four parts":       tok + ".x",
+		"header not b64":   "!!." + parts[1] + "." + parts[2],
+		"header not JSON":  b64([]byte("alg")) + "." + parts[1] + "." + parts[2],
+		"claims not JSON":  parts[0] + "." + b64([]byte("[1,")) + "." + parts[2],
+		"claims not b64":   parts[0] + ".!!." + parts[2],
+		"signature padded": tok + "==",
+	} {
+		if _, _, _, _, err := parseJWT(bad); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Config is the auth part of the dashboard environment.
+type Config struct {
+	UsersFile     string        // DASHBOARD_USERS_FILE
+	TokensFile    string        // DASHBOARD_TOKENS_FILE
+	AuditLog      string        // DASHBOARD_AUDIT_LOG, empty logs to stderr
+	SessionTTL    time.Duration // DASHBOARD_SESSION_TTL
+	SessionIdle   time.Duration // DASHBOARD_SESSION_IDLE
+	SecureCookie  bool          // DASHBOARD_COOKIE_SECURE, also set for https requests
+	PasswordLogin bool          // DASHBOARD_PASSWORD_LOGIN: the login form; basic auth always works
+}
+
+// Package state, set up by Init like db.DB.
+var (
+	Users    *Store
+	Tokens   *TokenStore
+	Sessions *SessionStore
+	Audit    *AuditLog
+
+	// PasswordLogin enables the login form. With single sign-on it can be
+	// turned off; scripts still use basic auth.
+	PasswordLogin bool
+
+	secureCookie bool
+	failures     = &loginLimiter{fails: map[string]*failState{}}
+)
+
+// SessionCookie is the name of the session cookie.
+const SessionCookie = "dns_dashboard_session"
+
+// Init opens the user and token stores and the audit log.
+func Init(cfg Config) error {
+	var err error
+	if Users, err = OpenStore(cfg.UsersFile); err != nil {
+		return fmt.Errorf("user store: %w", err)
+	}
+	if Tokens, err = OpenTokenStore(cfg.TokensFile); err != nil {
+		return fmt.Errorf("token store: %w", err)
+	}
+	go Tokens.FlushLoop(time.Minute)
+	if Audit, err = OpenAuditLog(cfg.AuditLog); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	Sessions = NewSessionStore(cfg.SessionTTL, cfg.SessionIdle)
+	secureCookie = cfg.SecureCookie
+	PasswordLogin = cfg.PasswordLogin
+	return nil
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Username string
+	Role     Role
+	Method   string // password or oidc (sessions), basic or token
+	Session  string // session ID
+	CSRF     string // session CSRF token
+	Token    string // API token ID
+}
+
+// Can reports whether the caller has at least role.
+func (p *Principal) Can(role Role) bool {
+	return p != nil && p.Role >= role
+}
+
+const principalKey = "auth.principal"
+
+// Current returns the caller of c, or nil before Middleware ran.
+func Current(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals(principalKey).(*Principal)
+	return p
+}
+
+// Can reports whether the caller of c has at least role.
+func Can(c *fiber.Ctx, role Role) bool {
+	return Current(c).Can(role)
+}
+
+// publicPaths are served without a login.
+var publicPaths = map[string]bool{
+	"/login": true,
+}
+
+// Middleware authenticates every request except the login page, from a
+// bearer API token, the session cookie or HTTP basic auth (for scripts).
+// Session requests other than GET and HEAD must carry the session's CSRF
+// token. Unauthenticated page requests are redirected to the login page,
+// API requests get 401.
+func Middleware(c *fiber.Ctx) error {
+	if publicPaths[c.Path()] {
+		return c.Next()
+	}
+
+	if secret, ok := bearerToken(c); ok {
+		return tokenAuth(c, secret)
+	}
+
+	if id := c.Cookies(SessionCookie); id != "" {
+		if p := sessionPrincipal(id); p != nil {
+			if !safeMethod(c.Method()) && !validCSRF(p.CSRF, csrfToken(c)) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "missing or invalid CSRF token"})
+			}
+			c.Locals(principalKey, p)
+			return c.Next()
+		}
+	}
+
+	if username, password, ok := basicAuth(c); ok {
+		u, err := authenticate(c, username, password, "basic")
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Locals(principalKey, &Principal{Username: u.Username, Role: u.Role, Method: "basic"})
+		return c.Next()
+	}
+
+	if isAPI(c) || !safeMethod(c.Method()) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "authentication required"})
+	}
+	return c.Redirect("/login?next=" + url.QueryEscape(c.OriginalURL()))
+}
+
+// tokenAuth authenticates an API token request. Tokens only reach the
+// paths of their scopes and are rate limited each on their own.
+func tokenAuth(c *fiber.Ctx, secret string) error {
+	if !isAPI(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API tokens only work for /api"})
+	}
+	if failures.blocked(c.IP()) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "too many failed logins, try again later"})
+	}
+	t, err := Tokens.Authenticate(secret, c.IP())
+	if err != nil {
+		failures.fail(c.IP())
+		Record(c, Event{User: "token", Action: ActionLoginFailed, Detail: map[string]string{"method": "token"}})
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	failures.reset(c.IP())
+	if !tokenAllowed(t, c.Method(), c.Path()) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "token scope does not allow " + c.Method() + " " + c.Path()})
+	}
+	if ok, wait := Tokens.Allow(t); !ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": fmt.Sprintf("rate limit of %d requests per minute exceeded", t.RateLimit)})
+	}
+	c.Locals(principalKey, &Principal{Username: "token:" + t.ID, Role: t.Role(), Method: "token", Token: t.ID})
+	return c.Next()
+}
+
+func tokenAllowed(t Token, method, path string) bool {
+	if slices.Contains(t.Scopes, ScopeAdmin) {
+		return true
+	}
+	if !safeMethod(method) {
+		return false
+	}
+	for _, scope := range t.Scopes {
+		if slices.Contains(tokenPaths[scope], path) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionPrincipal returns the caller of a live session. Password sessions
+// end when their user is disabled, deleted or gets a new password.
+func sessionPrincipal(id string) *Principal {
+	sess, ok := Sessions.Get(id)
+	if !ok {
+		return nil
+	}
+	p := &Principal{Username: sess.Username, Role: sess.Role, Method: sess.Method, Session: sess.ID, CSRF: sess.CSRF}
+	if sess.Method == "password" {
+		u, ok := Users.Get(sess.Username)
+		if !ok || u.Disabled || u.PasswordHash != sess.hash {
+			Sessions.Delete(sess.ID)
+			return nil
+		}
+		p.Role = u.Role
+	}
+	return p
+}
+
+// Require rejects callers below role.
+func Require(role Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !Can(c, role) {
+			if isAPI(c) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": role.String() + " role required"})
+			}
+			return c.Status(fiber.StatusForbidden).SendString("Forbidden: " + role.String() + " role required")
+		}
+		return c.Next()
+	}
+}
+
+// Login checks a login form and starts a session. The form must come from
+// this site, as SameSite cookies do not protect it.
+func Login(c *fiber.Ctx, username, password string) error {
+	if !PasswordLogin {
+		return errors.New("password login is disabled; use single sign-on")
+	}
+	if !sameOrigin(c) {
+		return errors.New("cross-site login rejected")
+	}
+	u, err := authenticate(c, username, password, "form")
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(c, Sessions.Create(u))
+	Record(c, Event{User: u.Username, Role: u.Role.String(), Action: ActionLogin, Detail: map[string]string{"method": "password"}})
+	return nil
+}
+
+func setSessionCookie(c *fiber.Ctx, sess *Session) {
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    sess.ID,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   secureCookie || c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// Logout ends the caller's session.
+func Logout(c *fiber.Ctx) {
+	p := Current(c)
+	if p == nil || p.Session == "" {
+		return
+	}
+	Sessions.Delete(p.Session)
+	c.ClearCookie(SessionCookie)
+	Record(c, Event{Action: ActionLogout})
+}
+
+// authenticate checks credentials, limiting failures per remote address
+// and auditing them.
+func authenticate(c *fiber.Ctx, username, password, method string) (User, error) {
+	if failures.blocked(c.IP()) {
+		return User{}, errors.New("too many failed logins, try again later")
+	}
+	u, err := Users.Authenticate(username, password)
+	if err != nil {
+		failures.fail(c.IP())
+		Record(c, Event{User: username, Action: ActionLoginFailed, Detail: map[string]string{"method": method}})
+		return User{}, err
+	}
+	failures.reset(c.IP())
+	return u, nil
+}
+
+// Record writes ev to the audit log, filling in the caller and remote
+// address.
+func Record(c *fiber.Ctx, ev Event) {
+	if p := Current(c); p != nil && ev.User == "" {
+		ev.User, ev.Role = p.Username, p.Role.String()
+	}
+	ev.Remote = c.IP()
+	Audit.Record(ev)
+}
+
+func basicAuth(c *fiber.Ctx) (username, password string, ok bool) {
+	h := c.Get(fiber.HeaderAuthorization)
+	if len(h) < 6 || !strings.EqualFold(h[:6], "basic ") {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(h[6:]))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), ":")
+}
+
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	h := c.Get(fiber.HeaderAuthorization)
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+func csrfToken(c *fiber.Ctx) string {
+	if t := c.Get("X-CSRF-Token"); t != "" {
+		return t
+	}
+	return c.FormValue("_csrf")
+}
+
+func safeMethod(m string) bool {
+	return m == fiber.MethodGet || m == fiber.MethodHead || m == fiber.MethodOptions
+}
+
+func isAPI(c *fiber.Ctx) bool {
+	return strings.HasPrefix(c.Path(), "/api/")
+}
+
+// sameOrigin reports whether the Origin, or failing that the Referer, names
+// this host. Requests with neither come from non-browser clients.
+func sameOrigin(c *fiber.Ctx) bool {
+	origin := c.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		origin = c.Get(fiber.HeaderReferer)
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == c.Hostname()
+}
+
+// SafeRedirect returns next if it is a path on this site, else "/".
+func SafeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// Login failures are limited per remote address.
+const (
+	maxFailures   = 10
+	failureWindow = 15 * time.Minute
+)
+
+type failState struct {
+	count int
+	first time.Time
+}
+
+type loginLimiter struct {
+	mu    sync.Mutex
+	fails map[string]*failState
+}
+
+func (l *loginLimiter) blocked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.fails[ip]
+	if !ok {
+		return false
+	}
+	if time.Since(f.first) > failureWindow {
+		delete(l.fails, ip)
+		return false
+	}
+	return f.count >= maxFailures
+}
+
+func (l *loginLimiter) fail(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.fails[ip]
+	if !ok || time.Since(f.first) > failureWindow {
+		if len(l.fails) > 10000 {
+			l.prune()
+		}
+		f = &failState{first: time.Now()}
+		l.fails[ip] = f
+	}
+	f.count++
+	if f.count == maxFailures {
+		log.Printf("Blocking logins from %s for %s after %d failures", ip, failureWindow, maxFailures)
+	}
+}
+
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.fails, ip)
+}
+
+func (l *loginLimiter) prune() {
+	for ip, f := range l.fails {
+		if time.Since(f.first) > failureWindow {
+			delete(l.fails, ip)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testPassword = "correct horse battery"
+
+// newTestApp resets the package state to empty stores in a temporary
+// directory and returns an app behind Middleware whose handlers answer
+// with the caller's name.
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	dir := t.TempDir()
+	var err error
+	if Users, err = OpenStore(filepath.Join(dir, "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	if Tokens, err = OpenTokenStore(filepath.Join(dir, "tokens.json")); err != nil {
+		t.Fatal(err)
+	}
+	if Audit, err = OpenAuditLog(""); err != nil {
+		t.Fatal(err)
+	}
+	Sessions = NewSessionStore(time.Hour, time.Hour)
+	PasswordLogin = true
+	failures = &loginLimiter{fails: map[string]*failState{}}
+
+	app := fiber.New()
+	app.Use(Middleware)
+	whoami := func(c *fiber.Ctx) error { return c.SendString(Current(c).Username) }
+	app.Get("/login", func(c *fiber.Ctx) error { return c.SendString("login page") })
+	app.Get("/", whoami)
+	app.Post("/", whoami)
+	app.Get("/api/me", whoami)
+	app.Post("/api/me", whoami)
+	return app
+}
+
+func addUser(t *testing.T, name string, role Role) User {
+	t.Helper()
+	if err := Users.Add(name, role, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := Users.Get(name)
+	return u
+}
+
+// do sends req through app and returns the status and body.
+func do(t *testing.T, app *fiber.App, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func withSession(req *http.Request, sess *Session) *http.Request {
+	req.AddCookie(&http.Cookie{Name: SessionCookie, Value: sess.ID})
+	return req
+}
+
+func TestMiddlewareUnauthenticated(t *testing.T) {
+	app := newTestApp(t)
+
+	res, body := do(t, app, httptest.NewRequest("GET", "/login", nil))
+	if res.StatusCode != 200 || body != "login page" {
+		t.Errorf("GET /login = %d %q, want the public login page", res.StatusCode, body)
+	}
+	res, _ = do(t, app, httptest.NewRequest("GET", "/?x=1", nil))
+	if res.StatusCode != fiber.StatusFound || res.Header.Get("Location") != "/login?next="+url.QueryEscape("/?x=1") {
+		t.Errorf("GET / = %d to %q, want a redirect to the login page", res.StatusCode, res.Header.Get("Location"))
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/api/me", nil),
+		httptest.NewRequest("POST", "/", nil),
+	} {
+		if res, _ := do(t, app, req); res.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s %s = %d, want 401", req.Method, req.URL.Path, res.StatusCode)
+		}
+	}
+}
+
+func TestMiddlewareSessionCSRF(t *testing.T) {
+	app := newTestApp(t)
+	sess := Sessions.Create(addUser(t, "alice", RoleAdmin))
+
+	if res, body := do(t, app, withSession(httptest.NewRequest("GET", "/api/me", nil), sess)); res.StatusCode != 200 || body != "alice" {
+		t.Errorf("GET with session = %d %q, want 200 alice", res.StatusCode, body)
+	}
+
+	post := func(header, form string) int {
+		var req *http.Request
+		if form != "" {
+			req = httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"_csrf": {form}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest("POST", "/api/me", nil)
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		res, _ := do(t, app, withSession(req, sess))
+		return res.StatusCode
+	}
+	for _, tc := range []struct {
+		name, header, form string
+		want               int
+	}{
+		{"no token", "", "", fiber.StatusForbidden},
+		{"wrong header", "not-the-token", "", fiber.StatusForbidden},
+		{"wrong form field", "", "not-the-token", fiber.StatusForbidden},
+		{"header", sess.CSRF, "", 200},
+		{"form field", "", sess.CSRF, 200},
+	} {
+		if got := post(tc.header, tc.form); got != tc.want {
+			t.Errorf("POST with %s = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMiddlewareBasicAuth(t *testing.T) {
+	app := newTestApp(t)
+	addUser(t, "script", RoleViewer)
+
+	basic := func(user, pass string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/me", nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pass)))
+		return req
+	}
+	// Basic auth carries no session, so it needs no CSRF token.
+	if res, body := do(t, app, basic("script", testPassword)); res.StatusCode != 200 || body != "script" {
+		t.Errorf("basic auth = %d %q, want 200 script", res.StatusCode, body)
+	}
+	if res, _ := do(t, app, basic("script", "wrong password")); res.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("basic auth with a wrong password = %d, want 401", res.StatusCode)
+	}
+	// A dead session falls back to basic auth.
+	req := basic("script", testPassword)
+	req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "expired"})
+	if res, _ := do(t, app, req); res.StatusCode != 200 {
+		t.Errorf("basic auth with a stale cookie = %d, want 200", res.StatusCode)
+	}
+}
+
+func TestSessionPrincipal(t *testing.T) {
+	newTestApp(t)
+	addUser(t, "admin", RoleAdmin)
+	u := addUser(t, "bob", RoleViewer)
+
+	sess := Sessions.Create(u)
+	if p := sessionPrincipal(sess.ID); p == nil || p.Role != RoleViewer {
+		t.Fatalf("sessionPrincipal = %+v, want bob as viewer", p)
+	}
+	// Role changes apply to running sessions.
+	if _, err := Users.Update("bob", func(u *User) error { u.Role = RoleAnalyst; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if p := sessionPrincipal(sess.ID); p == nil || p.Role != RoleAnalyst {
+		t.Errorf("after a role change sessionPrincipal = %+v, want analyst", p)
+	}
+
+	if err := Users.SetPassword("bob", "another long password"); err != nil {
+		t.Fatal(err)
+	}
+	if p := sessionPrincipal(sess.ID); p != nil {
+		t.Error("session survived a password change")
+	}
+	if _, ok := Sessions.Get(sess.ID); ok {
+		t.Error("session ended by a password change is still stored")
+	}
+
+	u, _ = Users.Get("bob")
+	sess = Sessions.Create(u)
+	if _, err := Users.Update("bob", func(u *User) error { u.Disabled = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if p := sessionPrincipal(sess.ID); p != nil {
+		t.Error("session survived disabling the user")
+	}
+
+	// Single sign-on sessions are not tied to the user file.
+	sso := Sessions.CreateSSO("carol@example.com", RoleAnalyst)
+	if p := sessionPrincipal(sso.ID); p == nil || p.Role != RoleAnalyst || p.Method != "oidc" {
+		t.Errorf("SSO sessionPrincipal = %+v", p)
+	}
+}
+
+func TestStoreLastAdmin(t *testing.T) {
+	newTestApp(t)
+	addUser(t, "root", RoleAdmin)
+	addUser(t, "viewer", RoleViewer)
+
+	demote := func(u *User) error { u.Role = RoleViewer; return nil }
+	disable := func(u *User) error { u.Disabled = true; return nil }
+	if _, err := Users.Update("root", demote); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demoting the last admin: %v, want ErrLastAdmin", err)
+	}
+	if _, err := Users.Update("root", disable); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("disabling the last admin: %v, want ErrLastAdmin", err)
+	}
+	if err := Users.Delete("root"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("deleting the last admin: %v, want ErrLastAdmin", err)
+	}
+	if u, _ := Users.Get("root"); u.Role != RoleAdmin || u.Disabled {
+		t.Errorf("refused change was kept: %+v", u)
+	}
+	if err := Users.Delete("viewer"); err != nil {
+		t.Errorf("deleting a viewer: %v", err)
+	}
+
+	// With a second enabled admin the first may go.
+	addUser(t, "root2", RoleAdmin)
+	if _, err := Users.Update("root", disable); err != nil {
+		t.Errorf("disabling one of two admins: %v", err)
+	}
+	if err := Users.Delete("root2"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("deleting the only enabled admin: %v, want ErrLastAdmin", err)
+	}
+	if err := Users.Delete("root"); err != nil {
+		t.Errorf("deleting a disabled admin: %v", err)
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	l := &loginLimiter{fails: map[string]*failState{}}
+	for range maxFailures - 1 {
+		l.fail("192.0.2.1")
+	}
+	if l.blocked("192.0.2.1") {
+		t.Fatal("blocked before maxFailures")
+	}
+	l.fail("192.0.2.1")
+	if !l.blocked("192.0.2.1") {
+		t.Fatal("not blocked after maxFailures")
+	}
+	if l.blocked("192.0.2.2") {
+		t.Error("another address is blocked too")
+	}
+
+	l.fails["192.0.2.1"].first = time.Now().Add(-failureWindow - time.Second)
+	if l.blocked("192.0.2.1") {
+		t.Error("still blocked after the window")
+	}
+
+	for range maxFailures {
+		l.fail("192.0.2.3")
+	}
+	l.reset("192.0.2.3")
+	if l.blocked("192.0.2.3") {
+		t.Error("still blocked after a successful login")
+	}
+}
+
+func TestMiddlewareBlocksAfterFailures(t *testing.T) {
+	app := newTestApp(t)
+	addUser(t, "alice", RoleAdmin)
+	basic := func(pass string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.SetBasicAuth("alice", pass)
+		return req
+	}
+	for range maxFailures {
+		do(t, app, basic("wrong password"))
+	}
+	if res, body := do(t, app, basic(testPassword)); res.StatusCode != fiber.StatusUnauthorized || !strings.Contains(body, "too many") {
+		t.Errorf("right password after %d failures = %d %q, want 401 too many failed logins", maxFailures, res.StatusCode, body)
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	for next, want := range map[string]string{
+		"/logs?ip=1":           "/logs?ip=1",
+		"/":                    "/",
+		"":                     "/",
+		"logs":                 "/",
+		"//evil.example/":      "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+	} {
+		if got := SafeRedirect(next); got != want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if sameOrigin(c) {
+			return c.SendString("same")
+		}
+		return c.SendString("cross")
+	})
+	for _, tc := range []struct {
+		origin, referer, want string
+	}{
+		{"", "", "same"}, // non-browser client
+		{"https://dash.example", "", "same"},
+		{"https://evil.example", "", "cross"},
+		{"", "https://dash.example/login", "same"},
+		{"", "https://evil.example/login", "cross"},
+		{"https://evil.example", "https://dash.example/login", "cross"},
+		{"null", "", "cross"},
+	} {
+		req := httptest.NewRequest("GET", "http://dash.example/", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		if _, got := do(t, app, req); got != tc.want {
+			t.Errorf("Origin %q Referer %q: %s, want %s", tc.origin, tc.referer, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer        string // DASHBOARD_OIDC_ISSUER, e.g. https://sso.example.com/realms/corp
+	ClientID      string // DASHBOARD_OIDC_CLIENT_ID
+	ClientSecret  string // DASHBOARD_OIDC_CLIENT_SECRET, empty for public clients
+	RedirectURL   string // DASHBOARD_OIDC_REDIRECT_URL, ending in /auth/oidc/callback
+	Scopes        []string
+	UsernameClaim string // preferred_username, falling back to email and sub
+	GroupsClaim   string
+	RoleGroups    map[Role][]string // groups granting each role
+	DefaultRole   Role              // for users in none of them; RoleNone refuses them
+}
+
+// RoleFor returns the highest role any of groups grants.
+func (cfg OIDCConfig) RoleFor(groups []string) Role {
+	for _, role := range []Role{RoleAdmin, RoleAnalyst, RoleViewer} {
+		for _, g := range cfg.RoleGroups[role] {
+			if slices.Contains(groups, g) {
+				return role
+			}
+		}
+	}
+	return cfg.DefaultRole
+}
+
+// OIDC is set by InitOIDC when single sign-on is configured.
+var OIDC *OIDCProvider
+
+// OIDCProvider runs the authorization code flow with PKCE (RFC 7636) and
+// verifies the ID token against the provider's published keys.
+type OIDCProvider struct {
+	Config OIDCConfig
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey // by kid
+	keysAt    time.Time
+	pending   map[string]oidcPending // by state
+}
+
+// oidcDiscovery is the part of .well-known/openid-configuration we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending is a login that went to the provider and has not come back.
+type oidcPending struct {
+	verifier string
+	nonce    string
+	next     string
+	created  time.Time
+}
+
+const (
+	oidcStateCookie  = "dns_dashboard_oidc"
+	oidcLoginTimeout = 10 * time.Minute
+	oidcKeysMinAge   = time.Minute // refetch the JWKS for an unknown kid at most this often
+)
+
+// InitOIDC enables single sign-on. The provider is contacted on the first
+// login, so the dashboard starts while it is unreachable.
+func InitOIDC(cfg OIDCConfig) error {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return errors.New("DASHBOARD_OIDC_ISSUER, DASHBOARD_OIDC_CLIENT_ID and DASHBOARD_OIDC_REDIRECT_URL are required")
+	}
+	if _, err := url.Parse(cfg.RedirectURL); err != nil {
+		return fmt.Errorf("redirect URL: %w", err)
+	}
+	if len(cfg.Scopes) == 0 || !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	OIDC = &OIDCProvider{
+		Config:  cfg,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		pending: map[string]oidcPending{},
+	}
+	publicPaths["/auth/oidc/login"] = true
+	publicPaths["/auth/oidc/callback"] = true
+	return nil
+}
+
+// Start begins a login and returns the provider URL to redirect to. The
+// state is also bound to the browser with a short-lived cookie.
+func (o *OIDCProvider) Start(c *fiber.Ctx, next string) (string, error) {
+	d, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+	state, p := randomToken(24), oidcPending{
+		verifier: randomToken(32),
+		nonce:    randomToken(24),
+		next:     SafeRedirect(strings.Clone(next)), // fiber reuses the request buffer
+		created:  time.Now(),
+	}
+	o.mu.Lock()
+	for s, old := range o.pending {
+		if time.Since(old.created) > oidcLoginTimeout {
+			delete(o.pending, s)
+		}
+	}
+	if len(o.pending) >= 10000 {
+		o.mu.Unlock()
+		return "", errors.New("too many logins in progress")
+	}
+	o.pending[state] = p
+	o.mu.Unlock()
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(oidcLoginTimeout / time.Second),
+		HTTPOnly: true,
+		Secure:   secureCookie || c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode, // sent on the provider's redirect back
+	})
+
+	challenge := sha256.Sum256([]byte(p.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.Config.ClientID},
+		"redirect_uri":          {o.Config.RedirectURL},
+		"scope":                 {strings.Join(o.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {p.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Finish handles the provider's redirect back: it redeems the code,
+// verifies the ID token, maps the groups to a role and starts a session.
+// It returns where to send the browser.
+func (o *OIDCProvider) Finish(c *fiber.Ctx) (string, error) {
+	state := c.Query("state")
+	cookie := c.Cookies(oidcStateCookie)
+	c.ClearCookie(oidcStateCookie)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		return "", errors.New("login state mismatch; start the login again")
+	}
+	o.mu.Lock()
+	p, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Since(p.created) > oidcLoginTimeout {
+		return "", errors.New("login expired; start the login again")
+	}
+	if e := c.Query("error"); e != "" {
+		return "", fmt.Errorf("provider refused the login: %s %s", e, c.Query("error_description"))
+	}
+	code := c.Query("code")
+	if code == "" {
+		return "", errors.New("provider returned no code")
+	}
+
+	rawIDToken, err := o.exchange(code, p.verifier)
+	if err != nil {
+		return "", err
+	}
+	claims, err := o.verify(rawIDToken, p.nonce)
+	if err != nil {
+		return "", fmt.Errorf("ID token: %w", err)
+	}
+
+	username := claimString(claims, o.Config.UsernameClaim)
+	for _, name := range []string{"preferred_username", "email", "sub"} {
+		if username == "" {
+			username = claimString(claims, name)
+		}
+	}
+	groups := claimStrings(claims, o.Config.GroupsClaim)
+	role := o.Config.RoleFor(groups)
+	if role == RoleNone {
+		Record(c, Event{User: username, Action: ActionLoginFailed, Detail: map[string]string{"method": "oidc", "reason": "no role for groups " + strings.Join(groups, ",")}})
+		return "", fmt.Errorf("%s is not in a group with access to the dashboard", username)
+	}
+
+	sess := Sessions.CreateSSO(username, role)
+	setSessionCookie(c, sess)
+	Record(c, Event{User: username, Role: role.String(), Action: ActionLogin, Detail: map[string]string{"method": "oidc"}})
+	return p.next, nil
+}
+
+// exchange redeems the authorization code and returns the ID token.
+func (o *OIDCProvider) exchange(code, verifier string) (string, error) {
+	d, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.Config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {o.Config.ClientID},
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.Config.ClientID), url.QueryEscape(o.Config.ClientSecret))
+	}
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := o.doJSON(req, &tok); err != nil {
+		if tok.Error != "" {
+			return "", fmt.Errorf("token endpoint: %s %s", tok.Error, tok.ErrorDescription)
+		}
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if tok.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token; is the openid scope allowed?")
+	}
+	return tok.IDToken, nil
+}
+
+// verify checks the ID token's signature, issuer, audience, lifetime and
+// nonce (OpenID Connect Core 3.1.3.7) and returns its claims.
+func (o *OIDCProvider) verify(raw, nonce string) (map[string]any, error) {
+	h, claims, signed, sig, err := parseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, signed, sig); err != nil {
+		return nil, err
+	}
+
+	if iss := claimString(claims, "iss"); iss != strings.TrimSuffix(o.Config.Issuer, "/") && iss != o.Config.Issuer {
+		return nil, fmt.Errorf("issuer %q is not %q", iss, o.Config.Issuer)
+	}
+	aud := claimStrings(claims, "aud")
+	if !slices.Contains(aud, o.Config.ClientID) {
+		return nil, errors.New("token is not for this client")
+	}
+	if azp := claimString(claims, "azp"); len(aud) > 1 && azp != o.Config.ClientID {
+		return nil, errors.New("token was issued to another client")
+	}
+	now := time.Now().Unix()
+	const skew = 60
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now > exp+skew {
+		return nil, errors.New("token expired")
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && iat > now+skew {
+		return nil, errors.New("token issued in the future")
+	}
+	if got := claimString(claims, "nonce"); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+	return claims, nil
+}
+
+// key returns the signing key kid, refetching the key set when it is
+// unknown so provider key rotation needs no restart.
+func (o *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	keys, fetched := o.keys, o.keysAt
+	o.mu.Unlock()
+	if k, ok := lookupKey(keys, kid); ok {
+		return k, nil
+	}
+	if time.Since(fetched) < oidcKeysMinAge {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	d, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+	keys = map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil && pub != nil {
+			keys[k.Kid] = pub
+		}
+	}
+	o.mu.Lock()
+	o.keys, o.keysAt = keys, time.Now()
+	o.mu.Unlock()
+
+	if k, ok := lookupKey(keys, kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds kid; a token without kid may use the only key.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if k, ok := keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// discover fetches the provider metadata once.
+func (o *OIDCProvider) discover() (*oidcDiscovery, error) {
+	o.mu.Lock()
+	d := o.discovery
+	o.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(o.Config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	d = &oidcDiscovery{}
+	if err := o.doJSON(req, d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(o.Config.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC discovery: issuer %q does not match %q", d.Issuer, o.Config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery: endpoints missing")
+	}
+
+	o.mu.Lock()
+	o.discovery = d
+	o.mu.Unlock()
+	return d, nil
+}
+
+// doJSON sends req and decodes the JSON answer into v, also for error
+// statuses so OAuth error fields can be read.
+func (o *OIDCProvider) doJSON(req *http.Request, v any) error {
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return decodeErr
+}
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testIssuer is an OpenID provider serving discovery, a JWKS and a token
+// endpoint that redeems the codes handed out with grant.
+type testIssuer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]crypto.Signer // published in the JWKS, by kid
+	jwksFetches int
+	grants      map[string]testGrant // by code
+}
+
+type testGrant struct {
+	challenge string // PKCE S256 challenge the verifier must match
+	idToken   string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{
+		keys:   map[string]crypto.Signer{"k1": testRSAKey(), "k2": testECKey()},
+		grants: map[string]testGrant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                iss.URL,
+			AuthorizationEndpoint: iss.URL + "/authorize",
+			TokenEndpoint:         iss.URL + "/token",
+			JWKSURI:               iss.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		iss.jwksFetches++
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, k := range iss.keys {
+			set.Keys = append(set.Keys, publicJWK(kid, k))
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		g, ok := iss.grants[r.FormValue("code")]
+		delete(iss.grants, r.FormValue("code"))
+		iss.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || b64(sum[:]) != g.challenge || r.FormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": g.idToken})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) fetches() int {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	return iss.jwksFetches
+}
+
+func (iss *testIssuer) grant(code, challenge, idToken string) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.grants[code] = testGrant{challenge, idToken}
+}
+
+func (iss *testIssuer) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Config: OIDCConfig{
+			Issuer:      iss.URL,
+			ClientID:    "dashboard",
+			RedirectURL: "http://dash.example/auth/oidc/callback",
+			Scopes:      []string{"openid", "profile"},
+			GroupsClaim: "groups",
+			RoleGroups:  map[Role][]string{RoleAdmin: {"dns-admins"}, RoleViewer: {"staff"}},
+			DefaultRole: RoleNone,
+		},
+		Client:  iss.Client(),
+		pending: map[string]oidcPending{},
+	}
+}
+
+// idClaims are valid ID token claims for the provider.
+func (iss *testIssuer) idClaims(nonce string) map[string]any {
+	now := time.Now().Unix()
+	return map[string]any{
+		"iss":                iss.URL,
+		"aud":                "dashboard",
+		"sub":                "0f3a",
+		"preferred_username": "alice",
+		"groups":             []string{"staff"},
+		"iat":                now,
+		"exp":                now + 300,
+		"nonce":              nonce,
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	o := iss.provider()
+	now := time.Now().Unix()
+
+	for _, tc := range []struct {
+		name    string
+		alg     string // header alg
+		signAlg string // how the token is really signed, if different
+		kid     string
+		modify  func(map[string]any)
+		ok      bool
+	}{
+		{name: "valid RS256", ok: true},
+		{name: "valid ES256", alg: "ES256", kid: "k2", ok: true},
+		{name: "alg none", alg: "none"},
+		{name: "HS256", alg: "HS256"},
+		{name: "RSA key with an ES alg", alg: "ES256", signAlg: "RS256", kid: "k1"},
+		{name: "other issuer", modify: func(c map[string]any) { c["iss"] = "https://evil.example" }},
+		{name: "wrong aud", modify: func(c map[string]any) { c["aud"] = "other-client" }},
+		{name: "several aud without azp", modify: func(c map[string]any) { c["aud"] = []string{"dashboard", "other-client"} }},
+		{name: "several aud, wrong azp", modify: func(c map[string]any) {
+			c["aud"], c["azp"] = []string{"dashboard", "other-client"}, "other-client"
+		}},
+		{name: "several aud, azp is us", ok: true, modify: func(c map[string]any) {
+			c["aud"], c["azp"] = []string{"dashboard", "other-client"}, "dashboard"
+		}},
+		{name: "expired", modify: func(c map[string]any) { c["exp"] = now - 120 }},
+		{name: "within clock skew", ok: true, modify: func(c map[string]any) { c["exp"] = now - 30 }},
+		{name: "no exp", modify: func(c map[string]any) { delete(c, "exp") }},
+		{name: "issued in the future", modify: func(c map[string]any) { c["iat"] = now + 600 }},
+		{name: "nonce mismatch", modify: func(c map[string]any) { c["nonce"] = "replayed" }},
+		{name: "no nonce", modify: func(c map[string]any) { delete(c, "nonce") }},
+	} {
+		alg, kid := tc.alg, tc.kid
+		if alg == "" {
+			alg = "RS256"
+		}
+		if kid == "" {
+			kid = "k1"
+		}
+		claims := iss.idClaims("n0nce")
+		if tc.modify != nil {
+			tc.modify(claims)
+		}
+		signAlg := tc.signAlg
+		if signAlg == "" {
+			signAlg = alg
+		}
+		header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+		payload, _ := json.Marshal(claims)
+		signed := b64(header) + "." + b64(payload)
+		raw := signed + "." + b64(signJWS(t, signAlg, iss.keys[kid], []byte(signed)))
+		_, err := o.verify(raw, "n0nce")
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: verify = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestOIDCKeyRefetch(t *testing.T) {
+	iss := newTestIssuer(t)
+	o := iss.provider()
+	token := func(kid string) string {
+		return signJWT(t, "RS256", kid, iss.keys[kid], iss.idClaims("n"))
+	}
+
+	if _, err := o.verify(token("k1"), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.verify(token("k1"), "n"); err != nil || iss.fetches() != 1 {
+		t.Fatalf("second token: %v after %d JWKS fetches, want the cached key set", err, iss.fetches())
+	}
+
+	// The provider rotates to a new key.
+	iss.mu.Lock()
+	iss.keys["k3"] = testRSAKey()
+	iss.mu.Unlock()
+	// Just after a fetch an unknown kid does not refetch, so a flood of
+	// made-up kids cannot hammer the provider.
+	if _, err := o.verify(token("k3"), "n"); err == nil || iss.fetches() != 1 {
+		t.Fatalf("unknown kid right after a fetch: %v after %d JWKS fetches, want an error and no refetch", err, iss.fetches())
+	}
+	o.mu.Lock()
+	o.keysAt = time.Now().Add(-oidcKeysMinAge)
+	o.mu.Unlock()
+	if _, err := o.verify(token("k3"), "n"); err != nil || iss.fetches() != 2 {
+		t.Errorf("rotated key: %v after %d JWKS fetches, want it found by a refetch", err, iss.fetches())
+	}
+	if _, err := o.verify(token("k1"), "n"); err != nil || iss.fetches() != 2 {
+		t.Errorf("old key after the refetch: %v after %d JWKS fetches", err, iss.fetches())
+	}
+}
+
+func TestOIDCFinish(t *testing.T) {
+	newTestApp(t)
+	iss := newTestIssuer(t)
+	o := iss.provider()
+
+	app := fiber.New()
+	app.Get("/auth/oidc/login", func(c *fiber.Ctx) error {
+		u, err := o.Start(c, c.Query("next"))
+		if err != nil {
+			return err
+		}
+		return c.Redirect(u)
+	})
+	app.Get("/auth/oidc/callback", func(c *fiber.Ctx) error {
+		next, err := o.Finish(c)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+		return c.Redirect(next)
+	})
+
+	// login runs the flow with the provider vouching for claims, and returns
+	// the callback's answer. stateCookie, if set, replaces the browser's
+	// state cookie.
+	login := func(modify func(map[string]any), stateCookie *string) (*http.Response, string) {
+		res, _ := do(t, app, httptest.NewRequest("GET", "/auth/oidc/login?next=/logs", nil))
+		authURL, err := url.Parse(res.Header.Get("Location"))
+		if err != nil || !strings.HasPrefix(authURL.String(), iss.URL+"/authorize?") {
+			t.Fatalf("login redirected to %q", res.Header.Get("Location"))
+		}
+		q := authURL.Query()
+		if q.Get("client_id") != "dashboard" || q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid profile" {
+			t.Fatalf("authorization request %v", q)
+		}
+		var cookie string
+		for _, c := range res.Cookies() {
+			if c.Name == oidcStateCookie {
+				cookie = c.Value
+			}
+		}
+		if cookie != q.Get("state") {
+			t.Fatalf("state cookie %q does not carry the state %q", cookie, q.Get("state"))
+		}
+		if stateCookie != nil {
+			cookie = *stateCookie
+		}
+
+		claims := iss.idClaims(q.Get("nonce"))
+		if modify != nil {
+			modify(claims)
+		}
+		code := randomToken(16)
+		iss.grant(code, q.Get("code_challenge"), signJWT(t, "RS256", "k1", iss.keys["k1"], claims))
+
+		req := httptest.NewRequest("GET", "/auth/oidc/callback?"+url.Values{"state": {q.Get("state")}, "code": {code}}.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+		return do(t, app, req)
+	}
+	session := func(res *http.Response) (Session, bool) {
+		for _, c := range res.Cookies() {
+			if c.Name == SessionCookie {
+				return Sessions.Get(c.Value)
+			}
+		}
+		return Session{}, false
+	}
+
+	for _, tc := range []struct {
+		groups []string
+		role   Role
+	}{
+		{[]string{"dns-admins", "staff"}, RoleAdmin},
+		{[]string{"staff"}, RoleViewer},
+	} {
+		res, body := login(func(c map[string]any) { c["groups"] = tc.groups }, nil)
+		if res.StatusCode != fiber.StatusFound || res.Header.Get("Location") != "/logs" {
+			t.Fatalf("groups %q: callback = %d %q, want a redirect to /logs", tc.groups, res.StatusCode, body)
+		}
+		if sess, ok := session(res); !ok || sess.Username != "alice" || sess.Method != "oidc" || sess.Role != tc.role {
+			t.Errorf("groups %q: session %+v, want alice as %s", tc.groups, sess, tc.role)
+		}
+	}
+
+	refused := func(name, want string, res *http.Response, body string) {
+		t.Helper()
+		if res.StatusCode != fiber.StatusForbidden || !strings.Contains(body, want) {
+			t.Errorf("%s: callback = %d %q, want it refused with %q", name, res.StatusCode, body, want)
+		}
+		if _, ok := session(res); ok {
+			t.Errorf("%s: a session was started", name)
+		}
+	}
+	other := "someone-elses-state"
+	res, body := login(nil, &other)
+	refused("state cookie mismatch", "state mismatch", res, body)
+	empty := ""
+	res, body = login(nil, &empty)
+	refused("no state cookie", "state mismatch", res, body)
+	res, body = login(func(c map[string]any) { c["nonce"] = "from-another-login" }, nil)
+	refused("nonce mismatch", "nonce mismatch", res, body)
+	res, body = login(func(c map[string]any) { c["aud"] = "other-client" }, nil)
+	refused("wrong aud", "not for this client", res, body)
+	res, body = login(func(c map[string]any) { c["groups"] = []string{"contractors"} }, nil)
+	refused("no group with DefaultRole none", "not in a group", res, body)
+
+	o.Config.DefaultRole = RoleViewer
+	res, _ = login(func(c map[string]any) { c["groups"] = []string{"contractors"} }, nil)
+	if sess, ok := session(res); !ok || sess.Role != RoleViewer {
+		t.Errorf("no group with DefaultRole viewer: session %+v, want a viewer", sess)
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// Session is a logged-in browser. For password logins the role is looked
+// up from the user store on every request, so role changes apply
+// immediately; single sign-on sessions keep the role their groups gave at
+// login.
+type Session struct {
+	ID       string
+	Username string
+	Method   string // password or oidc
+	Role     Role   // oidc only
+	CSRF     string // sent back in X-CSRF-Token or _csrf by unsafe requests
+	Created  time.Time
+	LastSeen time.Time
+
+	hash string // password hash at login; a new password ends the session
+}
+
+// validCSRF reports whether token is the session's CSRF token want.
+func validCSRF(want, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// SessionStore keeps sessions in memory; restarting the dashboard logs
+// everyone out.
+type SessionStore struct {
+	TTL  time.Duration // since login
+	Idle time.Duration // since the last request
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates an empty session store.
+func NewSessionStore(ttl, idle time.Duration) *SessionStore {
+	return &SessionStore{TTL: ttl, Idle: idle, sessions: map[string]*Session{}}
+}
+
+// Create starts a session for a user of the store.
+func (s *SessionStore) Create(u User) *Session {
+	return s.add(&Session{Username: u.Username, Method: "password", hash: u.PasswordHash})
+}
+
+// CreateSSO starts a session for a user the OIDC provider vouched for.
+func (s *SessionStore) CreateSSO(username string, role Role) *Session {
+	return s.add(&Session{Username: username, Method: "oidc", Role: role})
+}
+
+func (s *SessionStore) add(sess *Session) *Session {
+	now := time.Now()
+	sess.ID, sess.CSRF = randomToken(32), randomToken(32)
+	sess.Created, sess.LastSeen = now, now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	s.sessions[sess.ID] = sess
+	return sess
+}
+
+// Get returns a live session and marks it used. It returns a copy.
+func (s *SessionStore) Get(id string) (Session, bool) {
+	if id == "" {
+		return Session{}, false
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	if s.expired(sess, now) {
+		delete(s.sessions, id)
+		return Session{}, false
+	}
+	sess.LastSeen = now
+	return *sess, true
+}
+
+// Delete ends a session.
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// DeleteUser ends every password session of username.
+func (s *SessionStore) DeleteUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if sess.Username == username && sess.Method == "password" {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *SessionStore) expired(sess *Session, now time.Time) bool {
+	return now.Sub(sess.Created) > s.TTL || now.Sub(sess.LastSeen) > s.Idle
+}
+
+// expire drops expired sessions. Callers hold s.mu.
+func (s *SessionStore) expire(now time.Time) {
+	for id, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// RandomPassword returns a password for new accounts.
+func RandomPassword() string {
+	return randomToken(15)
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes. Every API path a scope reaches is listed in tokenPaths;
+// admin reaches all of /api.
+const (
+	ScopeStats = "stats:read"
+	ScopeLogs  = "logs:read"
+	ScopeAdmin = "admin"
+)
+
+// tokenPaths are the API paths each scope may GET. Other paths are closed
+// to tokens, so a new endpoint is only reachable once it is added here.
+var tokenPaths = map[string][]string{
+	ScopeStats: {"/api/me", "/api/stats", "/api/query-types", "/api/response-codes",
+		"/api/top-domains", "/api/top-clients", "/api/timeline", "/api/dnsdist-stats"},
+	ScopeLogs: {"/api/me", "/api/logs", "/api/recent-queries"},
+}
+
+var validScopes = []string{ScopeStats, ScopeLogs, ScopeAdmin}
+
+// DefaultTokenRateLimit is the requests per minute of a token created
+// without one.
+const DefaultTokenRateLimit = 60
+
+// tokenPrefix starts every token so leaked ones are easy to grep for.
+const tokenPrefix = "dnsd_"
+
+// Token is an API token for scripts. Only the SHA-256 of the secret is
+// kept; the token itself is shown once, when it is created.
+type Token struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Scopes       []string   `json:"scopes"`
+	RateLimit    int        `json:"rate_limit"` // requests per minute
+	Hash         string     `json:"hash"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	LastUsedFrom string     `json:"last_used_from,omitempty"`
+}
+
+// Role is what the handlers treat the token as: admin tokens as admins,
+// logs tokens as analysts (raw client addresses, audited client searches)
+// and stats tokens as viewers.
+func (t Token) Role() Role {
+	switch {
+	case slices.Contains(t.Scopes, ScopeAdmin):
+		return RoleAdmin
+	case slices.Contains(t.Scopes, ScopeLogs):
+		return RoleAnalyst
+	}
+	return RoleViewer
+}
+
+// Active reports whether the token can be used at now.
+func (t Token) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+var (
+	ErrBadToken = errors.New("invalid, expired or revoked API token")
+	ErrNoToken  = errors.New("no such token")
+)
+
+// TokenStore keeps the tokens in a JSON file next to the users. Only the
+// dashboard writes it; last-used times are saved once a minute.
+type TokenStore struct {
+	Path string
+
+	mu      sync.Mutex
+	tokens  map[string]*Token // by ID
+	dirty   bool
+	buckets map[string]*bucket
+}
+
+// OpenTokenStore reads the token file. A missing file is an empty store.
+func OpenTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{Path: path, tokens: map[string]*Token{}, buckets: map[string]*bucket{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Tokens []*Token `json:"tokens"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, t := range f.Tokens {
+		s.tokens[t.ID] = t
+	}
+	return s, nil
+}
+
+// Create makes a token and returns it with its secret, which is not
+// stored.
+func (s *TokenStore) Create(name string, scopes []string, rateLimit int, ttl time.Duration, createdBy string) (Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return Token{}, "", errors.New("a name of up to 100 characters is required")
+	}
+	if len(scopes) == 0 {
+		return Token{}, "", fmt.Errorf("at least one scope is required (%s)", strings.Join(validScopes, ", "))
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(validScopes, sc) {
+			return Token{}, "", fmt.Errorf("unknown scope %q (%s)", sc, strings.Join(validScopes, ", "))
+		}
+	}
+	if rateLimit < 0 {
+		return Token{}, "", errors.New("rate_limit must not be negative")
+	}
+	if rateLimit == 0 {
+		rateLimit = DefaultTokenRateLimit
+	}
+
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Token{}, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := tokenPrefix + id + "_" + randomToken(32)
+	now := time.Now().UTC()
+	t := &Token{
+		ID:        id,
+		Name:      name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		RateLimit: rateLimit,
+		Hash:      hashToken(secret),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		exp := now.Add(ttl)
+		t.ExpiresAt = &exp
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[id] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return Token{}, "", err
+	}
+	return *t, secret, nil
+}
+
+// Authenticate checks a presented token and records its use.
+func (s *TokenStore) Authenticate(secret, remote string) (Token, error) {
+	rest, ok := strings.CutPrefix(secret, tokenPrefix)
+	if !ok {
+		return Token{}, ErrBadToken
+	}
+	id, _, _ := strings.Cut(rest, "_")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	// compare anyway so unknown IDs take as long
+	want := hashToken("")
+	if ok {
+		want = t.Hash
+	}
+	match := subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(want)) == 1
+	now := time.Now().UTC()
+	if !ok || !match || !t.Active(now) {
+		return Token{}, ErrBadToken
+	}
+	t.LastUsedAt, t.LastUsedFrom = &now, remote
+	s.dirty = true
+	return *t, nil
+}
+
+// Allow takes one request from the token's per-minute budget.
+func (s *TokenStore) Allow(t Token) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[t.ID]
+	if !ok {
+		b = &bucket{tokens: float64(t.RateLimit), last: time.Now()}
+		s.buckets[t.ID] = b
+	}
+	return b.take(float64(t.RateLimit), time.Now())
+}
+
+// List returns all tokens, newest first.
+func (s *TokenStore) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Revoke disables a token for good. Revoked tokens stay listed.
+func (s *TokenStore) Revoke(id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrNoToken
+	}
+	if t.RevokedAt == nil {
+		now := time.Now().UTC()
+		t.RevokedAt = &now
+		if err := s.save(); err != nil {
+			t.RevokedAt = nil
+			return Token{}, err
+		}
+	}
+	delete(s.buckets, id)
+	return *t, nil
+}
+
+// FlushLoop saves last-used times every interval; run it in a goroutine.
+func (s *TokenStore) FlushLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		if s.dirty {
+			if err := s.save(); err != nil {
+				log.Printf("Saving API tokens failed: %v", err)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// save writes the file atomically. Callers hold s.mu.
+func (s *TokenStore) save() error {
+	f := struct {
+		Tokens []*Token `json:"tokens"`
+	}{}
+	for _, t := range s.tokens {
+		f.Tokens = append(f.Tokens, t)
+	}
+	sort.Slice(f.Tokens, func(i, j int) bool { return f.Tokens[i].CreatedAt.Before(f.Tokens[j].CreatedAt) })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.Path, append(data, '\n')); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// bucket is a token bucket refilled at limit per minute.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(limit float64, now time.Time) (bool, time.Duration) {
+	b.tokens = min(limit, b.tokens+now.Sub(b.last).Minutes()*limit)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit * float64(time.Minute))
+	return false, wait
+}
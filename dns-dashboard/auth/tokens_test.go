@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTokenAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := OpenTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, secret, err := s.Create("grafana", []string{ScopeStats}, 0, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix+tok.ID+"_") || tok.RateLimit != DefaultTokenRateLimit {
+		t.Fatalf("Create = %+v with secret %q", tok, secret)
+	}
+	got, err := s.Authenticate(secret, "192.0.2.7")
+	if err != nil || got.ID != tok.ID || got.LastUsedFrom != "192.0.2.7" {
+		t.Fatalf("Authenticate = %+v, %v", got, err)
+	}
+
+	// Only the hash is stored, and it survives a restart.
+	if reopened, err := OpenTokenStore(path); err != nil {
+		t.Fatal(err)
+	} else if _, err := reopened.Authenticate(secret, ""); err != nil {
+		t.Errorf("after reopening the store: %v", err)
+	}
+
+	_, expiring, _ := s.Create("expiring", []string{ScopeLogs}, 0, time.Hour, "admin")
+	_, revoked, _ := s.Create("revoked", []string{ScopeLogs}, 0, 0, "admin")
+	for _, tt := range s.List() {
+		switch tt.Name {
+		case "expiring":
+			past := time.Now().Add(-time.Second)
+			s.tokens[tt.ID].ExpiresAt = &past
+		case "revoked":
+			if _, err := s.Revoke(tt.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	id, rest, _ := strings.Cut(strings.TrimPrefix(secret, tokenPrefix), "_")
+	for name, bad := range map[string]string{
+		"empty":            "",
+		"no prefix":        strings.TrimPrefix(secret, tokenPrefix),
+		"other prefix":     "ghp_" + id + "_" + rest,
+		"unknown ID":       tokenPrefix + "000000000000_" + rest,
+		"wrong secret":     tokenPrefix + id + "_" + strings.Repeat("A", len(rest)),
+		"ID of another":    tokenPrefix + id + "_" + strings.SplitN(revoked, "_", 3)[2],
+		"expired":          expiring,
+		"revoked":          revoked,
+		"secret truncated": secret[:len(secret)-1],
+	} {
+		if _, err := s.Authenticate(bad, ""); !errors.Is(err, ErrBadToken) {
+			t.Errorf("%s: Authenticate = %v, want ErrBadToken", name, err)
+		}
+	}
+}
+
+func TestTokenAllowed(t *testing.T) {
+	stats := Token{Scopes: []string{ScopeStats}}
+	logs := Token{Scopes: []string{ScopeLogs}}
+	both := Token{Scopes: []string{ScopeLogs, ScopeStats}}
+	admin := Token{Scopes: []string{ScopeAdmin}}
+	for _, tc := range []struct {
+		tok          Token
+		method, path string
+		want         bool
+	}{
+		{stats, "GET", "/api/stats", true},
+		{stats, "GET", "/api/timeline", true},
+		{stats, "HEAD", "/api/me", true},
+		{stats, "GET", "/api/logs", false},
+		{stats, "GET", "/api/recent-queries", false},
+		{stats, "GET", "/api/admin/users", false},
+		{stats, "GET", "/api/stats/", false},
+		{logs, "GET", "/api/logs", true},
+		{logs, "GET", "/api/stats", false},
+		{logs, "POST", "/api/logs", false},
+		{both, "GET", "/api/logs", true},
+		{both, "GET", "/api/stats", true},
+		{both, "GET", "/api/admin/tokens", false},
+		{admin, "GET", "/api/admin/users", true},
+		{admin, "POST", "/api/admin/tokens", true},
+		{admin, "DELETE", "/api/admin/lists/block", true},
+	} {
+		if got := tokenAllowed(tc.tok, tc.method, tc.path); got != tc.want {
+			t.Errorf("%v %s %s: allowed = %v, want %v", tc.tok.Scopes, tc.method, tc.path, got, tc.want)
+		}
+	}
+	// Every scope but admin has a path list, or its tokens reach nothing.
+	for _, scope := range validScopes {
+		if scope != ScopeAdmin && len(tokenPaths[scope]) == 0 {
+			t.Errorf("scope %s reaches no path", scope)
+		}
+	}
+}
+
+func TestMiddlewareToken(t *testing.T) {
+	app := newTestApp(t)
+	app.Get("/api/logs", func(c *fiber.Ctx) error { return c.SendString("logs") })
+	app.Get("/api/stats", func(c *fiber.Ctx) error { return c.SendString("stats") })
+	_, secret, err := Tokens.Create("grafana", []string{ScopeStats}, 2, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, secret string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		res, _ := do(t, app, req)
+		return res.StatusCode
+	}
+
+	if got := get("/api/logs", secret); got != fiber.StatusForbidden {
+		t.Errorf("stats token on /api/logs = %d, want 403", got)
+	}
+	if got := get("/", secret); got != fiber.StatusUnauthorized {
+		t.Errorf("token on a page = %d, want 401", got)
+	}
+	if got := get("/api/stats", "dnsd_000000000000_guess"); got != fiber.StatusUnauthorized {
+		t.Errorf("unknown token = %d, want 401", got)
+	}
+
+	// A rate limit of 2 per minute: the third request waits 30s.
+	for i := range 2 {
+		if got := get("/api/stats", secret); got != 200 {
+			t.Fatalf("request %d = %d, want 200", i+1, got)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	res, _ := do(t, app, req)
+	if res.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("third request = %d, want 429", res.StatusCode)
+	}
+	if after, _ := strconv.Atoi(res.Header.Get("Retry-After")); after < 29 || after > 31 {
+		t.Errorf("Retry-After = %q, want about 30", res.Header.Get("Retry-After"))
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := &bucket{tokens: 2, last: now}
+	const limit = 60 // one a second
+
+	for i := range 2 {
+		if ok, _ := b.take(limit, now); !ok {
+			t.Fatalf("take %d refused with a full bucket", i+1)
+		}
+	}
+	if ok, wait := b.take(limit, now); ok || wait != time.Second {
+		t.Errorf("empty bucket: take = %v, %s, want refused for 1s", ok, wait)
+	}
+	now = now.Add(400 * time.Millisecond)
+	if ok, wait := b.take(limit, now); ok || wait != 600*time.Millisecond {
+		t.Errorf("after 400ms: take = %v, %s, want refused for 600ms", ok, wait)
+	}
+	now = now.Add(600 * time.Millisecond)
+	if ok, _ := b.take(limit, now); !ok {
+		t.Error("after 1s: take refused, want one token refilled")
+	}
+
+	// An idle bucket refills to the limit, no further.
+	now = now.Add(time.Hour)
+	for i := range limit {
+		if ok, _ := b.take(limit, now); !ok {
+			t.Fatalf("take %d after an idle hour refused", i+1)
+		}
+	}
+	if ok, _ := b.take(limit, now); ok {
+		t.Error("bucket held more than the limit")
+	}
+}
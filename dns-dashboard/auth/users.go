@@ -0,0 +1,371 @@
+// Package auth implements dashboard users, login sessions and the audit
+// log.
+//
+// Users live in a JSON file with bcrypt password hashes and one of three
+// roles: viewers see aggregate statistics with client addresses masked,
+// analysts also see raw client addresses and may search for a client, and
+// admins may additionally manage users and storage.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is what a user may see and do. Higher roles include lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleAnalyst
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:  "viewer",
+	RoleAnalyst: "analyst",
+	RoleAdmin:   "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+// ParseRole parses viewer, analyst or admin.
+func ParseRole(s string) (Role, error) {
+	for r, name := range roleNames {
+		if name == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q (viewer, analyst or admin)", s)
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(b []byte) error {
+	var err error
+	*r, err = ParseRole(string(b))
+	return err
+}
+
+// User is a dashboard account.
+type User struct {
+	Username     string    `json:"username"`
+	Role         Role      `json:"role"`
+	PasswordHash string    `json:"password_hash"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// MinPasswordLength is enforced when a password is set.
+const MinPasswordLength = 10
+
+var (
+	ErrBadCredentials = errors.New("invalid username or password")
+	ErrNotFound       = errors.New("no such user")
+	ErrExists         = errors.New("user already exists")
+	ErrLastAdmin      = errors.New("at least one enabled admin must remain")
+)
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// dummyHash is compared against for unknown users, so a login takes as long
+// whether or not the user exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dns-dashboard"), bcrypt.DefaultCost)
+
+// Store is the user file. It is re-read when the file changes on disk, so
+// the users CLI can edit it while the dashboard runs.
+type Store struct {
+	Path string
+
+	mu      sync.Mutex
+	users   map[string]User
+	modTime time.Time
+	checked time.Time
+}
+
+// storeFile is the layout of the user file.
+type storeFile struct {
+	Users []User `json:"users"`
+}
+
+// OpenStore reads the user file. A missing file is an empty store.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{Path: path, users: map[string]User{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	fi, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.users, s.modTime = map[string]User{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return err
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%s: %w", s.Path, err)
+	}
+	users := make(map[string]User, len(f.Users))
+	for _, u := range f.Users {
+		if !validUsername.MatchString(u.Username) || u.Role == RoleNone {
+			return fmt.Errorf("%s: invalid user %q", s.Path, u.Username)
+		}
+		users[u.Username] = u
+	}
+	s.users, s.modTime = users, fi.ModTime()
+	return nil
+}
+
+// refresh reloads the file if it changed, at most once a second.
+func (s *Store) refresh() {
+	if time.Since(s.checked) < time.Second {
+		return
+	}
+	s.checked = time.Now()
+	fi, err := os.Stat(s.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !s.modTime.IsZero():
+	case err != nil || fi.ModTime().Equal(s.modTime):
+		return
+	}
+	if err := s.load(); err != nil {
+		// keep serving the users we have
+		log.Printf("Reloading users from %s failed: %v", s.Path, err)
+	}
+}
+
+// save writes the file atomically. Callers hold s.mu.
+func (s *Store) save() error {
+	f := storeFile{Users: make([]User, 0, len(s.users))}
+	for _, u := range s.users {
+		f.Users = append(f.Users, u)
+	}
+	sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].Username < f.Users[j].Username })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(s.Path, append(data, '\n')); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.Path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Authenticate checks a username and password. Disabled and unknown users
+// fail like a wrong password.
+func (s *Store) Authenticate(username, password string) (User, error) {
+	u, ok := s.Get(username)
+	hash := dummyHash
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !ok || u.Disabled || err != nil {
+		return User{}, ErrBadCredentials
+	}
+	return u, nil
+}
+
+// Get returns a user.
+func (s *Store) Get(username string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	u, ok := s.users[username]
+	return u, ok
+}
+
+// List returns all users sorted by name.
+func (s *Store) List() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	out := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Username < out[j].Username })
+	return out
+}
+
+// Len returns the number of users.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	return len(s.users)
+}
+
+// Add creates a user.
+func (s *Store) Add(username string, role Role, password string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	if role == RoleNone {
+		return errors.New("a role is required")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Time{}
+	s.refresh()
+	if _, ok := s.users[username]; ok {
+		return ErrExists
+	}
+	now := time.Now().UTC()
+	s.users[username] = User{Username: username, Role: role, PasswordHash: hash, CreatedAt: now, UpdatedAt: now}
+	if err := s.save(); err != nil {
+		delete(s.users, username)
+		return err
+	}
+	return nil
+}
+
+// Update changes a user with fn and saves it. It refuses changes that
+// leave no enabled admin.
+func (s *Store) Update(username string, fn func(u *User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Time{}
+	s.refresh()
+	old, ok := s.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	u := old
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	u.Username = old.Username
+	u.UpdatedAt = time.Now().UTC()
+
+	s.users[username] = u
+	if !s.hasAdmin() && (old.Role == RoleAdmin && !old.Disabled) {
+		s.users[username] = old
+		return User{}, ErrLastAdmin
+	}
+	if err := s.save(); err != nil {
+		s.users[username] = old
+		return User{}, err
+	}
+	return u, nil
+}
+
+// SetPassword replaces a user's password.
+func (s *Store) SetPassword(username, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.Update(username, func(u *User) error {
+		u.PasswordHash = hash
+		return nil
+	})
+	return err
+}
+
+// Delete removes a user.
+func (s *Store) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Time{}
+	s.refresh()
+	old, ok := s.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.users, username)
+	if old.Role == RoleAdmin && !old.Disabled && !s.hasAdmin() {
+		s.users[username] = old
+		return ErrLastAdmin
+	}
+	if err := s.save(); err != nil {
+		s.users[username] = old
+		return err
+	}
+	return nil
+}
+
+func (s *Store) hasAdmin() bool {
+	for _, u := range s.users {
+		if u.Role == RoleAdmin && !u.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword checks the password length and returns its bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must have at least %d characters", MinPasswordLength)
+	}
+	if len(password) > 72 {
+		return "", errors.New("password must have at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
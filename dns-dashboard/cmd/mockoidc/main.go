@@ -0,0 +1,263 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying the
+// dashboard's single sign-on locally. It signs ID tokens with a key
+// generated at startup and lets whoever opens the login page pick a user
+// name and groups:
+//
+//	mockoidc -listen 127.0.0.1:9998 -client-id dns-dashboard
+//
+//	DASHBOARD_OIDC_ISSUER=http://127.0.0.1:9998
+//	DASHBOARD_OIDC_CLIENT_ID=dns-dashboard
+//	DASHBOARD_OIDC_REDIRECT_URL=http://127.0.0.1:8080/auth/oidc/callback
+//	DASHBOARD_OIDC_ADMIN_GROUPS=dns-admins
+//
+// It checks the client ID, redirect URI and PKCE verifier like a real
+// provider, but has no passwords. Never expose it.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	username    string
+	groups      []string
+	created     time.Time
+}
+
+type provider struct {
+	issuer   string
+	clientID string
+	secret   string
+	key      *rsa.PrivateKey
+	kid      string
+
+	mu     sync.Mutex
+	grants map[string]grant // by code
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9998", "Address to listen on")
+	issuer := flag.String("issuer", "", "Issuer URL (default http://LISTEN)")
+	clientID := flag.String("client-id", "dns-dashboard", "Accepted client ID")
+	secret := flag.String("client-secret", "", "Required client secret (empty accepts public clients)")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *listen
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &provider{
+		issuer:   strings.TrimSuffix(*issuer, "/"),
+		clientID: *clientID,
+		secret:   *secret,
+		key:      key,
+		kid:      randomString(8),
+		grants:   map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	log.Printf("Mock OIDC provider %s listening on %s", p.issuer, *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<title>Mock OIDC login</title>
+<h1>Mock OIDC login</h1>
+<p>Client <b>{{.ClientID}}</b> wants you to sign in.</p>
+<form method="post">
+<p><label>User name <input name="username" value="alice"></label></p>
+<p><label>Groups (comma-separated) <input name="groups" value="dns-admins"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>`))
+
+// authorize shows a form on GET and issues a code on POST. The request
+// parameters stay in the URL, so the form posts back to it.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	switch {
+	case q.Get("client_id") != p.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case err != nil || redirect.Scheme == "" || redirect.Host == "":
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		http.Error(w, "only response_type=code is supported", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		loginForm.Execute(w, map[string]string{"ClientID": p.clientID})
+		return
+	}
+
+	var groups []string
+	for _, g := range strings.Split(r.FormValue("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	code := randomString(24)
+	p.mu.Lock()
+	p.grants[code] = grant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		username:    r.FormValue("username"),
+		groups:      groups,
+		created:     time.Now(),
+	}
+	p.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.FormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	clientID := r.FormValue("client_id")
+	if id, secret, ok := r.BasicAuth(); ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if p.secret != "" && secret != p.secret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+		clientID = id
+	} else if p.secret != "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.FormValue("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	switch {
+	case !ok || time.Since(g.created) > time.Minute:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or expired code"})
+		return
+	case clientID != g.clientID || r.FormValue("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "client or redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := p.sign(map[string]any{
+		"iss":                p.issuer,
+		"sub":                "mock-" + g.username,
+		"aud":                g.clientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              g.nonce,
+		"preferred_username": g.username,
+		"email":              g.username + "@example.com",
+		"groups":             g.groups,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(24),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign makes an RS256 JWT.
+func (p *provider) sign(claims map[string]any) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.kid})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"dns-dashboard/auth"
+	"dns-dashboard/lists"
+)
+
+func authConfig() auth.Config {
+	return auth.Config{
+		UsersFile:     getEnv("DASHBOARD_USERS_FILE", "/etc/dns-dashboard/users.json"),
+		TokensFile:    getEnv("DASHBOARD_TOKENS_FILE", "/etc/dns-dashboard/tokens.json"),
+		AuditLog:      getEnv("DASHBOARD_AUDIT_LOG", "/var/log/dns-dashboard/audit.log"),
+		SessionTTL:    getDuration("DASHBOARD_SESSION_TTL", 12*time.Hour),
+		SessionIdle:   getDuration("DASHBOARD_SESSION_IDLE", time.Hour),
+		SecureCookie:  getEnv("DASHBOARD_COOKIE_SECURE", "") == "true",
+		PasswordLogin: getEnv("DASHBOARD_PASSWORD_LOGIN", "true") != "false",
+	}
+}
+
+// listsConfig reads where the dnsdist lists are and how to reach the
+// dnsdist console that reloads them; ok is false when DNSDIST_LISTS_DIR is
+// set empty. There is no default key: install.sh generates one per server.
+func listsConfig() (lists.Config, bool) {
+	cfg := lists.Config{
+		Dir:         getEnv("DNSDIST_LISTS_DIR", "/etc/dnsdist"),
+		ConsoleAddr: getEnv("DNSDIST_CONSOLE_ADDR", "127.0.0.1:5199"),
+		ConsoleKey:  getEnv("DNSDIST_CONSOLE_KEY", ""),
+	}
+	return cfg, cfg.Dir != ""
+}
+
+// oidcConfig reads the single sign-on settings; ok is false when
+// DASHBOARD_OIDC_ISSUER is not set.
+func oidcConfig() (auth.OIDCConfig, bool) {
+	cfg := auth.OIDCConfig{
+		Issuer:        getEnv("DASHBOARD_OIDC_ISSUER", ""),
+		ClientID:      getEnv("DASHBOARD_OIDC_CLIENT_ID", ""),
+		ClientSecret:  getEnv("DASHBOARD_OIDC_CLIENT_SECRET", ""),
+		RedirectURL:   getEnv("DASHBOARD_OIDC_REDIRECT_URL", ""),
+		Scopes:        strings.Fields(getEnv("DASHBOARD_OIDC_SCOPES", "openid profile email groups")),
+		UsernameClaim: getEnv("DASHBOARD_OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   getEnv("DASHBOARD_OIDC_GROUPS_CLAIM", "groups"),
+		RoleGroups: map[auth.Role][]string{
+			auth.RoleAdmin:   getList("DASHBOARD_OIDC_ADMIN_GROUPS"),
+			auth.RoleAnalyst: getList("DASHBOARD_OIDC_ANALYST_GROUPS"),
+			auth.RoleViewer:  getList("DASHBOARD_OIDC_VIEWER_GROUPS"),
+		},
+	}
+	if def := getEnv("DASHBOARD_OIDC_DEFAULT_ROLE", "none"); def != "none" {
+		role, err := auth.ParseRole(def)
+		if err != nil {
+			log.Fatalf("DASHBOARD_OIDC_DEFAULT_ROLE: %v", err)
+		}
+		cfg.DefaultRole = role
+	}
+	return cfg, cfg.Issuer != ""
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("%s: invalid duration %q", key, value)
+		}
+		return d
+	}
+	return fallback
+}
+
+// getList reads a comma-separated list.
+func getList(key string) []string {
+	var out []string
+	for _, s := range strings.Split(getEnv(key, ""), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+// Package dnsdist talks to the dnsdist console (controlSocket), which runs
+// Lua in the resolver without a restart.
+package dnsdist
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Console is a client for the encrypted dnsdist console. Key is the
+// setKey() value of dnsdist.conf.
+type Console struct {
+	Addr    string
+	Timeout time.Duration
+
+	key [32]byte
+}
+
+// NewConsole returns a console client for addr with the base64 key.
+// dnsdist hands its key to libsodium as is, so only the first 32 bytes of
+// a longer key count.
+func NewConsole(addr, key string) (*Console, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) < 32 {
+		return nil, errors.New("console key must be at least 32 bytes in base64, like setKey() in dnsdist.conf")
+	}
+	c := &Console{Addr: addr, Timeout: 5 * time.Second}
+	copy(c.key[:], raw)
+	return c, nil
+}
+
+// Exec runs one Lua command and returns what the console printed.
+//
+// The protocol: both sides send a 24-byte random nonce. Messages from the
+// client use the server's first and the client's second half, replies the
+// client's first and the server's second half; each message increments
+// the first four bytes as a big-endian counter. A message is a 32-bit
+// big-endian length and a NaCl secretbox.
+func (c *Console) Exec(cmd string) (string, error) {
+	conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	var ours, theirs [24]byte
+	if _, err := rand.Read(ours[:]); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(ours[:]); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, theirs[:]); err != nil {
+		return "", fmt.Errorf("console handshake: %w", err)
+	}
+	var reading, writing [24]byte
+	copy(reading[:12], ours[:12])
+	copy(reading[12:], theirs[12:])
+	copy(writing[:12], theirs[:12])
+	copy(writing[12:], ours[12:])
+
+	// An empty message first: a server with another key hangs up.
+	if _, err := c.roundTrip(conn, "", &reading, &writing); err != nil {
+		return "", fmt.Errorf("console rejected the connection, check the key: %w", err)
+	}
+	return c.roundTrip(conn, cmd, &reading, &writing)
+}
+
+func (c *Console) roundTrip(conn net.Conn, msg string, reading, writing *[24]byte) (string, error) {
+	sealed := secretbox.Seal(nil, []byte(msg), writing, &c.key)
+	increment(writing)
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err := conn.Write(append(buf, sealed...)); err != nil {
+		return "", err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 {
+		return "", nil
+	}
+	if n > 16<<20 {
+		return "", fmt.Errorf("console reply of %d bytes", n)
+	}
+	reply := make([]byte, n)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return "", err
+	}
+	out, ok := secretbox.Open(nil, reply, reading, &c.key)
+	increment(reading)
+	if !ok {
+		return "", errors.New("cannot decrypt console reply, check the key")
+	}
+	return string(out), nil
+}
+
+func increment(nonce *[24]byte) {
+	binary.BigEndian.PutUint32(nonce[:4], binary.BigEndian.Uint32(nonce[:4])+1)
+}
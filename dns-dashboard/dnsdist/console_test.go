@@ -0,0 +1,164 @@
+package dnsdist
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// fakeConsole is the server side of the dnsdist console protocol. It
+// answers each command with reply and records the commands it decrypted.
+type fakeConsole struct {
+	key   [32]byte
+	reply func(cmd string) string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func startFakeConsole(t *testing.T, key [32]byte, reply func(string) string) (*fakeConsole, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeConsole{key: key, reply: reply}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeConsole) serve(conn net.Conn) {
+	defer conn.Close()
+	var client, ours [24]byte
+	if _, err := io.ReadFull(conn, client[:]); err != nil {
+		return
+	}
+	rand.Read(ours[:])
+	if _, err := conn.Write(ours[:]); err != nil {
+		return
+	}
+	// The mirror image of Exec: the client writes with our first half.
+	var reading, writing [24]byte
+	copy(reading[:12], ours[:12])
+	copy(reading[12:], client[12:])
+	copy(writing[:12], client[:12])
+	copy(writing[12:], ours[12:])
+
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		sealed := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, sealed); err != nil {
+			return
+		}
+		cmd, ok := secretbox.Open(nil, sealed, &reading, &f.key)
+		increment(&reading)
+		if !ok {
+			return // dnsdist hangs up on a client with another key
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, string(cmd))
+		f.mu.Unlock()
+
+		out := secretbox.Seal(nil, []byte(f.reply(string(cmd))), &writing, &f.key)
+		increment(&writing)
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(out))), out...)); err != nil {
+			return
+		}
+	}
+}
+
+func testKey(seed byte, n int) (string, [32]byte) {
+	raw := make([]byte, n)
+	for i := range raw {
+		raw[i] = seed + byte(i)
+	}
+	var key [32]byte
+	copy(key[:], raw)
+	return base64.StdEncoding.EncodeToString(raw), key
+}
+
+func TestConsoleExec(t *testing.T) {
+	b64, key := testKey(1, 32)
+	f, addr := startFakeConsole(t, key, func(cmd string) string {
+		if cmd == "reloadLists()" {
+			return "lists reloaded: blocklist 3, allowlist 1, noiselist 0\n"
+		}
+		return ""
+	})
+	c, err := NewConsole(addr, b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every Exec is a connection of its own that sends an empty probe
+	// before the command, so the nonce counters advance on both sides.
+	for range 2 {
+		out, err := c.Exec("reloadLists()")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(out, "lists reloaded: blocklist 3") {
+			t.Errorf("Exec = %q", out)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if want := []string{"", "reloadLists()", "", "reloadLists()"}; strings.Join(f.commands, "|") != strings.Join(want, "|") {
+		t.Errorf("console received %q, want %q", f.commands, want)
+	}
+}
+
+func TestConsoleLongKey(t *testing.T) {
+	// dnsdist uses only the first 32 bytes of a longer key.
+	b64, key := testKey(7, 48)
+	_, addr := startFakeConsole(t, key, func(string) string { return "ok" })
+	c, err := NewConsole(addr, b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := c.Exec("showVersion()"); err != nil || out != "ok" {
+		t.Errorf("Exec = %q, %v", out, err)
+	}
+}
+
+func TestConsoleWrongKey(t *testing.T) {
+	_, key := testKey(1, 32)
+	f, addr := startFakeConsole(t, key, func(string) string { return "ok" })
+	other, _ := testKey(2, 32)
+	c, err := NewConsole(addr, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exec("reloadLists()"); err == nil || !strings.Contains(err.Error(), "check the key") {
+		t.Errorf("Exec with another key: %v, want a key error", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.commands) != 0 {
+		t.Errorf("console decrypted %q sent with another key", f.commands)
+	}
+
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 31))} {
+		if _, err := NewConsole(addr, bad); err == nil {
+			t.Errorf("NewConsole(%q) accepted the key", bad)
+		}
+	}
+}
@@ -1,19 +1,24 @@
 module dns-dashboard
 
-go 1.24
+// github.com/ClickHouse/ch-go v0.71.0 (via clickhouse-go) needs at least go 1.24.1
+go 1.24.1
 
 toolchain go1.24.12
 
+require (
+	github.com/ClickHouse/clickhouse-go/v2 v2.43.0
+	github.com/gofiber/fiber/v2 v2.52.11
+	github.com/gofiber/template/html/v2 v2.1.3
+	golang.org/x/crypto v0.47.0
+)
+
 require (
 	github.com/ClickHouse/ch-go v0.71.0 // indirect
-	github.com/ClickHouse/clickhouse-go/v2 v2.43.0 // indirect
 	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/go-faster/city v1.0.1 // indirect
 	github.com/go-faster/errors v0.7.1 // indirect
-	github.com/gofiber/fiber/v2 v2.52.11 // indirect
 	github.com/gofiber/template v1.8.3 // indirect
-	github.com/gofiber/template/html/v2 v2.1.3 // indirect
 	github.com/gofiber/utils v1.1.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/klauspost/compress v1.18.3 // indirect
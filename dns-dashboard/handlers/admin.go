@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+
+	"dns-dashboard/db"
+	"dns-dashboard/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ApiAdminStorage lists the MergeTree tables of the dashboard database with
+// their current TTL (set by the collector's migrate command from
+// clickhouse.retention) and disk usage per partition from system.parts.
+func ApiAdminStorage(c *fiber.Ctx) error {
+	rows, err := db.DB.Query(`
+		SELECT name, engine, engine_full, ifNull(total_rows, 0), ifNull(total_bytes, 0)
+		FROM system.tables
+		WHERE database = currentDatabase() AND engine LIKE '%MergeTree'
+		ORDER BY name
+	`)
+	if err != nil {
+		log.Printf("ApiAdminStorage tables query failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	defer rows.Close()
+
+	tables := []models.StorageTable{}
+	byName := map[string]int{}
+	for rows.Next() {
+		var t models.StorageTable
+		var engineFull string
+		if err := rows.Scan(&t.Table, &t.Engine, &engineFull, &t.Rows, &t.Bytes); err != nil {
+			log.Printf("ApiAdminStorage tables scan failed: %v", err)
+			continue
+		}
+		t.TTL = ttlClause(engineFull)
+		t.Partitions = []models.StoragePartition{}
+		byName[t.Table] = len(tables)
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ApiAdminStorage tables rows error: %v", err)
+	}
+
+	parts, err := db.DB.Query(`
+		SELECT table, partition, disk_name, count() as parts, sum(rows), sum(bytes_on_disk),
+			sum(data_uncompressed_bytes),
+			if(max(delete_ttl_info_max) = 0, '', toString(max(delete_ttl_info_max)))
+		FROM system.parts
+		WHERE database = currentDatabase() AND active
+		GROUP BY table, partition, disk_name
+		ORDER BY table, partition, disk_name
+	`)
+	if err != nil {
+		log.Printf("ApiAdminStorage parts query failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	defer parts.Close()
+
+	for parts.Next() {
+		var table string
+		var p models.StoragePartition
+		if err := parts.Scan(&table, &p.Partition, &p.Disk, &p.Parts, &p.Rows, &p.Bytes, &p.UncompressedBytes, &p.ExpiresAt); err != nil {
+			log.Printf("ApiAdminStorage parts scan failed: %v", err)
+			continue
+		}
+		if i, ok := byName[table]; ok {
+			tables[i].Partitions = append(tables[i].Partitions, p)
+		}
+	}
+	if err := parts.Err(); err != nil {
+		log.Printf("ApiAdminStorage parts rows error: %v", err)
+	}
+
+	return c.JSON(fiber.Map{"tables": tables})
+}
+
+// ttlClause extracts the TTL expression from system.tables.engine_full.
+func ttlClause(engine string) string {
+	_, ttl, ok := strings.Cut(engine, " TTL ")
+	if !ok {
+		return ""
+	}
+	ttl, _, _ = strings.Cut(ttl, " SETTINGS ")
+	return strings.TrimSpace(ttl)
+}
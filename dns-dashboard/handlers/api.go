@@ -16,19 +16,48 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// weightedCount counts rows scaled by sample_weight, so rows dropped by
+// collector sampling or folded by its rate limits still add up to the real
+// number of queries.
+const weightedCount = "toUInt64(round(sum(sample_weight)))"
+
+// rollupCount is weightedCount for the rollup tables (clickhouse/schema.sql),
+// whose queries column already holds sum(sample_weight).
+const rollupCount = "toUInt64(round(sum(queries)))"
+
+// topEntries expands the merged topKWeighted state of a dns_top_1h column
+// into rows t = (value, weight, error) with the weight in hundredths of a
+// query; topCount turns it back into a query count.
+const (
+	topEntries = "arrayJoin(topKWeightedMerge(100, 3, 'counts')(%s)) AS t"
+	topCount   = "toUInt64(round(t.2 / 100))"
+)
+
 func ApiStats(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
+	minFrom, minTo := tr.minuteBounds()
+	hourFrom, hourTo := tr.hourBounds()
 	stats := models.DashboardStats{}
 
-	// Optimized: Single query instead of 5 separate queries
-	err := db.DB.QueryRow(`
+	// Single round trip over the rollups; no raw dns_logs scan.
+	// range_queries and the uniques cover the requested range; qps and
+	// latency are always current. qps is the last complete minute.
+	// anonymized samples the modes of recent rows (a bounded read) so the
+	// page can flag pseudonymous data.
+	err = db.DB.QueryRow(`
 		SELECT 
-			countIf(response_type = 'CQ') as total_queries,
-			countIf(response_type = 'CQ' AND timestamp >= today()) as today_queries,
-			uniqIf(client_ip, response_type = 'CQ' AND timestamp >= today()) as unique_clients,
-			uniqIf(qname, response_type = 'CQ' AND timestamp >= today()) as unique_domains,
-			countIf(response_type = 'CQ' AND timestamp >= now() - INTERVAL 1 MINUTE) / 60.0 as qps
-		FROM dns_logs
-	`).Scan(&stats.TotalQueries, &stats.TodayQueries, &stats.UniqueClients, &stats.UniqueDomains, &stats.QPS)
+			(SELECT `+rollupCount+` FROM dns_stats_1m WHERE response_type = 'CQ') as total_queries,
+			(SELECT `+rollupCount+` FROM dns_stats_1m WHERE response_type = 'CQ' AND minute >= today()) as today_queries,
+			(SELECT `+rollupCount+` FROM dns_stats_1m WHERE response_type = 'CQ' AND minute >= toDateTime(?) AND minute <= toDateTime(?)) as range_queries,
+			(SELECT uniqMerge(clients) FROM dns_uniques_1h WHERE hour >= toDateTime(?) AND hour <= toDateTime(?)) as unique_clients,
+			(SELECT uniqMerge(domains) FROM dns_uniques_1h WHERE hour >= toDateTime(?) AND hour <= toDateTime(?)) as unique_domains,
+			(SELECT sum(queries) / 60.0 FROM dns_stats_1m WHERE response_type = 'CQ' AND minute = toStartOfMinute(now() - INTERVAL 1 MINUTE)) as qps,
+			(SELECT ifNotFinite(sum(latency_us_sum) / sum(latency_count) / 1000.0, 0) FROM dns_stats_1m WHERE response_type = 'CQ' AND minute >= toStartOfMinute(now() - INTERVAL 5 MINUTE)) as avg_latency,
+			(SELECT groupUniqArray(toString(anonymized)) FROM (SELECT anonymized FROM dns_logs WHERE timestamp >= now() - INTERVAL 1 HOUR LIMIT 10000)) as anonymized
+	`, minFrom, minTo, hourFrom, hourTo, hourFrom, hourTo).Scan(&stats.TotalQueries, &stats.TodayQueries, &stats.RangeQueries, &stats.UniqueClients, &stats.UniqueDomains, &stats.QPS, &stats.AvgLatency, &stats.Anonymized)
 	if err != nil {
 		log.Printf("ApiStats query failed: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
@@ -192,14 +221,19 @@ func ApiDnsdistStats(c *fiber.Ctx) error {
 }
 
 func ApiQueryTypes(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
+	from, to := tr.minuteBounds()
 	rows, err := db.DB.Query(`
-		SELECT qtype, count() as cnt 
-		FROM dns_logs 
-		WHERE response_type = 'CQ' AND timestamp >= today() 
+		SELECT qtype, `+rollupCount+` as cnt 
+		FROM dns_stats_1m 
+		WHERE response_type = 'CQ' AND minute >= toDateTime(?) AND minute <= toDateTime(?) 
 		GROUP BY qtype 
 		ORDER BY cnt DESC 
 		LIMIT 10
-	`)
+	`, from, to)
 	if err != nil {
 		log.Printf("ApiQueryTypes query failed: %v", err)
 		return c.JSON([]models.QueryTypeStats{})
@@ -224,13 +258,18 @@ func ApiQueryTypes(c *fiber.Ctx) error {
 }
 
 func ApiResponseCodes(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
+	from, to := tr.minuteBounds()
 	rows, err := db.DB.Query(`
-		SELECT rcode, count() as cnt 
-		FROM dns_logs 
-		WHERE response_type = 'CR' AND timestamp >= today() 
+		SELECT rcode, `+rollupCount+` as cnt 
+		FROM dns_stats_1m 
+		WHERE (response_type = 'CR' OR paired = 1) AND minute >= toDateTime(?) AND minute <= toDateTime(?) 
 		GROUP BY rcode 
 		ORDER BY cnt DESC
-	`)
+	`, from, to)
 	if err != nil {
 		log.Printf("ApiResponseCodes query failed: %v", err)
 		return c.JSON([]models.ResponseCodeStats{})
@@ -254,15 +293,21 @@ func ApiResponseCodes(c *fiber.Ctx) error {
 	return c.JSON(results)
 }
 
+// The top lists read the hourly rollups, so their range is widened to
+// whole hours.
 func ApiTopDomains(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
+	from, to := tr.hourBounds()
 	rows, err := db.DB.Query(`
-		SELECT qname, count() as cnt 
-		FROM dns_logs 
-		WHERE response_type = 'CQ' AND timestamp >= today() AND qname != ''
-		GROUP BY qname 
+		SELECT t.1 as qname, `+topCount+` as cnt 
+		FROM (SELECT `+fmt.Sprintf(topEntries, "domains")+` FROM dns_top_1h WHERE hour >= toDateTime(?) AND hour <= toDateTime(?))
+		WHERE qname != ''
 		ORDER BY cnt DESC 
 		LIMIT 20
-	`)
+	`, from, to)
 	if err != nil {
 		log.Printf("ApiTopDomains query failed: %v", err)
 		return c.JSON([]models.TopDomain{})
@@ -285,14 +330,17 @@ func ApiTopDomains(c *fiber.Ctx) error {
 }
 
 func ApiTopClients(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
+	from, to := tr.hourBounds()
 	rows, err := db.DB.Query(`
-		SELECT replaceOne(toString(client_ip), '::ffff:', '') as client_ip, count() as cnt 
-		FROM dns_logs 
-		WHERE response_type = 'CQ' AND timestamp >= today()
-		GROUP BY client_ip 
+		SELECT replaceOne(toString(t.1), '::ffff:', '') as client_ip, `+topCount+` as cnt 
+		FROM (SELECT `+fmt.Sprintf(topEntries, "clients")+` FROM dns_top_1h WHERE hour >= toDateTime(?) AND hour <= toDateTime(?))
 		ORDER BY cnt DESC 
 		LIMIT 20
-	`)
+	`, from, to)
 	if err != nil {
 		log.Printf("ApiTopClients query failed: %v", err)
 		return c.JSON([]models.TopClient{})
@@ -311,19 +359,27 @@ func ApiTopClients(c *fiber.Ctx) error {
 	if err := rows.Err(); err != nil {
 		log.Printf("ApiTopClients rows error: %v", err)
 	}
+	if !seesClients(c) {
+		results = maskTopClients(results)
+	}
 	return c.JSON(results)
 }
 
+// ApiRecentQueries returns the last queries before to.
 func ApiRecentQueries(c *fiber.Ctx) error {
+	tr, err := parseTimeRange(c, "today")
+	if err != nil {
+		return badRange(c, err)
+	}
 	rows, err := db.DB.Query(`
 		SELECT 
-			formatDateTime(timestamp, '%Y-%m-%d %H:%i:%S') as ts,
+			toString(toDateTime64(timestamp, 3)) as ts,
 			replaceOne(toString(client_ip), '::ffff:', '') as client_ip, qname, qtype, response_type 
 		FROM dns_logs 
-		WHERE response_type = 'CQ' 
+		WHERE response_type = 'CQ' AND timestamp >= fromUnixTimestamp64Milli(?) AND timestamp <= fromUnixTimestamp64Milli(?) 
 		ORDER BY timestamp DESC 
 		LIMIT 50
-	`)
+	`, tr.From.UnixMilli(), tr.To.UnixMilli())
 	if err != nil {
 		log.Printf("ApiRecentQueries query failed: %v", err)
 		return c.JSON([]models.RecentQuery{})
@@ -339,6 +395,9 @@ func ApiRecentQueries(c *fiber.Ctx) error {
 			continue
 		}
 		q.Type = qtypeToString(qtype)
+		if !seesClients(c) {
+			q.ClientIP = maskClient(q.ClientIP)
+		}
 		results = append(results, q)
 	}
 	if err := rows.Err(); err != nil {
@@ -347,16 +406,59 @@ func ApiRecentQueries(c *fiber.Ctx) error {
 	return c.JSON(results)
 }
 
+// maxTimelinePoints caps the buckets of one timeline.
+const maxTimelinePoints = 3600
+
+// ApiTimeline counts queries per bucket over from..to (default the last
+// hour). Whole-minute buckets come from the per-minute rollup; finer ones
+// need the raw rows, so parseBucket limits their range.
 func ApiTimeline(c *fiber.Ctx) error {
-	rows, err := db.DB.Query(`
+	tr, err := parseTimeRange(c, "-1h")
+	if err != nil {
+		return badRange(c, err)
+	}
+	if err := tr.parseBucket(c, maxTimelinePoints); err != nil {
+		return badRange(c, err)
+	}
+	step := tr.Bucket
+
+	labelFormat := "15:04"
+	switch {
+	case step >= 24*time.Hour:
+		labelFormat = "2006-01-02"
+	case tr.To.Sub(tr.From) > 24*time.Hour:
+		labelFormat = "01-02 15:04"
+	case step < time.Second:
+		labelFormat = "15:04:05.000"
+	case step < time.Minute:
+		labelFormat = "15:04:05"
+	}
+
+	query := fmt.Sprintf(`
 		SELECT 
-			toStartOfMinute(timestamp) as minute,
-			count() as cnt
+			toStartOfInterval(toDateTime64(timestamp, 3), INTERVAL %d MILLISECOND) as bucket,
+			`+weightedCount+` as cnt
 		FROM dns_logs 
-		WHERE response_type = 'CQ' AND timestamp >= now() - INTERVAL 1 HOUR
-		GROUP BY minute
-		ORDER BY minute
-	`)
+		WHERE response_type = 'CQ' AND timestamp >= fromUnixTimestamp64Milli(?) AND timestamp <= fromUnixTimestamp64Milli(?)
+		GROUP BY bucket
+		ORDER BY bucket
+	`, step.Milliseconds())
+	args := []any{tr.From.UnixMilli(), tr.To.UnixMilli()}
+	if step%time.Minute == 0 {
+		query = fmt.Sprintf(`
+		SELECT 
+			toStartOfInterval(minute, INTERVAL %s) as bucket,
+			`+rollupCount+` as cnt
+		FROM dns_stats_1m 
+		WHERE response_type = 'CQ' AND minute >= toDateTime(?) AND minute <= toDateTime(?)
+		GROUP BY bucket
+		ORDER BY bucket
+	`, rollupInterval(step))
+		from, to := tr.minuteBounds()
+		args = []any{from, to}
+	}
+
+	rows, err := db.DB.Query(query, args...)
 	if err != nil {
 		log.Printf("ApiTimeline query failed: %v", err)
 		return c.JSON([]map[string]interface{}{})
@@ -365,14 +467,15 @@ func ApiTimeline(c *fiber.Ctx) error {
 
 	var results []map[string]interface{}
 	for rows.Next() {
-		var minute time.Time
+		var bucket time.Time
 		var count int64
-		if err := rows.Scan(&minute, &count); err != nil {
+		if err := rows.Scan(&bucket, &count); err != nil {
 			log.Printf("ApiTimeline scan failed: %v", err)
 			continue
 		}
 		results = append(results, map[string]interface{}{
-			"time":  minute.Format("15:04"),
+			"time":  bucket.Local().Format(labelFormat),
+			"ts":    bucket.UnixMilli(),
 			"count": count,
 		})
 	}
@@ -382,6 +485,18 @@ func ApiTimeline(c *fiber.Ctx) error {
 	return c.JSON(results)
 }
 
+// rollupInterval writes a whole-minute bucket as a ClickHouse interval in
+// its largest exact unit, so day and hour buckets align to the calendar.
+func rollupInterval(step time.Duration) string {
+	switch {
+	case step%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d DAY", step/(24*time.Hour))
+	case step%time.Hour == 0:
+		return fmt.Sprintf("%d HOUR", step/time.Hour)
+	}
+	return fmt.Sprintf("%d MINUTE", step/time.Minute)
+}
+
 func ApiLogs(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
 	limit := c.QueryInt("limit", 50)
@@ -397,10 +512,16 @@ func ApiLogs(c *fiber.Ctx) error {
 
 	clientIP := strings.TrimSpace(c.Query("client_ip"))
 	domain := strings.TrimSpace(c.Query("domain"))
+	answer := strings.TrimSpace(c.Query("answer"))
+	malformed := c.QueryBool("malformed")
+	ecs := strings.TrimSpace(c.Query("ecs"))
+	dnssecOK := c.QueryBool("dnssec_ok")
+	flags := strings.ToUpper(strings.TrimSpace(c.Query("flags")))
+	opcode := strings.ToUpper(strings.TrimSpace(c.Query("opcode")))
+	protocol := strings.ToUpper(strings.TrimSpace(c.Query("protocol")))
+	identity := strings.TrimSpace(c.Query("identity"))
 	qtype := strings.TrimSpace(c.Query("type"))
 	responseType := strings.ToUpper(strings.TrimSpace(c.Query("response_type")))
-	from := strings.TrimSpace(c.Query("from"))
-	to := strings.TrimSpace(c.Query("to"))
 	order := strings.ToLower(strings.TrimSpace(c.Query("order", "desc")))
 	if order != "asc" {
 		order = "desc"
@@ -408,6 +529,19 @@ func ApiLogs(c *fiber.Ctx) error {
 
 	offset := (page - 1) * limit
 
+	// Searching for a client needs the analyst role and is audited.
+	if clientIP != "" || ecs != "" {
+		if !seesClients(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "searching by client needs the analyst role"})
+		}
+		if clientIP != "" {
+			auditClientLookup(c, "client_ip", clientIP)
+		}
+		if ecs != "" {
+			auditClientLookup(c, "ecs", ecs)
+		}
+	}
+
 	where := " WHERE 1=1"
 	args := []interface{}{}
 
@@ -420,6 +554,60 @@ func ApiLogs(c *fiber.Ctx) error {
 		where += " AND qname LIKE ?"
 		args = append(args, "%"+domain+"%")
 	}
+	if answer != "" {
+		// Exact rdata match ("who resolved to this IP"), uses the bloom filter index
+		where += " AND has(answer_data, ?)"
+		args = append(args, answer)
+	}
+	if malformed {
+		where += " AND parse_error != ''"
+	}
+	if ecs != "" {
+		where += " AND ecs_subnet = ?"
+		args = append(args, ecs)
+	}
+	if dnssecOK {
+		where += " AND edns_do"
+	}
+	if flags != "" {
+		// Comma-separated list, e.g. "TC" or "-RD,CD": plain names must be
+		// set, names prefixed with "-" must be clear.
+		var set, clear uint16
+		for _, name := range strings.Split(flags, ",") {
+			name = strings.TrimSpace(name)
+			negate := strings.HasPrefix(name, "-")
+			bit, ok := headerFlagBits[strings.TrimPrefix(name, "-")]
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid flag"})
+			}
+			if negate {
+				clear |= bit
+			} else {
+				set |= bit
+			}
+		}
+		where += " AND bitAnd(flags, ?) = ?"
+		args = append(args, set|clear, set)
+	}
+	if opcode != "" {
+		op, ok := parseOpcode(opcode)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid opcode"})
+		}
+		where += " AND opcode = ?"
+		args = append(args, op)
+	}
+	if protocol != "" {
+		if !validProtocols[protocol] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid protocol"})
+		}
+		where += " AND socket_protocol = ?"
+		args = append(args, protocol)
+	}
+	if identity != "" {
+		where += " AND server_identity = ?"
+		args = append(args, identity)
+	}
 	if qtype != "" {
 		qt, ok := parseQType(qtype)
 		if !ok {
@@ -435,19 +623,26 @@ func ApiLogs(c *fiber.Ctx) error {
 		where += " AND response_type = ?"
 		args = append(args, responseType)
 	}
-	if from != "" {
-		where += " AND timestamp >= parseDateTimeBestEffort(?)"
-		args = append(args, from)
+	// Same range rules as the other endpoints, but without from the list
+	// reaches back to the oldest row.
+	tr, err := parseTimeRange(c, "")
+	if err != nil {
+		return badRange(c, err)
 	}
-	if to != "" {
-		where += " AND timestamp <= parseDateTimeBestEffort(?)"
-		args = append(args, to)
+	if !tr.From.IsZero() {
+		where += " AND timestamp >= fromUnixTimestamp64Milli(?)"
+		args = append(args, tr.From.UnixMilli())
 	}
+	where += " AND timestamp <= fromUnixTimestamp64Milli(?)"
+	args = append(args, tr.To.UnixMilli())
 
 	query := `
 		SELECT 
-			formatDateTime(timestamp, '%Y-%m-%d %H:%i:%S') as ts,
-			replaceOne(toString(client_ip), '::ffff:', '') as ip, qname, qtype, response_type, response_size
+			toString(toDateTime64(timestamp, 3)) as ts,
+			replaceOne(toString(client_ip), '::ffff:', '') as ip, qname, qtype, response_type, response_size, rcode, opcode, flags, latency_us, parse_error, sample_weight, toString(anonymized),
+			socket_protocol, query_port, server_identity,
+			edns_present, edns_udp_size, edns_do, ecs_subnet,
+			answer_types, answer_ttls, answer_data
 		FROM dns_logs
 	` + where + fmt.Sprintf(" ORDER BY timestamp %s LIMIT %d OFFSET %d", order, limit, offset)
 
@@ -463,10 +658,30 @@ func ApiLogs(c *fiber.Ctx) error {
 		var ts, ip, qname, rtype string
 		var qtype uint16
 		var size int
-		if err := rows.Scan(&ts, &ip, &qname, &qtype, &rtype, &size); err != nil {
+		var rcode, opcodeVal uint8
+		var flagBits uint16
+		var latencyUs uint32
+		var parseError string
+		var sampleWeight float32
+		var anonymized string
+		var socketProtocol, serverIdentity string
+		var queryPort uint16
+		var ednsPresent, ednsDO bool
+		var ednsUDPSize uint16
+		var ecsSubnet string
+		var answerTypes []uint16
+		var answerTTLs []uint32
+		var answerData []string
+		if err := rows.Scan(&ts, &ip, &qname, &qtype, &rtype, &size, &rcode, &opcodeVal, &flagBits, &latencyUs, &parseError, &sampleWeight, &anonymized,
+			&socketProtocol, &queryPort, &serverIdentity,
+			&ednsPresent, &ednsUDPSize, &ednsDO, &ecsSubnet,
+			&answerTypes, &answerTTLs, &answerData); err != nil {
 			log.Printf("ApiLogs scan failed: %v", err)
 			continue
 		}
+		if !seesClients(c) {
+			ip, ecsSubnet, queryPort = maskClient(ip), maskClient(ecsSubnet), 0
+		}
 		results = append(results, map[string]interface{}{
 			"timestamp":     ts,
 			"client_ip":     ip,
@@ -474,6 +689,21 @@ func ApiLogs(c *fiber.Ctx) error {
 			"type":          qtypeToString(qtype),
 			"response_type": rtype,
 			"size":          size,
+			"rcode":         rcodeToString(rcode),
+			"opcode":        opcodeToString(opcodeVal),
+			"flags":         flagsToStrings(flagBits),
+			"latency_ms":    float64(latencyUs) / 1000,
+			"answers":       formatAnswers(answerTypes, answerTTLs, answerData),
+			"parse_error":   parseError,
+			"sample_weight": sampleWeight,
+			"anonymized":    anonymized,
+			"protocol":      socketProtocol,
+			"client_port":   queryPort,
+			"server":        serverIdentity,
+			"edns":          ednsPresent,
+			"edns_udp_size": ednsUDPSize,
+			"edns_do":       ednsDO,
+			"ecs_subnet":    ecsSubnet,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -494,6 +724,18 @@ func ApiLogs(c *fiber.Ctx) error {
 	})
 }
 
+// formatAnswers renders answer records as "TYPE TTL RDATA" strings.
+func formatAnswers(types []uint16, ttls []uint32, data []string) []string {
+	answers := make([]string, 0, len(data))
+	for i := range data {
+		if i >= len(types) || i >= len(ttls) {
+			break
+		}
+		answers = append(answers, fmt.Sprintf("%s %d %s", qtypeToString(types[i]), ttls[i], data[i]))
+	}
+	return answers
+}
+
 func qtypeToString(qtype uint16) string {
 	if name, ok := qtypeNameByValue[qtype]; ok {
 		return name
@@ -522,6 +764,71 @@ func parseQType(input string) (uint16, bool) {
 	return 0, false
 }
 
+// headerFlagBits maps flag names to their bit in the stored flags column
+// (same layout as the DNS header word).
+var headerFlagBits = map[string]uint16{
+	"QR": 1 << 15,
+	"AA": 1 << 10,
+	"TC": 1 << 9,
+	"RD": 1 << 8,
+	"RA": 1 << 7,
+	"AD": 1 << 5,
+	"CD": 1 << 4,
+}
+
+// headerFlagOrder is the presentation order used by dig.
+var headerFlagOrder = []string{"QR", "AA", "TC", "RD", "RA", "AD", "CD"}
+
+func flagsToStrings(flags uint16) []string {
+	names := []string{}
+	for _, name := range headerFlagOrder {
+		if flags&headerFlagBits[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var opcodeNameByValue = map[uint8]string{
+	0: "QUERY",
+	1: "IQUERY",
+	2: "STATUS",
+	4: "NOTIFY",
+	5: "UPDATE",
+	6: "DSO",
+}
+
+func opcodeToString(opcode uint8) string {
+	if name, ok := opcodeNameByValue[opcode]; ok {
+		return name
+	}
+	return strconv.Itoa(int(opcode))
+}
+
+func parseOpcode(input string) (uint8, bool) {
+	if n, err := strconv.Atoi(input); err == nil && n >= 0 && n <= 15 {
+		return uint8(n), true
+	}
+	for v, name := range opcodeNameByValue {
+		if name == input {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// validProtocols are the dnstap SocketProtocol names accepted by the
+// protocol filter.
+var validProtocols = map[string]bool{
+	"UDP":         true,
+	"TCP":         true,
+	"DOT":         true,
+	"DOH":         true,
+	"DOQ":         true,
+	"DNSCRYPTUDP": true,
+	"DNSCRYPTTCP": true,
+}
+
 var qtypeNameByValue = map[uint16]string{
 	1:   "A",
 	2:   "NS",
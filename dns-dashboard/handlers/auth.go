@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dns-dashboard/auth"
+	"dns-dashboard/lists"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// page returns the template data every page gets: the title and the
+// logged-in user, role and CSRF token for the header and forms.
+func page(c *fiber.Ctx, title string) fiber.Map {
+	data := fiber.Map{"Title": title}
+	if p := auth.Current(c); p != nil {
+		data["User"] = p.Username
+		data["Role"] = p.Role.String()
+		data["CSRF"] = p.CSRF
+		data["CanSeeClients"] = p.Can(auth.RoleAnalyst)
+		data["IsAdmin"] = p.Can(auth.RoleAdmin)
+		data["CanEditLists"] = p.Can(auth.RoleAdmin) && lists.Console != nil
+	}
+	return data
+}
+
+// loginPage renders the login form, with a single sign-on button when
+// OIDC is configured.
+func loginPage(c *fiber.Ctx, status int, next, username, errMsg string) error {
+	return c.Status(status).Render("login", fiber.Map{
+		"Title":         "Sign in",
+		"Next":          next,
+		"Username":      username,
+		"Error":         errMsg,
+		"SSO":           auth.OIDC != nil,
+		"PasswordLogin": auth.PasswordLogin,
+	})
+}
+
+func LoginPage(c *fiber.Ctx) error {
+	return loginPage(c, fiber.StatusOK, auth.SafeRedirect(c.Query("next")), "", "")
+}
+
+func Login(c *fiber.Ctx) error {
+	next := auth.SafeRedirect(c.FormValue("next"))
+	username := strings.TrimSpace(c.FormValue("username"))
+	if err := auth.Login(c, username, c.FormValue("password")); err != nil {
+		status := fiber.StatusUnauthorized
+		if !errors.Is(err, auth.ErrBadCredentials) {
+			status = fiber.StatusForbidden
+		}
+		return loginPage(c, status, next, username, err.Error())
+	}
+	return c.Redirect(next, fiber.StatusSeeOther)
+}
+
+// OIDCLogin sends the browser to the identity provider.
+func OIDCLogin(c *fiber.Ctx) error {
+	to, err := auth.OIDC.Start(c, c.Query("next"))
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		return loginPage(c, fiber.StatusBadGateway, auth.SafeRedirect(c.Query("next")), "", "Single sign-on is unavailable: "+err.Error())
+	}
+	return c.Redirect(to, fiber.StatusSeeOther)
+}
+
+// OIDCCallback is the redirect URL registered with the provider.
+func OIDCCallback(c *fiber.Ctx) error {
+	next, err := auth.OIDC.Finish(c)
+	if err != nil {
+		log.Printf("OIDC callback failed: %v", err)
+		return loginPage(c, fiber.StatusForbidden, "/", "", "Single sign-on failed: "+err.Error())
+	}
+	return c.Redirect(next, fiber.StatusSeeOther)
+}
+
+func Logout(c *fiber.Ctx) error {
+	auth.Logout(c)
+	return c.Redirect("/login", fiber.StatusSeeOther)
+}
+
+// ApiMe returns the caller's name and role.
+func ApiMe(c *fiber.Ctx) error {
+	p := auth.Current(c)
+	return c.JSON(fiber.Map{"username": p.Username, "role": p.Role.String(), "method": p.Method})
+}
+
+// ApiChangePassword lets a user change their own password:
+// {"current": "...", "new": "..."}. Like any password change it ends the
+// user's sessions, this one included.
+func ApiChangePassword(c *fiber.Ctx) error {
+	var req struct {
+		Current string `json:"current"`
+		New     string `json:"new"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	p := auth.Current(c)
+	switch p.Method {
+	case "oidc":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "single sign-on users change their password at the identity provider"})
+	case "token":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "API tokens have no password"})
+	}
+	if _, err := auth.Users.Authenticate(p.Username, req.Current); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "current password is wrong"})
+	}
+	if err := auth.Users.SetPassword(p.Username, req.New); err != nil {
+		return userError(c, err)
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionPassword})
+	return c.JSON(fiber.Map{"ok": true})
+}
+
+// userView is a user as the admin API shows it, without the hash.
+type userView struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Disabled  bool   `json:"disabled"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newUserView(u auth.User) userView {
+	return userView{
+		Username:  u.Username,
+		Role:      u.Role.String(),
+		Disabled:  u.Disabled,
+		CreatedAt: u.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: u.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// ApiAdminUsers lists the dashboard users.
+func ApiAdminUsers(c *fiber.Ctx) error {
+	users := []userView{}
+	for _, u := range auth.Users.List() {
+		users = append(users, newUserView(u))
+	}
+	return c.JSON(fiber.Map{"users": users})
+}
+
+// ApiAdminCreateUser creates a user from {"username", "role", "password"}.
+// Without a password a random one is generated and returned once.
+func ApiAdminCreateUser(c *fiber.Ctx) error {
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	generated := req.Password == ""
+	if generated {
+		req.Password = auth.RandomPassword()
+	}
+	if err := auth.Users.Add(req.Username, role, req.Password); err != nil {
+		return userError(c, err)
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionUserCreate, Detail: map[string]string{"username": req.Username, "role": role.String()}})
+
+	u, _ := auth.Users.Get(req.Username)
+	resp := fiber.Map{"user": newUserView(u)}
+	if generated {
+		resp["password"] = req.Password
+	}
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// ApiAdminUpdateUser changes the role, enabled state or password of
+// :username. Disabling a user or changing their password ends their
+// sessions; a new role applies to their next request.
+func ApiAdminUpdateUser(c *fiber.Ctx) error {
+	var req struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+		Password *string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	username := c.Params("username")
+
+	var hash string
+	if req.Password != nil {
+		var err error
+		if hash, err = auth.HashPassword(*req.Password); err != nil {
+			return userError(c, err)
+		}
+	}
+	detail := map[string]string{"username": username}
+	u, err := auth.Users.Update(username, func(u *auth.User) error {
+		if req.Role != nil {
+			role, err := auth.ParseRole(*req.Role)
+			if err != nil {
+				return err
+			}
+			u.Role = role
+			detail["role"] = role.String()
+		}
+		if req.Disabled != nil {
+			u.Disabled = *req.Disabled
+			detail["disabled"] = strconv.FormatBool(u.Disabled)
+		}
+		if hash != "" {
+			u.PasswordHash = hash
+			detail["password"] = "changed"
+		}
+		return nil
+	})
+	if err != nil {
+		return userError(c, err)
+	}
+	if u.Disabled {
+		auth.Sessions.DeleteUser(username)
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionUserUpdate, Detail: detail})
+	return c.JSON(fiber.Map{"user": newUserView(u)})
+}
+
+// ApiAdminDeleteUser deletes :username and ends their sessions.
+func ApiAdminDeleteUser(c *fiber.Ctx) error {
+	username := c.Params("username")
+	if err := auth.Users.Delete(username); err != nil {
+		return userError(c, err)
+	}
+	auth.Sessions.DeleteUser(username)
+	auth.Record(c, auth.Event{Action: auth.ActionUserDelete, Detail: map[string]string{"username": username}})
+	return c.JSON(fiber.Map{"ok": true})
+}
+
+// ApiAdminAudit returns the newest audit events, optionally filtered by
+// ?user=, ?action= and ?client=; ?limit= defaults to 200.
+func ApiAdminAudit(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 200)
+	if limit <= 0 || limit > 5000 {
+		limit = 200
+	}
+	user, action, client := c.Query("user"), c.Query("action"), c.Query("client")
+	events, err := auth.Audit.Tail(limit, func(ev auth.Event) bool {
+		return (user == "" || ev.User == user) &&
+			(action == "" || ev.Action == action) &&
+			(client == "" || strings.Contains(ev.Client, client))
+	})
+	if err != nil {
+		log.Printf("ApiAdminAudit read failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot read audit log"})
+	}
+	return c.JSON(fiber.Map{"events": events})
+}
+
+// tokenView is an API token as the admin API shows it, without the hash.
+type tokenView struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	RateLimit    int      `json:"rate_limit"`
+	Active       bool     `json:"active"`
+	CreatedBy    string   `json:"created_by"`
+	CreatedAt    string   `json:"created_at"`
+	ExpiresAt    string   `json:"expires_at,omitempty"`
+	RevokedAt    string   `json:"revoked_at,omitempty"`
+	LastUsedAt   string   `json:"last_used_at,omitempty"`
+	LastUsedFrom string   `json:"last_used_from,omitempty"`
+}
+
+func newTokenView(t auth.Token) tokenView {
+	format := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return tokenView{
+		ID:           t.ID,
+		Name:         t.Name,
+		Scopes:       t.Scopes,
+		RateLimit:    t.RateLimit,
+		Active:       t.Active(time.Now()),
+		CreatedBy:    t.CreatedBy,
+		CreatedAt:    format(&t.CreatedAt),
+		ExpiresAt:    format(t.ExpiresAt),
+		RevokedAt:    format(t.RevokedAt),
+		LastUsedAt:   format(t.LastUsedAt),
+		LastUsedFrom: t.LastUsedFrom,
+	}
+}
+
+// ApiAdminTokens lists the API tokens, revoked ones included.
+func ApiAdminTokens(c *fiber.Ctx) error {
+	tokens := []tokenView{}
+	for _, t := range auth.Tokens.List() {
+		tokens = append(tokens, newTokenView(t))
+	}
+	return c.JSON(fiber.Map{"tokens": tokens})
+}
+
+// ApiAdminCreateToken creates an API token from {"name", "scopes",
+// "rate_limit", "expires_in"}. The token is returned once; only its hash
+// is kept.
+func ApiAdminCreateToken(c *fiber.Ctx) error {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rate_limit"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = parseSpan(req.ExpiresIn); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in: " + err.Error()})
+		}
+	}
+	t, secret, err := auth.Tokens.Create(req.Name, req.Scopes, req.RateLimit, ttl, auth.Current(c).Username)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionTokenCreate, Detail: map[string]string{
+		"token": t.ID, "name": t.Name, "scopes": strings.Join(t.Scopes, ","),
+	}})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"token": newTokenView(t), "secret": secret})
+}
+
+// ApiAdminRevokeToken revokes the token :id. It stops working at once but
+// stays listed.
+func ApiAdminRevokeToken(c *fiber.Ctx) error {
+	t, err := auth.Tokens.Revoke(c.Params("id"))
+	if errors.Is(err, auth.ErrNoToken) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		log.Printf("ApiAdminRevokeToken failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot save tokens"})
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionTokenRevoke, Detail: map[string]string{"token": t.ID, "name": t.Name}})
+	return c.JSON(fiber.Map{"token": newTokenView(t)})
+}
+
+func userError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, auth.ErrExists), errors.Is(err, auth.ErrLastAdmin):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+}
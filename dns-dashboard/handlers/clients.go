@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/netip"
+	"sort"
+
+	"dns-dashboard/auth"
+	"dns-dashboard/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Viewers see client addresses and ECS subnets cut to these prefixes;
+// analysts and admins see them as stored.
+const (
+	viewerIPv4Bits = 24
+	viewerIPv6Bits = 48
+)
+
+// seesClients reports whether the caller may see raw client addresses.
+func seesClients(c *fiber.Ctx) bool {
+	return auth.Can(c, auth.RoleAnalyst)
+}
+
+// maskClient cuts an address or prefix to the viewer prefix lengths.
+// Anything unparseable is hidden.
+func maskClient(s string) string {
+	if s == "" {
+		return s
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		bits := min(p.Bits(), viewerBits(p.Addr()))
+		return netip.PrefixFrom(p.Addr(), bits).Masked().String()
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "hidden"
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, viewerBits(addr)).Masked().String()
+}
+
+func viewerBits(addr netip.Addr) int {
+	if addr.Is4() || addr.Is4In6() {
+		return viewerIPv4Bits
+	}
+	return viewerIPv6Bits
+}
+
+// maskTopClients masks the list for viewers, merging clients that fall
+// into the same prefix.
+func maskTopClients(clients []models.TopClient) []models.TopClient {
+	byPrefix := map[string]int{}
+	var out []models.TopClient
+	for _, cl := range clients {
+		cl.IP = maskClient(cl.IP)
+		if i, ok := byPrefix[cl.IP]; ok {
+			out[i].Count += cl.Count
+			continue
+		}
+		byPrefix[cl.IP] = len(out)
+		out = append(out, cl)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out
+}
+
+// auditClientLookup records a search for a client in the audit log.
+func auditClientLookup(c *fiber.Ctx, filter, value string) {
+	auth.Record(c, auth.Event{
+		Action: auth.ActionClientLookup,
+		Client: value,
+		Detail: map[string]string{"filter": filter, "path": c.Path()},
+	})
+}
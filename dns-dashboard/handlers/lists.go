@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"dns-dashboard/auth"
+	"dns-dashboard/lists"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ApiAdminLists returns the editable dnsdist lists with their entry counts.
+func ApiAdminLists(c *fiber.Ctx) error {
+	out := []fiber.Map{}
+	for _, name := range lists.Names {
+		l, err := lists.Load(name)
+		if err != nil {
+			log.Printf("ApiAdminLists %s failed: %v", name, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot read " + name})
+		}
+		out = append(out, fiber.Map{"name": l.Name, "path": l.Path, "entries": len(l.Entries)})
+	}
+	return c.JSON(fiber.Map{"lists": out})
+}
+
+// ApiAdminList returns the entries of list :name, filtered by ?q= on the
+// domain, comment and section.
+func ApiAdminList(c *fiber.Ctx) error {
+	l, err := lists.Load(c.Params("name"))
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(fiber.Map{"name": l.Name, "path": l.Path, "total": len(l.Entries), "entries": l.Search(c.Query("q"))})
+}
+
+// ApiAdminListAdd adds {"domain", "comment"} to list :name and applies the
+// lists to dnsdist.
+func ApiAdminListAdd(c *fiber.Ctx) error {
+	var req struct {
+		Domain  string `json:"domain"`
+		Comment string `json:"comment"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	name := c.Params("name")
+	e, err := lists.Add(name, req.Domain, req.Comment)
+	if err != nil {
+		return listError(c, err)
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionListAdd, Detail: map[string]string{"list": name, "domain": e.Domain, "comment": e.Comment}})
+
+	resp := fiber.Map{"entry": e}
+	applyLists(resp)
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// ApiAdminListRemove removes ?domain= from list :name and applies the lists
+// to dnsdist.
+func ApiAdminListRemove(c *fiber.Ctx) error {
+	name := c.Params("name")
+	removed, err := lists.Remove(name, c.Query("domain"))
+	if err != nil {
+		return listError(c, err)
+	}
+	for _, e := range removed {
+		auth.Record(c, auth.Event{Action: auth.ActionListRemove, Detail: map[string]string{"list": name, "domain": e.Domain, "comment": e.Comment}})
+	}
+
+	resp := fiber.Map{"removed": removed}
+	applyLists(resp)
+	return c.JSON(resp)
+}
+
+// ApiAdminListsApply makes dnsdist reload the lists, e.g. after editing
+// the files by hand or when applying a change failed.
+func ApiAdminListsApply(c *fiber.Ctx) error {
+	out, err := lists.Apply()
+	if err != nil {
+		log.Printf("Applying lists failed: %v", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+	auth.Record(c, auth.Event{Action: auth.ActionListApply, Detail: map[string]string{"dnsdist": out}})
+	return c.JSON(fiber.Map{"applied": true, "dnsdist": out})
+}
+
+// ApiAdminListsHistory returns the newest list changes from the audit log,
+// optionally for one ?list= (applies are always included); ?limit=
+// defaults to 100. Without an audit log file there is no history, which
+// "kept": false tells apart from a list nobody changed.
+func ApiAdminListsHistory(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	name := c.Query("list")
+	events, err := auth.Audit.Tail(limit, func(ev auth.Event) bool {
+		return strings.HasPrefix(ev.Action, "list_") &&
+			(name == "" || ev.Detail["list"] == name || ev.Action == auth.ActionListApply)
+	})
+	if err != nil {
+		log.Printf("ApiAdminListsHistory read failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot read audit log"})
+	}
+	return c.JSON(fiber.Map{"events": events, "kept": auth.Audit.Kept()})
+}
+
+// applyLists applies a saved change to dnsdist. A failure does not undo
+// the change; it is reported so it can be applied again later.
+func applyLists(resp fiber.Map) {
+	out, err := lists.Apply()
+	if err != nil {
+		log.Printf("Applying lists failed: %v", err)
+		resp["applied"] = false
+		resp["apply_error"] = err.Error()
+		return
+	}
+	resp["applied"] = true
+	resp["dnsdist"] = out
+}
+
+func listError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, lists.ErrUnknownList), errors.Is(err, lists.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, lists.ErrExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.As(err, new(*fs.PathError)), errors.As(err, new(*os.LinkError)):
+		log.Printf("List file error: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "cannot read or write the list file"})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+}
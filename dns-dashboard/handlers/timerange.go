@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Time range limits shared by the dashboard endpoints.
+const (
+	maxRange       = 400 * 24 * time.Hour // about the rollup retention
+	maxRawRange    = 6 * time.Hour        // sub-minute timelines scan dns_logs
+	minBucket      = 10 * time.Millisecond
+	maxBucket      = 24 * time.Hour
+	autoBucketGoal = 240 // points an automatic bucket aims for at most
+)
+
+// autoBuckets are the bucket sizes picked automatically, smallest first.
+var autoBuckets = []time.Duration{
+	10 * time.Millisecond, 100 * time.Millisecond, time.Second, 5 * time.Second,
+	10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 10 * time.Minute,
+	15 * time.Minute, 30 * time.Minute, time.Hour, 3 * time.Hour, 6 * time.Hour,
+	12 * time.Hour, 24 * time.Hour,
+}
+
+// timeRange is the window of a request: ?from= and ?to= (see parseTime),
+// plus ?bucket= for timelines.
+type timeRange struct {
+	From, To    time.Time
+	Bucket      time.Duration
+	defaultFrom bool // from was not given
+}
+
+// parseTimeRange reads from and to. defaultFrom applies when from is
+// missing; with an empty defaultFrom the range is open at the start and
+// From stays zero. to defaults to now. to is clamped to now.
+func parseTimeRange(c *fiber.Ctx, defaultFrom string) (timeRange, error) {
+	now := time.Now()
+	r := timeRange{}
+
+	from := strings.TrimSpace(c.Query("from"))
+	if from == "" {
+		from, r.defaultFrom = defaultFrom, true
+	}
+	var err error
+	if from != "" {
+		if r.From, err = parseTime(from, now); err != nil {
+			return r, fmt.Errorf("from: %w", err)
+		}
+	}
+	r.To = now
+	if to := strings.TrimSpace(c.Query("to")); to != "" {
+		if r.To, err = parseTime(to, now); err != nil {
+			return r, fmt.Errorf("to: %w", err)
+		}
+	}
+
+	if r.To.After(now) {
+		r.To = now
+	}
+	if !r.From.Before(r.To) {
+		return r, errors.New("from must be before to")
+	}
+	if !r.From.IsZero() && r.To.Sub(r.From) > maxRange {
+		return r, fmt.Errorf("range is longer than %d days", maxRange/(24*time.Hour))
+	}
+	return r, nil
+}
+
+// parseBucket sets r.Bucket from ?bucket= (a duration such as 10s, 5m or
+// 1d, or "auto") or the older ?step_ms=. Automatic buckets keep the
+// timeline within autoBucketGoal points and stay on the minute rollup for
+// ranges over 15 minutes. Explicit buckets may give up to maxPoints
+// points; when from was not given the range is shortened to fit instead.
+func (r *timeRange) parseBucket(c *fiber.Ctx, maxPoints int) error {
+	spec := strings.TrimSpace(c.Query("bucket"))
+	if spec == "" && c.Query("step_ms") != "" {
+		spec = c.Query("step_ms") + "ms"
+	}
+
+	span := r.To.Sub(r.From)
+	if spec == "" || spec == "auto" {
+		r.Bucket = autoBuckets[len(autoBuckets)-1]
+		for _, b := range autoBuckets {
+			if span/b <= autoBucketGoal && (b >= time.Minute || span <= 15*time.Minute) {
+				r.Bucket = b
+				break
+			}
+		}
+		return nil
+	}
+
+	b, err := parseSpan(spec)
+	if err != nil {
+		return fmt.Errorf("bucket: %w", err)
+	}
+	if b < minBucket || b > maxBucket {
+		return fmt.Errorf("bucket: must be between %s and %s", minBucket, maxBucket)
+	}
+	r.Bucket = b
+
+	if span/b > time.Duration(maxPoints) {
+		if !r.defaultFrom {
+			return fmt.Errorf("bucket: %s gives more than %d points over this range", b, maxPoints)
+		}
+		r.From = r.To.Add(-b * time.Duration(maxPoints))
+		span = r.To.Sub(r.From)
+	}
+	if b%time.Minute != 0 && span > maxRawRange {
+		return fmt.Errorf("bucket: sub-minute buckets are limited to %s ranges", maxRawRange)
+	}
+	return nil
+}
+
+// parseTime accepts now, today, yesterday, an offset from now such as
+// -6h, -30m or -7d, unix seconds, RFC 3339, or "2006-01-02 15:04[:05]"
+// and "2006-01-02" in the dashboard's local time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	case "yesterday":
+		y, m, d := now.Date()
+		return time.Date(y, m, d-1, 0, 0, 0, 0, now.Location()), nil
+	}
+	if rel, ok := strings.CutPrefix(strings.TrimPrefix(s, "now"), "-"); ok {
+		d, err := parseSpan(rel)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q", s)
+}
+
+// parseSpan parses a Go duration, also accepting d (days) and w (weeks).
+func parseSpan(s string) (time.Duration, error) {
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{{"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour}} {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			unit := u.unit
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// minuteBounds and hourBounds widen the range to the whole rollup rows
+// that overlap it, as unix seconds for minute >= ? AND minute <= ?.
+func (r timeRange) minuteBounds() (int64, int64) {
+	return r.From.Truncate(time.Minute).Unix(), r.To.Unix()
+}
+
+func (r timeRange) hourBounds() (int64, int64) {
+	return r.From.Truncate(time.Hour).Unix(), r.To.Unix()
+}
+
+// badRange answers 400 for an invalid range.
+func badRange(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+}
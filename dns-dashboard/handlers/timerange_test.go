@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 30, 0, 0, time.Local)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"now", now},
+		{"today", time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)},
+		{"yesterday", time.Date(2026, 3, 9, 0, 0, 0, 0, time.Local)},
+		{"-6h", now.Add(-6 * time.Hour)},
+		{"now-30m", now.Add(-30 * time.Minute)},
+		{"-7d", now.Add(-7 * 24 * time.Hour)},
+		{"-1.5d", now.Add(-36 * time.Hour)},
+		{"1767312000", time.Unix(1767312000, 0)},
+		{"2026-03-01T12:00:00Z", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"2026-03-01T12:00:00.250+02:00", time.Date(2026, 3, 1, 10, 0, 0, 250e6, time.UTC)},
+		{"2026-03-01 12:00:05", time.Date(2026, 3, 1, 12, 0, 5, 0, time.Local)},
+		{"2026-03-01 12:00", time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)},
+		{"2026-03-01T12:00", time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+	} {
+		got, err := parseTime(tc.in, now)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseTime(%q) = %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "tomorrow", "-", "-0h", "-x", "+6h", "2026-13-01", "2026-03-01 25:00"} {
+		if got, err := parseTime(bad, now); err == nil {
+			t.Errorf("parseTime(%q) = %v, want an error", bad, got)
+		}
+	}
+}
+
+func TestParseSpan(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"100ms": 100 * time.Millisecond,
+		"10s":   10 * time.Second,
+		"5m":    5 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"1d":    24 * time.Hour,
+		"0.5d":  12 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+	} {
+		if got, err := parseSpan(in); err != nil || got != want {
+			t.Errorf("parseSpan(%q) = %s, %v, want %s", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "0", "0s", "-1h", "d", "0d", "-2w", "1x", "1dd"} {
+		if got, err := parseSpan(bad); err == nil {
+			t.Errorf("parseSpan(%q) = %s, want an error", bad, got)
+		}
+	}
+}
+
+// rangeOf runs parseTimeRange and, for maxPoints > 0, parseBucket on a
+// request with query.
+func rangeOf(t *testing.T, query, defaultFrom string, maxPoints int) (timeRange, error) {
+	t.Helper()
+	var r timeRange
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if r, err = parseTimeRange(c, defaultFrom); err == nil && maxPoints > 0 {
+			err = r.parseBucket(c, maxPoints)
+		}
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest("GET", "/?"+query, nil), -1); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return r, err
+}
+
+func TestParseTimeRange(t *testing.T) {
+	before := time.Now()
+
+	r, err := rangeOf(t, "from=-1h", "today", 0)
+	if err != nil || r.defaultFrom || r.To.Before(before) || r.To.Sub(r.From) != time.Hour {
+		t.Errorf("from=-1h: %+v, %v, want the last hour up to now", r, err)
+	}
+	r, err = rangeOf(t, "", "-6h", 0)
+	if err != nil || !r.defaultFrom || r.To.Sub(r.From) != 6*time.Hour {
+		t.Errorf("default from: %+v, %v, want the last 6h", r, err)
+	}
+	// to is clamped to now.
+	r, err = rangeOf(t, "from=-1h&to="+time.Now().Add(time.Hour).Format(time.RFC3339), "today", 0)
+	if err != nil || r.To.After(time.Now()) {
+		t.Errorf("future to: %+v, %v, want it clamped to now", r, err)
+	}
+	// Open at the start when there is no default.
+	r, err = rangeOf(t, "to=-1h", "", 0)
+	if err != nil || !r.From.IsZero() || time.Since(r.To) < time.Hour {
+		t.Errorf("no from and no default: %+v, %v, want an open start", r, err)
+	}
+
+	for _, tc := range []struct{ query, defaultFrom, want string }{
+		{"from=-1h&to=-2h", "today", "before to"},
+		{"from=" + time.Now().Add(time.Hour).Format(time.RFC3339), "", "before to"},
+		{"from=-401d", "today", "longer than 400 days"},
+		{"from=-400d&to=now", "", ""},
+		{"from=nope", "today", "from: cannot parse"},
+		{"from=-1h&to=nope", "today", "to: cannot parse"},
+	} {
+		_, err := rangeOf(t, tc.query, tc.defaultFrom, 0)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: %v, want %q", tc.query, err, tc.want)
+		}
+	}
+}
+
+func TestParseBucket(t *testing.T) {
+	for _, tc := range []struct {
+		query       string
+		defaultFrom string
+		maxPoints   int
+		bucket      time.Duration
+		span        time.Duration // 0 leaves the range as given
+		err         string
+	}{
+		// Automatic: at most autoBucketGoal points, sub-minute only up to 15m.
+		{query: "from=-1s", bucket: 10 * time.Millisecond},
+		{query: "from=-10m", bucket: 5 * time.Second},
+		{query: "from=-15m&bucket=auto", bucket: 5 * time.Second},
+		{query: "from=-1h", bucket: time.Minute},
+		{query: "from=-24h", bucket: 10 * time.Minute},
+		{query: "from=-7d", bucket: time.Hour},
+		{query: "from=-400d", bucket: 24 * time.Hour},
+
+		// Explicit buckets.
+		{query: "from=-1h&bucket=1m", maxPoints: 1000, bucket: time.Minute},
+		{query: "from=-1d&bucket=1d", maxPoints: 1000, bucket: 24 * time.Hour},
+		{query: "from=-1h&step_ms=60000", maxPoints: 1000, bucket: time.Minute},
+		{query: "from=-1h&bucket=1ms", maxPoints: 1000, err: "between 10ms and 24h"},
+		{query: "from=-7d&bucket=2d", maxPoints: 1000, err: "between 10ms and 24h"},
+		{query: "from=-1h&bucket=often", maxPoints: 1000, err: "invalid duration"},
+
+		// Too many points: an explicit from is refused, a default one is
+		// moved up to fit.
+		{query: "from=-2h&bucket=1s", maxPoints: 1000, err: "more than 1000 points"},
+		{query: "bucket=1s", defaultFrom: "-2h", maxPoints: 1000, bucket: time.Second, span: 1000 * time.Second},
+		{query: "bucket=1m", defaultFrom: "-30d", maxPoints: 1000, bucket: time.Minute, span: 1000 * time.Minute},
+
+		// Sub-minute buckets scan dns_logs, so their range is capped.
+		{query: "from=-6h&bucket=10s", maxPoints: 10000, bucket: 10 * time.Second},
+		{query: "from=-12h&bucket=10s", maxPoints: 10000, err: "limited to 6h0m0s"},
+		{query: "from=-12h&bucket=90s", maxPoints: 10000, err: "limited to 6h0m0s"},
+		{query: "from=-12h&bucket=2m", maxPoints: 10000, bucket: 2 * time.Minute},
+		// Moving a default from up can bring it under the cap.
+		{query: "bucket=10s", defaultFrom: "-7d", maxPoints: 1000, bucket: 10 * time.Second, span: 10000 * time.Second},
+		{query: "bucket=30s", defaultFrom: "-7d", maxPoints: 1000, err: "limited to 6h0m0s"},
+	} {
+		maxPoints := tc.maxPoints
+		if maxPoints == 0 {
+			maxPoints = 1000
+		}
+		defaultFrom := tc.defaultFrom
+		if defaultFrom == "" {
+			defaultFrom = "today"
+		}
+		r, err := rangeOf(t, tc.query, defaultFrom, maxPoints)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: %v, want %q", tc.query, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || r.Bucket != tc.bucket {
+			t.Errorf("%s: bucket %s, %v, want %s", tc.query, r.Bucket, err, tc.bucket)
+		}
+		if tc.span != 0 && r.To.Sub(r.From) != tc.span {
+			t.Errorf("%s: range %s, want it shortened to %s", tc.query, r.To.Sub(r.From), tc.span)
+		}
+	}
+}
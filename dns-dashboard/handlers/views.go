@@ -3,13 +3,13 @@ package handlers
 import "github.com/gofiber/fiber/v2"
 
 func Dashboard(c *fiber.Ctx) error {
-	return c.Render("dashboard", fiber.Map{
-		"Title": "DNS Analytics Dashboard",
-	})
+	return c.Render("dashboard", page(c, "DNS Analytics Dashboard"))
 }
 
 func LogsPage(c *fiber.Ctx) error {
-	return c.Render("logs", fiber.Map{
-		"Title": "Query Logs",
-	})
+	return c.Render("logs", page(c, "Query Logs"))
+}
+
+func ListsPage(c *fiber.Ctx) error {
+	return c.Render("lists", page(c, "Blocklist / Allowlist"))
 }
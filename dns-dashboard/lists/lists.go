@@ -0,0 +1,302 @@
+// Package lists edits the dnsdist suffix lists (blocklist, allowlist and
+// noiselist) and applies them to the running dnsdist through its console.
+//
+// The files keep their layout: entries are added at the end and removed
+// line by line, so section comments written by hand survive. An entry may
+// carry a comment after '#'; dnsdist.conf and the collector ignore it.
+package lists
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"dns-dashboard/dnsdist"
+)
+
+// Names are the lists that can be edited, each in Dir/NAME.txt.
+var Names = []string{"blocklist", "allowlist", "noiselist"}
+
+// Config is the lists part of the dashboard environment.
+type Config struct {
+	Dir         string // DNSDIST_LISTS_DIR
+	ConsoleAddr string // DNSDIST_CONSOLE_ADDR
+	ConsoleKey  string // DNSDIST_CONSOLE_KEY, setKey() in dnsdist.conf
+}
+
+// Package state, set up by Init like db.DB. Console is nil while list
+// editing is off.
+var (
+	Dir     string
+	Console *dnsdist.Console
+
+	mu sync.Mutex // serializes edits
+)
+
+var (
+	ErrUnknownList = errors.New("unknown list")
+	ErrExists      = errors.New("entry already in list")
+	ErrNotFound    = errors.New("entry not in list")
+)
+
+// Init sets the list directory and the dnsdist console used by Apply.
+func Init(cfg Config) error {
+	console, err := dnsdist.NewConsole(cfg.ConsoleAddr, cfg.ConsoleKey)
+	if err != nil {
+		return err
+	}
+	Dir, Console = cfg.Dir, console
+	return nil
+}
+
+// Entry is one domain of a list.
+type Entry struct {
+	Domain  string `json:"domain"`            // as written, e.g. *.example.com
+	Comment string `json:"comment,omitempty"` // after '#' on the same line
+	Section string `json:"section,omitempty"` // the comment heading its block
+	Line    int    `json:"line"`
+}
+
+// List is a parsed list file.
+type List struct {
+	Name    string  `json:"name"`
+	Path    string  `json:"path"`
+	Entries []Entry `json:"entries"`
+}
+
+// Path returns the file of list name.
+func Path(name string) (string, error) {
+	if !slices.Contains(Names, name) {
+		return "", fmt.Errorf("%w %q", ErrUnknownList, name)
+	}
+	return filepath.Join(Dir, name+".txt"), nil
+}
+
+// Load reads list name. A missing file is an empty list.
+func Load(name string) (*List, error) {
+	path, err := Path(name)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &List{Name: name, Path: path, Entries: []Entry{}}
+	section := ""
+	for i, line := range lines {
+		domain, comment, isComment := parseLine(line)
+		switch {
+		case domain != "":
+			l.Entries = append(l.Entries, Entry{Domain: domain, Comment: comment, Section: section, Line: i + 1})
+		case isComment:
+			section = comment
+		default:
+			section = ""
+		}
+	}
+	return l, nil
+}
+
+// Search returns the entries whose domain or comment contains q, ignoring
+// case.
+func (l *List) Search(q string) []Entry {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return l.Entries
+	}
+	out := []Entry{}
+	for _, e := range l.Entries {
+		if strings.Contains(strings.ToLower(e.Domain), q) ||
+			strings.Contains(strings.ToLower(e.Comment), q) ||
+			strings.Contains(strings.ToLower(e.Section), q) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Add appends domain with an optional comment to list name. The domain is
+// checked with Validate and must not be in the list yet in any spelling.
+func Add(name, domain, comment string) (Entry, error) {
+	domain, err := Validate(domain)
+	if err != nil {
+		return Entry{}, err
+	}
+	comment = strings.Join(strings.Fields(comment), " ")
+	if strings.ContainsAny(comment, "\r\n") || len(comment) > 200 {
+		return Entry{}, errors.New("comment must be one line of up to 200 characters")
+	}
+	path, err := Path(name)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	lines, err := readLines(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, line := range lines {
+		if d, _, _ := parseLine(line); d != "" && Normalize(d) == Normalize(domain) {
+			return Entry{}, fmt.Errorf("%w: %s", ErrExists, d)
+		}
+	}
+	// keep new entries out of a comment block at the end, e.g. the header
+	if n := len(lines); n > 0 {
+		if _, _, isComment := parseLine(lines[n-1]); isComment {
+			lines = append(lines, "")
+		}
+	}
+	line := domain
+	if comment != "" {
+		line += "  # " + comment
+	}
+	lines = append(lines, line)
+	if err := writeLines(path, lines); err != nil {
+		return Entry{}, err
+	}
+	return Entry{Domain: domain, Comment: comment, Line: len(lines)}, nil
+}
+
+// Remove deletes every line of list name that names domain in any
+// spelling and returns the removed entries.
+func Remove(name, domain string) ([]Entry, error) {
+	path, err := Path(name)
+	if err != nil {
+		return nil, err
+	}
+	want := Normalize(domain)
+
+	mu.Lock()
+	defer mu.Unlock()
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Entry
+	var kept []string
+	for i, line := range lines {
+		if d, comment, _ := parseLine(line); d != "" && Normalize(d) == want {
+			removed = append(removed, Entry{Domain: d, Comment: comment, Line: i + 1})
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, domain)
+	}
+	return removed, writeLines(path, kept)
+}
+
+// Apply makes dnsdist reload all lists through reloadLists() in
+// dnsdist.conf and returns its summary.
+func Apply() (string, error) {
+	out, err := Console.Exec("reloadLists()")
+	if err != nil {
+		return "", fmt.Errorf("dnsdist console %s: %w", Console.Addr, err)
+	}
+	out = strings.TrimSpace(out)
+	if !strings.HasPrefix(out, "lists reloaded") {
+		return "", fmt.Errorf("dnsdist: %s", out)
+	}
+	log.Printf("dnsdist: %s", out)
+	return out, nil
+}
+
+// Validate checks a domain in the syntax of loadSuffixList in dnsdist.conf
+// (example.com, *.example.com or .example.com; each matches the domain
+// and its subdomains) and returns it lowercased without a trailing dot.
+func Validate(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	name := Normalize(domain)
+	if name == "" {
+		return "", errors.New("domain is empty")
+	}
+	if strings.HasPrefix(domain, "*") && !strings.HasPrefix(domain, "*.") {
+		return "", fmt.Errorf("%q: a wildcard must be a whole label, as in *.example.com", domain)
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("%q: longer than 253 characters", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", fmt.Errorf("%q: labels must be 1 to 63 characters", domain)
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			case r == '*':
+				return "", fmt.Errorf("%q: only a leading *. is supported", domain)
+			case r > 127:
+				return "", fmt.Errorf("%q: write internationalized names in punycode (xn--)", domain)
+			default:
+				return "", fmt.Errorf("%q: invalid character %q", domain, r)
+			}
+		}
+	}
+	return domain, nil
+}
+
+// Normalize reduces a domain to the name dnsdist matches: lowercase,
+// without *. or . in front and without a trailing dot.
+func Normalize(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(domain, "*"), "."), ".")
+}
+
+// parseLine splits a line into domain and comment; isComment is set for
+// lines holding only a comment.
+func parseLine(line string) (domain, comment string, isComment bool) {
+	domain, comment, _ = strings.Cut(line, "#")
+	domain, comment = strings.TrimSpace(domain), strings.TrimSpace(comment)
+	return domain, comment, domain == "" && strings.Contains(line, "#")
+}
+
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// writeLines replaces path atomically, keeping its mode (dnsdist and the
+// collector read it).
+func writeLines(path string, lines []string) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	data := strings.Join(lines, "\n") + "\n"
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,181 @@
+package lists
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string // want "" means invalid
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{" *.example.com ", "*.example.com"},
+		{".example.com", ".example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"", ""},
+		{".", ""},
+		{"*.", ""},
+		{"*example.com", ""},
+		{"a.*.example.com", ""},
+		{"example..com", ""},
+		{"exa mple.com", ""},
+		{"example.com # comment", ""},
+		{"bücher.example", ""},
+		{strings.Repeat("a", 64) + ".example", ""},
+	} {
+		got, err := Validate(tc.in)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("Validate(%q) = %q, want an error", tc.in, got)
+			}
+		} else if err != nil || got != tc.want {
+			t.Errorf("Validate(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	// All spellings of one suffix match the same names in dnsdist.
+	for _, in := range []string{"example.com", "*.example.com", ".example.com", "Example.Com.", " *.EXAMPLE.com. "} {
+		if got := Normalize(in); got != "example.com" {
+			t.Errorf("Normalize(%q) = %q, want example.com", in, got)
+		}
+	}
+}
+
+const testList = `# Blocklist (suffix / wildcard)
+# One domain per line.
+
+# Ads
+*.ads.example
+.tracker.example  # seen in 2026-01
+doubleclick.example#no space
+
+# Malware
+bad.example
+`
+
+func writeTestList(t *testing.T, content string) string {
+	t.Helper()
+	Dir = t.TempDir()
+	path := filepath.Join(Dir, "blocklist.txt")
+	if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	writeTestList(t, testList)
+	l, err := Load("blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Domain: "*.ads.example", Section: "Ads", Line: 5},
+		{Domain: ".tracker.example", Comment: "seen in 2026-01", Section: "Ads", Line: 6},
+		{Domain: "doubleclick.example", Comment: "no space", Section: "Ads", Line: 7},
+		{Domain: "bad.example", Section: "Malware", Line: 10},
+	}
+	if len(l.Entries) != len(want) {
+		t.Fatalf("entries %+v, want %+v", l.Entries, want)
+	}
+	for i := range want {
+		if l.Entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, l.Entries[i], want[i])
+		}
+	}
+	if got := l.Search("2026"); len(got) != 1 || got[0].Domain != ".tracker.example" {
+		t.Errorf("Search by comment = %+v", got)
+	}
+	if got := l.Search("malware"); len(got) != 1 || got[0].Domain != "bad.example" {
+		t.Errorf("Search by section = %+v", got)
+	}
+
+	if _, err := Load("../users"); !errors.Is(err, ErrUnknownList) {
+		t.Errorf("Load outside Names: %v, want ErrUnknownList", err)
+	}
+}
+
+func TestAddRemoveRoundTrip(t *testing.T) {
+	path := writeTestList(t, testList)
+
+	e, err := Add("blocklist", "*.New.Example.", "  reported\tby   soc ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Domain != "*.new.example" || e.Comment != "reported by soc" || e.Line != 11 {
+		t.Errorf("Add = %+v", e)
+	}
+	// The same suffix in another spelling is a duplicate.
+	for _, dup := range []string{"new.example", ".new.example", "*.ads.example.", "ADS.example"} {
+		if _, err := Add("blocklist", dup, ""); !errors.Is(err, ErrExists) {
+			t.Errorf("Add(%q): %v, want ErrExists", dup, err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if want := testList + "*.new.example  # reported by soc\n"; string(data) != want {
+		t.Errorf("after Add the file is\n%s\nwant\n%s", data, want)
+	}
+
+	removed, err := Remove("blocklist", "new.example")
+	if err != nil || len(removed) != 1 || removed[0].Line != 11 {
+		t.Fatalf("Remove = %+v, %v", removed, err)
+	}
+	if _, err := Remove("blocklist", "new.example"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Remove: %v, want ErrNotFound", err)
+	}
+	data, _ = os.ReadFile(path)
+	if string(data) != testList {
+		t.Errorf("after Add and Remove the file is\n%s\nwant it unchanged:\n%s", data, testList)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Errorf("file mode after rewriting: %v, %v, want 0640", fi.Mode().Perm(), err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(Dir, ".*")); len(left) != 0 {
+		t.Errorf("temporary files left behind: %q", left)
+	}
+
+	// Removing one spelling drops the commented entry too, keeping the
+	// section around it.
+	if removed, err := Remove("blocklist", "*.tracker.example"); err != nil || len(removed) != 1 || removed[0].Comment != "seen in 2026-01" {
+		t.Errorf("Remove of a commented entry = %+v, %v", removed, err)
+	}
+	l, _ := Load("blocklist")
+	if len(l.Entries) != 3 || l.Entries[1].Domain != "doubleclick.example" || l.Entries[1].Section != "Ads" {
+		t.Errorf("after removing .tracker.example: %+v", l.Entries)
+	}
+}
+
+func TestAddAfterComment(t *testing.T) {
+	// A file of only its header: the first entry must not join the header
+	// block, or it would show up in the header's section.
+	path := writeTestList(t, "# Blocklist\n# One domain per line.\n")
+	if _, err := Add("blocklist", "bad.example", ""); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if want := "# Blocklist\n# One domain per line.\n\nbad.example\n"; string(data) != want {
+		t.Errorf("file is %q, want %q", data, want)
+	}
+	l, _ := Load("blocklist")
+	if len(l.Entries) != 1 || l.Entries[0].Section != "" {
+		t.Errorf("entries %+v, want bad.example outside any section", l.Entries)
+	}
+
+	// A missing file starts empty.
+	Dir = t.TempDir()
+	if _, err := Add("allowlist", "good.example", "vendor"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(Dir, "allowlist.txt"))
+	if string(data) != "good.example  # vendor\n" {
+		t.Errorf("new file is %q", data)
+	}
+}
@@ -4,23 +4,49 @@ import (
 	"log"
 	"os"
 
+	"dns-dashboard/auth"
 	"dns-dashboard/db"
 	"dns-dashboard/handlers"
+	"dns-dashboard/lists"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/template/html/v2"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		os.Exit(runUsers(os.Args[2:]))
+	}
+
 	clickhouseDSN := getEnv("CLICKHOUSE_DSN", "tcp://127.0.0.1:9000?database=dns")
 	listenAddr := getEnv("LISTEN_ADDR", ":8080")
-	authUser := getEnv("DASHBOARD_USER", "")
-	authPass := getEnv("DASHBOARD_PASS", "")
 
-	if authUser == "" || authPass == "" {
-		log.Fatal("DASHBOARD_USER and DASHBOARD_PASS must be set")
+	if err := auth.Init(authConfig()); err != nil {
+		log.Fatalf("Failed to initialize auth: %v", err)
+	}
+	if cfg, ok := oidcConfig(); ok {
+		if err := auth.InitOIDC(cfg); err != nil {
+			log.Fatalf("OIDC: %v", err)
+		}
+		log.Printf("Single sign-on through %s enabled", cfg.Issuer)
+	} else if !auth.PasswordLogin {
+		log.Fatal("DASHBOARD_PASSWORD_LOGIN=false needs single sign-on (DASHBOARD_OIDC_ISSUER)")
+	}
+	if err := bootstrapAdmin(); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg, ok := listsConfig(); ok {
+		if cfg.ConsoleKey == "" {
+			log.Fatal("DNSDIST_CONSOLE_KEY is not set: use the setKey() value of dnsdist.conf, or set DNSDIST_LISTS_DIR= to turn list editing off")
+		}
+		if err := lists.Init(cfg); err != nil {
+			log.Fatalf("dnsdist lists: %v", err)
+		}
+		if !auth.Audit.Kept() {
+			log.Print("DASHBOARD_AUDIT_LOG is empty: list changes go to this log only and the lists page shows no history")
+		}
 	}
 
 	if err := db.InitDB(clickhouseDSN); err != nil {
@@ -34,15 +60,19 @@ func main() {
 	})
 
 	app.Use(cors.New())
-	app.Use(basicauth.New(basicauth.Config{
-		Users: map[string]string{
-			authUser: authPass,
-		},
-		Realm: "DNS Dashboard",
-	}))
+	app.Use(auth.Middleware)
 
 	// Routes
+	app.Get("/login", handlers.LoginPage)
+	app.Post("/login", handlers.Login)
+	app.Post("/logout", handlers.Logout)
+	if auth.OIDC != nil {
+		app.Get("/auth/oidc/login", handlers.OIDCLogin)
+		app.Get("/auth/oidc/callback", handlers.OIDCCallback)
+	}
 	app.Get("/", handlers.Dashboard)
+	app.Get("/api/me", handlers.ApiMe)
+	app.Post("/api/me/password", handlers.ApiChangePassword)
 	app.Get("/api/stats", handlers.ApiStats)
 	app.Get("/api/query-types", handlers.ApiQueryTypes)
 	app.Get("/api/response-codes", handlers.ApiResponseCodes)
@@ -53,6 +83,28 @@ func main() {
 	app.Get("/api/dnsdist-stats", handlers.ApiDnsdistStats)
 	app.Get("/logs", handlers.LogsPage)
 	app.Get("/api/logs", handlers.ApiLogs)
+	if lists.Console != nil {
+		app.Get("/lists", auth.Require(auth.RoleAdmin), handlers.ListsPage)
+	}
+
+	admin := app.Group("/api/admin", auth.Require(auth.RoleAdmin))
+	admin.Get("/storage", handlers.ApiAdminStorage)
+	admin.Get("/users", handlers.ApiAdminUsers)
+	admin.Post("/users", handlers.ApiAdminCreateUser)
+	admin.Patch("/users/:username", handlers.ApiAdminUpdateUser)
+	admin.Delete("/users/:username", handlers.ApiAdminDeleteUser)
+	admin.Get("/audit", handlers.ApiAdminAudit)
+	admin.Get("/tokens", handlers.ApiAdminTokens)
+	admin.Post("/tokens", handlers.ApiAdminCreateToken)
+	admin.Delete("/tokens/:id", handlers.ApiAdminRevokeToken)
+	if lists.Console != nil {
+		admin.Get("/lists", handlers.ApiAdminLists)
+		admin.Post("/lists/apply", handlers.ApiAdminListsApply)
+		admin.Get("/lists/history", handlers.ApiAdminListsHistory)
+		admin.Get("/lists/:name", handlers.ApiAdminList)
+		admin.Post("/lists/:name", handlers.ApiAdminListAdd)
+		admin.Delete("/lists/:name", handlers.ApiAdminListRemove)
+	}
 
 	log.Printf("DNS Dashboard running on %s", listenAddr)
 	log.Fatal(app.Listen(listenAddr))
@@ -1,14 +1,16 @@
 package models
 
 type DashboardStats struct {
-	TotalQueries   int64   `json:"total_queries"`
-	TodayQueries   int64   `json:"today_queries"`
-	CacheHitRatio  float64 `json:"cache_hit_ratio"`
-	AvgLatency     float64 `json:"avg_latency"`
-	UniqueClients  int64   `json:"unique_clients"`
-	UniqueDomains  int64   `json:"unique_domains"`
-	QPS            float64 `json:"qps"`
-	BlockedQueries int64   `json:"blocked_queries"`
+	TotalQueries   int64    `json:"total_queries"`
+	TodayQueries   int64    `json:"today_queries"`
+	RangeQueries   int64    `json:"range_queries"` // from..to, default today
+	CacheHitRatio  float64  `json:"cache_hit_ratio"`
+	AvgLatency     float64  `json:"avg_latency"`
+	UniqueClients  int64    `json:"unique_clients"`
+	UniqueDomains  int64    `json:"unique_domains"`
+	QPS            float64  `json:"qps"`
+	BlockedQueries int64    `json:"blocked_queries"`
+	Anonymized     []string `json:"anonymized"` // modes seen in the last hour: none, truncate, hmac
 }
 
 type QueryTypeStats struct {
@@ -38,3 +40,24 @@ type RecentQuery struct {
 	Type         string `json:"type"`
 	ResponseType string `json:"response_type"`
 }
+
+// StorageTable is a table of the dashboard database with its TTL and the
+// disk usage of its active parts.
+type StorageTable struct {
+	Table      string             `json:"table"`
+	Engine     string             `json:"engine"`
+	TTL        string             `json:"ttl"`
+	Rows       uint64             `json:"rows"`
+	Bytes      uint64             `json:"bytes_on_disk"`
+	Partitions []StoragePartition `json:"partitions"`
+}
+
+type StoragePartition struct {
+	Partition         string `json:"partition"`
+	Disk              string `json:"disk"`
+	Parts             uint64 `json:"parts"`
+	Rows              uint64 `json:"rows"`
+	Bytes             uint64 `json:"bytes_on_disk"`
+	UncompressedBytes uint64 `json:"uncompressed_bytes"`
+	ExpiresAt         string `json:"expires_at"` // when the last row's TTL passes, empty without TTL
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"dns-dashboard/auth"
+)
+
+// bootstrapAdmin creates the first admin from DASHBOARD_USER and
+// DASHBOARD_PASS (the old single-user setup) when the user file is empty.
+// With single sign-on no local user is needed.
+func bootstrapAdmin() error {
+	if auth.Users.Len() > 0 {
+		return nil
+	}
+	user, pass := getEnv("DASHBOARD_USER", ""), getEnv("DASHBOARD_PASS", "")
+	if (user == "" || pass == "") && auth.OIDC != nil {
+		return nil
+	}
+	if user == "" || pass == "" {
+		return fmt.Errorf("no dashboard users in %s; create one with '%s users add NAME admin'", auth.Users.Path, os.Args[0])
+	}
+	if err := auth.Users.Add(user, auth.RoleAdmin, pass); err != nil {
+		return fmt.Errorf("creating admin %q from DASHBOARD_USER/DASHBOARD_PASS: %w", user, err)
+	}
+	log.Printf("Created admin %q in %s from DASHBOARD_USER/DASHBOARD_PASS; these variables can be removed now", user, auth.Users.Path)
+	return nil
+}
+
+const usersUsage = `usage: %[1]s users list
+       %[1]s users add NAME viewer|analyst|admin [-password-stdin]
+       %[1]s users passwd NAME [-password-stdin]
+       %[1]s users role NAME viewer|analyst|admin
+       %[1]s users disable|enable NAME
+       %[1]s users del NAME
+
+add and passwd print a generated password unless -password-stdin is given.
+The running dashboard picks up changes within a second.
+`
+
+// runUsers implements "dns-dashboard users ...", editing the user file
+// named by DASHBOARD_USERS_FILE.
+func runUsers(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, usersUsage, os.Args[0])
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet("users "+cmd, flag.ContinueOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	// flags may follow the positional arguments
+	var pos []string
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	want := map[string]int{"list": 0, "add": 2, "passwd": 1, "role": 2, "disable": 1, "enable": 1, "del": 1}
+	n, ok := want[cmd]
+	if !ok || len(pos) != n {
+		fmt.Fprintf(os.Stderr, usersUsage, os.Args[0])
+		return 2
+	}
+
+	cfg := authConfig()
+	store, err := auth.OpenStore(cfg.UsersFile)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	audit, err := auth.OpenAuditLog(cfg.AuditLog)
+	if err != nil {
+		log.Printf("Audit log: %v", err)
+		audit = nil
+	}
+	record := func(action string, detail map[string]string) {
+		operator := "cli"
+		if u := os.Getenv("SUDO_USER"); u != "" {
+			operator += ":" + u
+		} else if u := os.Getenv("USER"); u != "" {
+			operator += ":" + u
+		}
+		audit.Record(auth.Event{User: operator, Action: action, Remote: "local", Detail: detail})
+	}
+
+	password := func() (string, bool, error) {
+		if !*passwordStdin {
+			return auth.RandomPassword(), true, nil
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+			return "", false, fmt.Errorf("reading password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), false, nil
+	}
+
+	switch cmd {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tSTATUS\tUPDATED")
+		for _, u := range store.List() {
+			status := "enabled"
+			if u.Disabled {
+				status = "disabled"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.Username, u.Role, status, u.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+		return 0
+
+	case "add":
+		role, err := auth.ParseRole(pos[1])
+		if err != nil {
+			log.Printf("%v", err)
+			return 2
+		}
+		pass, generated, err := password()
+		if err == nil {
+			err = store.Add(pos[0], role, pass)
+		}
+		if err != nil {
+			log.Printf("Adding %s failed: %v", pos[0], err)
+			return 1
+		}
+		record(auth.ActionUserCreate, map[string]string{"username": pos[0], "role": role.String()})
+		if generated {
+			fmt.Printf("Password for %s: %s\n", pos[0], pass)
+		}
+
+	case "passwd":
+		pass, generated, err := password()
+		if err == nil {
+			err = store.SetPassword(pos[0], pass)
+		}
+		if err != nil {
+			log.Printf("Changing the password of %s failed: %v", pos[0], err)
+			return 1
+		}
+		record(auth.ActionUserUpdate, map[string]string{"username": pos[0], "password": "changed"})
+		if generated {
+			fmt.Printf("Password for %s: %s\n", pos[0], pass)
+		}
+
+	case "role":
+		role, err := auth.ParseRole(pos[1])
+		if err != nil {
+			log.Printf("%v", err)
+			return 2
+		}
+		if _, err := store.Update(pos[0], func(u *auth.User) error { u.Role = role; return nil }); err != nil {
+			log.Printf("Changing the role of %s failed: %v", pos[0], err)
+			return 1
+		}
+		record(auth.ActionUserUpdate, map[string]string{"username": pos[0], "role": role.String()})
+
+	case "disable", "enable":
+		disabled := cmd == "disable"
+		if _, err := store.Update(pos[0], func(u *auth.User) error { u.Disabled = disabled; return nil }); err != nil {
+			log.Printf("Changing %s failed: %v", pos[0], err)
+			return 1
+		}
+		record(auth.ActionUserUpdate, map[string]string{"username": pos[0], "disabled": fmt.Sprint(disabled)})
+
+	case "del":
+		if err := store.Delete(pos[0]); err != nil {
+			log.Printf("Deleting %s failed: %v", pos[0], err)
+			return 1
+		}
+		record(auth.ActionUserDelete, map[string]string{"username": pos[0]})
+	}
+	return 0
+}
@@ -8,6 +8,7 @@ COLLECTOR_BIN="/usr/local/bin/dnsdist-collector"
 DASHBOARD_DIR="/opt/dns-dashboard"
 DNSDIST_CONF_SRC="./dnsdist/dnsdist.conf"
 DNSDIST_CONF_DST="/etc/dnsdist/dnsdist.conf"
+DNSDIST_CONSOLE_ENV="/etc/dns-dashboard/dnsdist-console.env"
 UNBOUND_CONF_DIR="/etc/unbound/unbound.conf.d"
 # =========================================
 
@@ -73,11 +74,30 @@ deploy_unbound_conf() {
   systemctl --no-pager -l status unbound || true
 }
 
+# ensure_console_key generates the dnsdist console key on the first install
+# and keeps it afterwards. dnsdist.conf gets it as setKey(), the dashboard
+# unit as DNSDIST_CONSOLE_KEY through its EnvironmentFile.
+ensure_console_key() {
+  if [[ ! -s "${DNSDIST_CONSOLE_ENV}" ]]; then
+    log "Generating dnsdist console key"
+    install -d -m 0750 "$(dirname "${DNSDIST_CONSOLE_ENV}")"
+    (umask 077 && printf 'DNSDIST_CONSOLE_KEY=%s\n' "$(head -c 32 /dev/urandom | base64)" > "${DNSDIST_CONSOLE_ENV}")
+  fi
+  DNSDIST_CONSOLE_KEY="$(sed -n 's/^DNSDIST_CONSOLE_KEY=//p' "${DNSDIST_CONSOLE_ENV}")"
+}
+
 deploy_dnsdist_conf() {
   log "Deploying dnsdist configuration"
-  install -m 0644 "${DNSDIST_CONF_SRC}" "${DNSDIST_CONF_DST}"
-  install -m 0644 ./dnsdist/allowlist.txt /etc/dnsdist/allowlist.txt
-  install -m 0644 ./dnsdist/blocklist.txt /etc/dnsdist/blocklist.txt
+  ensure_console_key
+  # Holds the console key: readable by dnsdist only
+  install -m 0640 -o root -g _dnsdist "${DNSDIST_CONF_SRC}" "${DNSDIST_CONF_DST}"
+  sed -i "s|@DNSDIST_CONSOLE_KEY@|${DNSDIST_CONSOLE_KEY}|" "${DNSDIST_CONF_DST}"
+  # Lists are edited on the server (dashboard "Lists" page); only seed them
+  for list in allowlist blocklist noiselist; do
+    if [[ ! -f "/etc/dnsdist/${list}.txt" ]]; then
+      install -m 0644 "./dnsdist/${list}.txt" "/etc/dnsdist/${list}.txt"
+    fi
+  done
 
   # Validate config
   dnsdist -C "${DNSDIST_CONF_DST}" --check-config
@@ -117,6 +137,19 @@ build_dashboard() {
   fi
 }
 
+# create_dashboard_admin creates the first dashboard admin with a random
+# password on new installs; existing user files are left alone.
+create_dashboard_admin() {
+  local users_file="/etc/dns-dashboard/users.json"
+  if [ -s "${users_file}" ]; then
+    return
+  fi
+  log "Creating dashboard admin"
+  DASHBOARD_USERS_FILE="${users_file}" "${DASHBOARD_DIR}/dns-dashboard" users add admin admin
+  echo "Sign in to the dashboard as admin with the password above and add users with"
+  echo "  ${DASHBOARD_DIR}/dns-dashboard users add NAME viewer|analyst|admin"
+}
+
 deploy_systemd() {
   log "Deploying systemd unit + tmpfiles"
   install -m 0644 ./systemd/dnsdist-collector.service /etc/systemd/system/dnsdist-collector.service
@@ -126,6 +159,7 @@ deploy_systemd() {
 
   # Dashboard Service
   install -m 0644 ./systemd/dns-dashboard.service /etc/systemd/system/dns-dashboard.service
+  create_dashboard_admin
 
   systemctl daemon-reload
   systemctl enable --now dnsdist-collector
@@ -135,9 +169,10 @@ deploy_systemd() {
 }
 
 init_clickhouse_schema() {
-  log "Creating ClickHouse database/table schema"
-  clickhouse-client --query "CREATE DATABASE IF NOT EXISTS dns"
-  clickhouse-client --multiquery < ./clickhouse/schema.sql
+  log "Applying ClickHouse schema migrations"
+  # Creates dns.dns_logs on new installs and upgrades existing ones
+  # (collector/migrate/migrations); applied versions are in dns.schema_migrations.
+  "${COLLECTOR_BIN}" migrate --clickhouse "${CLICKHOUSE_HTTP}"
 }
 
 health_checks() {
@@ -166,10 +201,10 @@ main() {
   ensure_tools
   install_clickhouse_repo
   install_packages
-  init_clickhouse_schema
   deploy_unbound_conf
   deploy_dnsdist_conf
   build_collector
+  init_clickhouse_schema
   build_dashboard
   deploy_systemd
   health_checks